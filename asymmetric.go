@@ -0,0 +1,1370 @@
+/*-
+ * Copyright 2014 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jose
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/ed25519"
+	josecipher "gopkg.in/square/go-jose.v2/cipher"
+	"gopkg.in/square/go-jose.v2/json"
+)
+
+// A generic RSA-based encrypter/verifier
+type rsaEncrypterVerifier struct {
+	publicKey     *rsa.PublicKey
+	rand          io.Reader // Optional random source, RandReader when nil
+	pssSaltLength int       // Optional fixed PSS salt length, any length when zero or PSSSaltLengthAuto
+}
+
+// A generic RSA-based decrypter/signer
+type rsaDecrypterSigner struct {
+	privateKey    *rsa.PrivateKey
+	rand          io.Reader // Optional random source, RandReader when nil
+	pssSaltLength int       // Optional PSS salt length, hash length when zero
+}
+
+// A generic EC-based encrypter/verifier
+type ecEncrypterVerifier struct {
+	publicKey  *ecdsa.PublicKey
+	rand       io.Reader // Optional random source, RandReader when nil
+	partyUInfo []byte    // Optional "apu" agreement parameter
+	partyVInfo []byte    // Optional "apv" agreement parameter
+}
+
+type edEncrypterVerifier struct {
+	publicKey ed25519.PublicKey
+}
+
+// A key generator for ECDH-ES
+type ecKeyGenerator struct {
+	size               int
+	algID              string
+	publicKey          *ecdsa.PublicKey
+	customDeriveECDHES CustomDeriveECDHES
+	rand               io.Reader // Optional random source, RandReader when nil
+	partyUInfo         []byte    // Optional "apu" agreement parameter
+	partyVInfo         []byte    // Optional "apv" agreement parameter
+}
+
+// An X25519-based encrypter
+type x25519Encrypter struct {
+	publicKey  *ecdh.PublicKey
+	rand       io.Reader // Optional random source, RandReader when nil
+	partyUInfo []byte    // Optional "apu" agreement parameter
+	partyVInfo []byte    // Optional "apv" agreement parameter
+}
+
+// A key generator for ECDH-ES over X25519
+type x25519KeyGenerator struct {
+	size       int
+	algID      string
+	publicKey  *ecdh.PublicKey
+	rand       io.Reader // Optional random source, RandReader when nil
+	partyUInfo []byte    // Optional "apu" agreement parameter
+	partyVInfo []byte    // Optional "apv" agreement parameter
+}
+
+// An X25519-based decrypter
+type x25519Decrypter struct {
+	privateKey *ecdh.PrivateKey
+}
+
+// A generic EC-based decrypter/signer
+type ecDecrypterSigner struct {
+	privateKey         *ecdsa.PrivateKey
+	customDeriveECDHES CustomDeriveECDHES
+	rand               io.Reader // Optional random source, RandReader when nil
+}
+
+// An ECDH-1PU encrypter, holding the sender's static private key alongside
+// the recipient's public key
+type ec1PUEncrypter struct {
+	senderKey  *ecdsa.PrivateKey
+	publicKey  *ecdsa.PublicKey
+	rand       io.Reader // Optional random source, RandReader when nil
+	partyUInfo []byte    // Optional "apu" agreement parameter
+	partyVInfo []byte    // Optional "apv" agreement parameter
+}
+
+// A key generator for ECDH-1PU
+type ec1PUKeyGenerator struct {
+	size       int
+	algID      string
+	senderKey  *ecdsa.PrivateKey
+	publicKey  *ecdsa.PublicKey
+	rand       io.Reader // Optional random source, RandReader when nil
+	partyUInfo []byte    // Optional "apu" agreement parameter
+	partyVInfo []byte    // Optional "apv" agreement parameter
+}
+
+// An ECDH-1PU decrypter, holding the recipient's private key alongside the
+// sender's static public key
+type ec1PUDecrypter struct {
+	privateKey *ecdsa.PrivateKey
+	senderKey  *ecdsa.PublicKey
+}
+
+// An ECDH-1PU encrypter over X25519
+type x25519OnePUEncrypter struct {
+	senderKey  *ecdh.PrivateKey
+	publicKey  *ecdh.PublicKey
+	rand       io.Reader // Optional random source, RandReader when nil
+	partyUInfo []byte    // Optional "apu" agreement parameter
+	partyVInfo []byte    // Optional "apv" agreement parameter
+}
+
+// A key generator for ECDH-1PU over X25519
+type x25519OnePUKeyGenerator struct {
+	size       int
+	algID      string
+	senderKey  *ecdh.PrivateKey
+	publicKey  *ecdh.PublicKey
+	rand       io.Reader // Optional random source, RandReader when nil
+	partyUInfo []byte    // Optional "apu" agreement parameter
+	partyVInfo []byte    // Optional "apv" agreement parameter
+}
+
+// An ECDH-1PU decrypter over X25519
+type x25519OnePUDecrypter struct {
+	privateKey *ecdh.PrivateKey
+	senderKey  *ecdh.PublicKey
+}
+
+type edDecrypterSigner struct {
+	privateKey ed25519.PrivateKey
+	rand       io.Reader // Optional random source, RandReader when nil
+}
+
+// newRSARecipient creates recipientKeyInfo based on the given key.
+func newRSARecipient(keyAlg KeyAlgorithm, publicKey *rsa.PublicKey) (recipientKeyInfo, error) {
+	// Verify that key management algorithm is supported by this encrypter
+	switch keyAlg {
+	case RSA1_5, RSA_OAEP, RSA_OAEP_256, RSA_OAEP_384, RSA_OAEP_512:
+	default:
+		return recipientKeyInfo{}, ErrUnsupportedAlgorithm
+	}
+
+	if publicKey == nil {
+		return recipientKeyInfo{}, errors.New("invalid public key")
+	}
+
+	return recipientKeyInfo{
+		keyAlg: keyAlg,
+		keyEncrypter: &rsaEncrypterVerifier{
+			publicKey: publicKey,
+		},
+	}, nil
+}
+
+// newRSASigner creates a recipientSigInfo based on the given key.
+func newRSASigner(sigAlg SignatureAlgorithm, privateKey *rsa.PrivateKey) (recipientSigInfo, error) {
+	// Verify that key management algorithm is supported by this encrypter
+	switch sigAlg {
+	case RS256, RS384, RS512, PS256, PS384, PS512:
+	default:
+		return recipientSigInfo{}, ErrUnsupportedAlgorithm
+	}
+
+	if privateKey == nil {
+		return recipientSigInfo{}, errors.New("invalid private key")
+	}
+
+	return recipientSigInfo{
+		sigAlg: sigAlg,
+		publicKey: staticPublicKey(&JSONWebKey{
+			Key: privateKey.Public(),
+		}),
+		signer: &rsaDecrypterSigner{
+			privateKey: privateKey,
+		},
+	}, nil
+}
+
+// defaultMinimumRSAKeySize is the smallest RSA modulus, in bits, accepted
+// when no explicit minimum has been configured.
+const defaultMinimumRSAKeySize = 2048
+
+// validateRSAKeySize rejects RSA keys whose modulus is smaller than min
+// bits, unwrapping JSON Web Keys to get at the underlying key. A min of
+// zero applies the default of 2048. Keys that are not RSA are accepted
+// unchanged.
+func validateRSAKeySize(key interface{}, min int) error {
+	if min == 0 {
+		min = defaultMinimumRSAKeySize
+	}
+
+	var publicKey *rsa.PublicKey
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		publicKey = &key.PublicKey
+	case *rsa.PublicKey:
+		publicKey = key
+	case JSONWebKey:
+		return validateRSAKeySize(key.Key, min)
+	case *JSONWebKey:
+		return validateRSAKeySize(key.Key, min)
+	default:
+		return nil
+	}
+
+	if publicKey.N.BitLen() < min {
+		return fmt.Errorf("square/go-jose: RSA key is %d bits, minimum is %d bits", publicKey.N.BitLen(), min)
+	}
+	return nil
+}
+
+func newEd25519Signer(sigAlg SignatureAlgorithm, privateKey ed25519.PrivateKey) (recipientSigInfo, error) {
+	if sigAlg != EdDSA {
+		return recipientSigInfo{}, ErrUnsupportedAlgorithm
+	}
+
+	if privateKey == nil {
+		return recipientSigInfo{}, errors.New("invalid private key")
+	}
+	return recipientSigInfo{
+		sigAlg: sigAlg,
+		publicKey: staticPublicKey(&JSONWebKey{
+			Key: privateKey.Public(),
+		}),
+		signer: &edDecrypterSigner{
+			privateKey: privateKey,
+		},
+	}, nil
+}
+
+// newECDHRecipient creates recipientKeyInfo based on the given key.
+func newECDHRecipient(keyAlg KeyAlgorithm, publicKey *ecdsa.PublicKey) (recipientKeyInfo, error) {
+	// Verify that key management algorithm is supported by this encrypter
+	switch keyAlg {
+	case ECDH_ES, ECDH_ES_A128KW, ECDH_ES_A192KW, ECDH_ES_A256KW:
+	default:
+		return recipientKeyInfo{}, ErrUnsupportedAlgorithm
+	}
+
+	if publicKey == nil || !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+		return recipientKeyInfo{}, errors.New("invalid public key")
+	}
+
+	return recipientKeyInfo{
+		keyAlg: keyAlg,
+		keyEncrypter: &ecEncrypterVerifier{
+			publicKey: publicKey,
+		},
+	}, nil
+}
+
+// newX25519Recipient creates recipientKeyInfo based on the given key.
+func newX25519Recipient(keyAlg KeyAlgorithm, publicKey *ecdh.PublicKey) (recipientKeyInfo, error) {
+	// Verify that key management algorithm is supported by this encrypter
+	switch keyAlg {
+	case ECDH_ES, ECDH_ES_A128KW, ECDH_ES_A192KW, ECDH_ES_A256KW:
+	default:
+		return recipientKeyInfo{}, ErrUnsupportedAlgorithm
+	}
+
+	if publicKey == nil || publicKey.Curve() != ecdh.X25519() {
+		return recipientKeyInfo{}, errors.New("invalid public key")
+	}
+
+	return recipientKeyInfo{
+		keyAlg: keyAlg,
+		keyEncrypter: &x25519Encrypter{
+			publicKey: publicKey,
+		},
+	}, nil
+}
+
+// newECDH1PURecipient creates recipientKeyInfo for the authenticated ECDH-1PU
+// key agreement, validating that the sender private key and recipient public
+// key are of the same family and on the same curve.
+func newECDH1PURecipient(keyAlg KeyAlgorithm, pair ECDH1PUEncryptionKey) (recipientKeyInfo, error) {
+	// Verify that key management algorithm is supported by this encrypter
+	switch keyAlg {
+	case ECDH_1PU, ECDH_1PU_A128KW, ECDH_1PU_A192KW, ECDH_1PU_A256KW:
+	default:
+		return recipientKeyInfo{}, ErrUnsupportedAlgorithm
+	}
+
+	switch publicKey := pair.RecipientKey.(type) {
+	case *ecdsa.PublicKey:
+		senderKey, ok := pair.SenderKey.(*ecdsa.PrivateKey)
+		if !ok || senderKey == nil {
+			return recipientKeyInfo{}, errors.New("square/go-jose: ECDH-1PU requires the sender's private key alongside the recipient's public key")
+		}
+		if publicKey == nil || !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+			return recipientKeyInfo{}, errors.New("square/go-jose: invalid public key")
+		}
+		if senderKey.Curve != publicKey.Curve {
+			return recipientKeyInfo{}, errors.New("square/go-jose: sender and recipient keys must be on the same curve")
+		}
+		return recipientKeyInfo{
+			keyAlg: keyAlg,
+			keyEncrypter: &ec1PUEncrypter{
+				senderKey: senderKey,
+				publicKey: publicKey,
+			},
+		}, nil
+	case *ecdh.PublicKey:
+		senderKey, ok := pair.SenderKey.(*ecdh.PrivateKey)
+		if !ok || senderKey == nil {
+			return recipientKeyInfo{}, errors.New("square/go-jose: ECDH-1PU requires the sender's private key alongside the recipient's public key")
+		}
+		if publicKey == nil || publicKey.Curve() != ecdh.X25519() || senderKey.Curve() != ecdh.X25519() {
+			return recipientKeyInfo{}, errors.New("square/go-jose: invalid public key")
+		}
+		return recipientKeyInfo{
+			keyAlg: keyAlg,
+			keyEncrypter: &x25519OnePUEncrypter{
+				senderKey: senderKey,
+				publicKey: publicKey,
+			},
+		}, nil
+	}
+
+	return recipientKeyInfo{}, ErrUnsupportedKeyType
+}
+
+// newECDH1PUDecrypter creates a key decrypter for the authenticated ECDH-1PU
+// key agreement, validating that the recipient private key and sender public
+// key are of the same family and on the same curve.
+func newECDH1PUDecrypter(pair ECDH1PUDecryptionKey) (keyDecrypter, error) {
+	switch privateKey := pair.RecipientKey.(type) {
+	case *ecdsa.PrivateKey:
+		senderKey, ok := pair.SenderKey.(*ecdsa.PublicKey)
+		if !ok || senderKey == nil {
+			return nil, errors.New("square/go-jose: ECDH-1PU requires the sender's public key alongside the recipient's private key")
+		}
+		if privateKey == nil || senderKey.Curve != privateKey.Curve || !senderKey.Curve.IsOnCurve(senderKey.X, senderKey.Y) {
+			return nil, errors.New("square/go-jose: invalid sender public key")
+		}
+		return &ec1PUDecrypter{
+			privateKey: privateKey,
+			senderKey:  senderKey,
+		}, nil
+	case *ecdh.PrivateKey:
+		senderKey, ok := pair.SenderKey.(*ecdh.PublicKey)
+		if !ok || senderKey == nil {
+			return nil, errors.New("square/go-jose: ECDH-1PU requires the sender's public key alongside the recipient's private key")
+		}
+		if privateKey == nil || privateKey.Curve() != ecdh.X25519() || senderKey.Curve() != ecdh.X25519() {
+			return nil, errors.New("square/go-jose: invalid sender public key")
+		}
+		return &x25519OnePUDecrypter{
+			privateKey: privateKey,
+			senderKey:  senderKey,
+		}, nil
+	}
+
+	return nil, ErrUnsupportedKeyType
+}
+
+// newECDSASigner creates a recipientSigInfo based on the given key.
+func newECDSASigner(sigAlg SignatureAlgorithm, privateKey *ecdsa.PrivateKey) (recipientSigInfo, error) {
+	// Verify that key management algorithm is supported by this encrypter
+	switch sigAlg {
+	case ES256, ES384, ES512, ES256K:
+	default:
+		return recipientSigInfo{}, ErrUnsupportedAlgorithm
+	}
+
+	if privateKey == nil {
+		return recipientSigInfo{}, errors.New("invalid private key")
+	}
+
+	return recipientSigInfo{
+		sigAlg: sigAlg,
+		publicKey: staticPublicKey(&JSONWebKey{
+			Key: privateKey.Public(),
+		}),
+		signer: &ecDecrypterSigner{
+			privateKey: privateKey,
+		},
+	}, nil
+}
+
+// Encrypt the given payload and update the object.
+func (ctx rsaEncrypterVerifier) encryptKey(cek []byte, alg KeyAlgorithm) (recipientInfo, error) {
+	encryptedKey, err := ctx.encrypt(cek, alg)
+	if err != nil {
+		return recipientInfo{}, err
+	}
+
+	return recipientInfo{
+		encryptedKey: encryptedKey,
+		header:       &rawHeader{},
+	}, nil
+}
+
+// Encrypt the given payload. Based on the key encryption algorithm,
+// this will either use RSA-PKCS1v1.5 or RSA-OAEP (with SHA-1, SHA-256,
+// SHA-384 or SHA-512).
+func (ctx rsaEncrypterVerifier) encrypt(cek []byte, alg KeyAlgorithm) ([]byte, error) {
+	switch alg {
+	case RSA1_5:
+		return rsa.EncryptPKCS1v15(randSource(ctx.rand), ctx.publicKey, cek)
+	case RSA_OAEP:
+		return rsa.EncryptOAEP(sha1.New(), randSource(ctx.rand), ctx.publicKey, cek, []byte{})
+	case RSA_OAEP_256:
+		return rsa.EncryptOAEP(sha256.New(), randSource(ctx.rand), ctx.publicKey, cek, []byte{})
+	case RSA_OAEP_384:
+		return rsa.EncryptOAEP(sha512.New384(), randSource(ctx.rand), ctx.publicKey, cek, []byte{})
+	case RSA_OAEP_512:
+		return rsa.EncryptOAEP(sha512.New(), randSource(ctx.rand), ctx.publicKey, cek, []byte{})
+	}
+
+	return nil, ErrUnsupportedAlgorithm
+}
+
+// Decrypt the given payload and return the content encryption key.
+func (ctx rsaDecrypterSigner) decryptKey(headers rawHeader, recipient *recipientInfo, generator keyGenerator) ([]byte, error) {
+	return ctx.decrypt(recipient.encryptedKey, headers.getAlgorithm(), generator)
+}
+
+// Decrypt the given payload. Based on the key encryption algorithm,
+// this will either use RSA-PKCS1v1.5 or RSA-OAEP (with SHA-1, SHA-256,
+// SHA-384 or SHA-512).
+func (ctx rsaDecrypterSigner) decrypt(jek []byte, alg KeyAlgorithm, generator keyGenerator) ([]byte, error) {
+	// Note: The random reader on decrypt operations is only used for blinding,
+	// so stubbing is meanlingless (hence the direct use of rand.Reader).
+	switch alg {
+	case RSA1_5:
+		defer func() {
+			// DecryptPKCS1v15SessionKey sometimes panics on an invalid payload
+			// because of an index out of bounds error, which we want to ignore.
+			// This has been fixed in Go 1.3.1 (released 2014/08/13), the recover()
+			// only exists for preventing crashes with unpatched versions.
+			// See: https://groups.google.com/forum/#!topic/golang-dev/7ihX6Y6kx9k
+			// See: https://code.google.com/p/go/source/detail?r=58ee390ff31602edb66af41ed10901ec95904d33
+			_ = recover()
+		}()
+
+		// Perform some input validation.
+		keyBytes := ctx.privateKey.PublicKey.N.BitLen() / 8
+		if keyBytes != len(jek) {
+			// Input size is incorrect, the encrypted payload should always match
+			// the size of the public modulus (e.g. using a 2048 bit key will
+			// produce 256 bytes of output). Reject this since it's invalid input.
+			return nil, ErrCryptoFailure
+		}
+
+		cek, _, err := generator.genKey()
+		if err != nil {
+			return nil, ErrCryptoFailure
+		}
+
+		// When decrypting an RSA-PKCS1v1.5 payload, we must take precautions to
+		// prevent chosen-ciphertext attacks as described in RFC 3218, "Preventing
+		// the Million Message Attack on Cryptographic Message Syntax". We are
+		// therefore deliberately ignoring errors here.
+		_ = rsa.DecryptPKCS1v15SessionKey(rand.Reader, ctx.privateKey, jek, cek)
+
+		return cek, nil
+	case RSA_OAEP:
+		// Use rand.Reader for RSA blinding
+		return rsa.DecryptOAEP(sha1.New(), rand.Reader, ctx.privateKey, jek, []byte{})
+	case RSA_OAEP_256:
+		// Use rand.Reader for RSA blinding
+		return rsa.DecryptOAEP(sha256.New(), rand.Reader, ctx.privateKey, jek, []byte{})
+	case RSA_OAEP_384:
+		// Use rand.Reader for RSA blinding
+		return rsa.DecryptOAEP(sha512.New384(), rand.Reader, ctx.privateKey, jek, []byte{})
+	case RSA_OAEP_512:
+		// Use rand.Reader for RSA blinding
+		return rsa.DecryptOAEP(sha512.New(), rand.Reader, ctx.privateKey, jek, []byte{})
+	}
+
+	return nil, ErrUnsupportedAlgorithm
+}
+
+// Sign the given payload
+func (ctx rsaDecrypterSigner) signPayload(payload []byte, alg SignatureAlgorithm) (Signature, error) {
+	var hash crypto.Hash
+
+	switch alg {
+	case RS256, PS256:
+		hash = crypto.SHA256
+	case RS384, PS384:
+		hash = crypto.SHA384
+	case RS512, PS512:
+		hash = crypto.SHA512
+	default:
+		return Signature{}, ErrUnsupportedAlgorithm
+	}
+
+	hasher := hash.New()
+
+	// According to documentation, Write() on hash never fails
+	_, _ = hasher.Write(payload)
+	hashed := hasher.Sum(nil)
+
+	var out []byte
+	var err error
+
+	switch alg {
+	case RS256, RS384, RS512:
+		out, err = rsa.SignPKCS1v15(randSource(ctx.rand), ctx.privateKey, hash, hashed)
+	case PS256, PS384, PS512:
+		saltLength := rsa.PSSSaltLengthEqualsHash
+		switch {
+		case ctx.pssSaltLength > 0:
+			saltLength = ctx.pssSaltLength
+		case ctx.pssSaltLength == PSSSaltLengthAuto:
+			saltLength = rsa.PSSSaltLengthAuto
+		}
+		out, err = rsa.SignPSS(randSource(ctx.rand), ctx.privateKey, hash, hashed, &rsa.PSSOptions{
+			SaltLength: saltLength,
+		})
+	}
+
+	if err != nil {
+		return Signature{}, err
+	}
+
+	return Signature{
+		Signature: out,
+		protected: &rawHeader{},
+	}, nil
+}
+
+// Verify the given payload
+func (ctx rsaEncrypterVerifier) verifyPayload(payload []byte, signature []byte, alg SignatureAlgorithm) error {
+	var hash crypto.Hash
+
+	switch alg {
+	case RS256, PS256:
+		hash = crypto.SHA256
+	case RS384, PS384:
+		hash = crypto.SHA384
+	case RS512, PS512:
+		hash = crypto.SHA512
+	default:
+		return ErrUnsupportedAlgorithm
+	}
+
+	hasher := hash.New()
+
+	// According to documentation, Write() on hash never fails
+	_, _ = hasher.Write(payload)
+	hashed := hasher.Sum(nil)
+
+	switch alg {
+	case RS256, RS384, RS512:
+		return rsa.VerifyPKCS1v15(ctx.publicKey, hash, hashed, signature)
+	case PS256, PS384, PS512:
+		// A nil options value accepts any salt length, the safe default
+		// for interop; a fixed length is only enforced when configured.
+		var opts *rsa.PSSOptions
+		if ctx.pssSaltLength > 0 {
+			opts = &rsa.PSSOptions{SaltLength: ctx.pssSaltLength}
+		}
+		return rsa.VerifyPSS(ctx.publicKey, hash, hashed, signature, opts)
+	}
+
+	return ErrUnsupportedAlgorithm
+}
+
+// Encrypt the given payload and update the object.
+func (ctx ecEncrypterVerifier) encryptKey(cek []byte, alg KeyAlgorithm) (recipientInfo, error) {
+	switch alg {
+	case ECDH_ES:
+		// ECDH-ES mode doesn't wrap a key, the shared secret is used directly as the key.
+		return recipientInfo{
+			header: &rawHeader{},
+		}, nil
+	case ECDH_ES_A128KW, ECDH_ES_A192KW, ECDH_ES_A256KW:
+	default:
+		return recipientInfo{}, ErrUnsupportedAlgorithm
+	}
+
+	generator := ecKeyGenerator{
+		algID:      string(alg),
+		publicKey:  ctx.publicKey,
+		rand:       ctx.rand,
+		partyUInfo: ctx.partyUInfo,
+		partyVInfo: ctx.partyVInfo,
+	}
+
+	switch alg {
+	case ECDH_ES_A128KW:
+		generator.size = 16
+	case ECDH_ES_A192KW:
+		generator.size = 24
+	case ECDH_ES_A256KW:
+		generator.size = 32
+	}
+
+	kek, header, err := generator.genKey()
+	if err != nil {
+		return recipientInfo{}, err
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return recipientInfo{}, err
+	}
+
+	jek, err := josecipher.KeyWrap(block, cek)
+	if err != nil {
+		return recipientInfo{}, err
+	}
+
+	return recipientInfo{
+		encryptedKey: jek,
+		header:       &header,
+	}, nil
+}
+
+// Get key size for EC key generator
+func (ctx ecKeyGenerator) keySize() int {
+	return ctx.size
+}
+
+// setPartyInfoHeaders writes non-empty "apu"/"apv" agreement parameters into
+// the recipient headers so the decrypt side can feed them to the Concat KDF.
+func setPartyInfoHeaders(headers rawHeader, apu, apv []byte) error {
+	if len(apu) > 0 {
+		if err := headers.set(headerAPU, newBuffer(apu)); err != nil {
+			return err
+		}
+	}
+	if len(apv) > 0 {
+		if err := headers.set(headerAPV, newBuffer(apv)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get a content encryption key for ECDH-ES
+func (ctx ecKeyGenerator) genKey() ([]byte, rawHeader, error) {
+	priv, err := ecdsa.GenerateKey(ctx.publicKey.Curve, randSource(ctx.rand))
+	if err != nil {
+		return nil, rawHeader{}, err
+	}
+
+	var out []byte
+	if ctx.customDeriveECDHES != nil {
+		out = ctx.customDeriveECDHES(ctx.algID, ctx.partyUInfo, ctx.partyVInfo, priv, ctx.publicKey, ctx.size)
+	} else {
+		out = josecipher.DeriveECDHES(ctx.algID, ctx.partyUInfo, ctx.partyVInfo, priv, ctx.publicKey, ctx.size)
+	}
+
+	b, err := json.Marshal(&JSONWebKey{
+		Key: &priv.PublicKey,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	headers := rawHeader{
+		headerEPK: makeRawMessage(b),
+	}
+
+	if err := setPartyInfoHeaders(headers, ctx.partyUInfo, ctx.partyVInfo); err != nil {
+		return nil, nil, err
+	}
+
+	return out, headers, nil
+}
+
+// Decrypt the given payload and return the content encryption key.
+func (ctx ecDecrypterSigner) decryptKey(headers rawHeader, recipient *recipientInfo, generator keyGenerator) ([]byte, error) {
+	epk, err := headers.getEPK()
+	if err != nil {
+		return nil, errors.New("square/go-jose: invalid epk header")
+	}
+	if epk == nil {
+		return nil, errors.New("square/go-jose: missing epk header")
+	}
+
+	publicKey, ok := epk.Key.(*ecdsa.PublicKey)
+	if publicKey == nil || !ok {
+		return nil, errors.New("square/go-jose: invalid epk header")
+	}
+
+	if publicKey.Curve != ctx.privateKey.Curve {
+		return nil, fmt.Errorf("square/go-jose: epk header is on curve '%s', expected '%s'",
+			publicKey.Curve.Params().Name, ctx.privateKey.Curve.Params().Name)
+	}
+
+	if !ctx.privateKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+		return nil, errors.New("square/go-jose: invalid public key in epk header")
+	}
+
+	apuData, err := headers.getAPU()
+	if err != nil {
+		return nil, errors.New("square/go-jose: invalid apu header")
+	}
+	apvData, err := headers.getAPV()
+	if err != nil {
+		return nil, errors.New("square/go-jose: invalid apv header")
+	}
+
+	var deriveKey func(algID string, size int) []byte
+
+	if ctx.customDeriveECDHES != nil {
+		deriveKey = func(algID string, size int) []byte {
+			return ctx.customDeriveECDHES(algID, apuData.bytes(), apvData.bytes(), ctx.privateKey, publicKey, size)
+		}
+	} else {
+		deriveKey = func(algID string, size int) []byte {
+			return josecipher.DeriveECDHES(algID, apuData.bytes(), apvData.bytes(), ctx.privateKey, publicKey, size)
+		}
+	}
+
+	var keySize int
+
+	algorithm := headers.getAlgorithm()
+	switch algorithm {
+	case ECDH_ES:
+		// ECDH-ES uses direct key agreement, no key unwrapping necessary.
+		return deriveKey(string(headers.getEncryption()), generator.keySize()), nil
+	case ECDH_ES_A128KW:
+		keySize = 16
+	case ECDH_ES_A192KW:
+		keySize = 24
+	case ECDH_ES_A256KW:
+		keySize = 32
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	key := deriveKey(string(algorithm), keySize)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return josecipher.KeyUnwrap(block, recipient.encryptedKey)
+}
+
+// Encrypt the given payload and update the object.
+func (ctx ec1PUEncrypter) encryptKey(cek []byte, alg KeyAlgorithm) (recipientInfo, error) {
+	switch alg {
+	case ECDH_1PU:
+		// ECDH-1PU mode doesn't wrap a key, the shared secret is used directly as the key.
+		return recipientInfo{
+			header: &rawHeader{},
+		}, nil
+	case ECDH_1PU_A128KW, ECDH_1PU_A192KW, ECDH_1PU_A256KW:
+	default:
+		return recipientInfo{}, ErrUnsupportedAlgorithm
+	}
+
+	generator := ec1PUKeyGenerator{
+		algID:      string(alg),
+		senderKey:  ctx.senderKey,
+		publicKey:  ctx.publicKey,
+		rand:       ctx.rand,
+		partyUInfo: ctx.partyUInfo,
+		partyVInfo: ctx.partyVInfo,
+	}
+
+	switch alg {
+	case ECDH_1PU_A128KW:
+		generator.size = 16
+	case ECDH_1PU_A192KW:
+		generator.size = 24
+	case ECDH_1PU_A256KW:
+		generator.size = 32
+	}
+
+	kek, header, err := generator.genKey()
+	if err != nil {
+		return recipientInfo{}, err
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return recipientInfo{}, err
+	}
+
+	jek, err := josecipher.KeyWrap(block, cek)
+	if err != nil {
+		return recipientInfo{}, err
+	}
+
+	return recipientInfo{
+		encryptedKey: jek,
+		header:       &header,
+	}, nil
+}
+
+// Get key size for ECDH-1PU key generator
+func (ctx ec1PUKeyGenerator) keySize() int {
+	return ctx.size
+}
+
+// Get a content encryption key for ECDH-1PU
+func (ctx ec1PUKeyGenerator) genKey() ([]byte, rawHeader, error) {
+	priv, err := ecdsa.GenerateKey(ctx.publicKey.Curve, randSource(ctx.rand))
+	if err != nil {
+		return nil, rawHeader{}, err
+	}
+
+	out := josecipher.DeriveECDH1PU(ctx.algID, ctx.partyUInfo, ctx.partyVInfo, priv, ctx.publicKey, ctx.senderKey, ctx.publicKey, ctx.size)
+
+	b, err := json.Marshal(&JSONWebKey{
+		Key: &priv.PublicKey,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	headers := rawHeader{
+		headerEPK: makeRawMessage(b),
+	}
+
+	if err := setPartyInfoHeaders(headers, ctx.partyUInfo, ctx.partyVInfo); err != nil {
+		return nil, nil, err
+	}
+
+	return out, headers, nil
+}
+
+// Decrypt the given payload and return the content encryption key.
+func (ctx ec1PUDecrypter) decryptKey(headers rawHeader, recipient *recipientInfo, generator keyGenerator) ([]byte, error) {
+	epk, err := headers.getEPK()
+	if err != nil {
+		return nil, errors.New("square/go-jose: invalid epk header")
+	}
+	if epk == nil {
+		return nil, errors.New("square/go-jose: missing epk header")
+	}
+
+	publicKey, ok := epk.Key.(*ecdsa.PublicKey)
+	if publicKey == nil || !ok {
+		return nil, errors.New("square/go-jose: invalid epk header")
+	}
+
+	if publicKey.Curve != ctx.privateKey.Curve {
+		return nil, fmt.Errorf("square/go-jose: epk header is on curve '%s', expected '%s'",
+			publicKey.Curve.Params().Name, ctx.privateKey.Curve.Params().Name)
+	}
+
+	if !ctx.privateKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+		return nil, errors.New("square/go-jose: invalid public key in epk header")
+	}
+
+	apuData, err := headers.getAPU()
+	if err != nil {
+		return nil, errors.New("square/go-jose: invalid apu header")
+	}
+	apvData, err := headers.getAPV()
+	if err != nil {
+		return nil, errors.New("square/go-jose: invalid apv header")
+	}
+
+	deriveKey := func(algID string, size int) []byte {
+		return josecipher.DeriveECDH1PU(algID, apuData.bytes(), apvData.bytes(), ctx.privateKey, publicKey, ctx.privateKey, ctx.senderKey, size)
+	}
+
+	var keySize int
+
+	algorithm := headers.getAlgorithm()
+	switch algorithm {
+	case ECDH_1PU:
+		// ECDH-1PU uses direct key agreement, no key unwrapping necessary.
+		return deriveKey(string(headers.getEncryption()), generator.keySize()), nil
+	case ECDH_1PU_A128KW:
+		keySize = 16
+	case ECDH_1PU_A192KW:
+		keySize = 24
+	case ECDH_1PU_A256KW:
+		keySize = 32
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	// draft-madden-jose-ecdh-1pu-04 §2.1: the key-wrapping modes must only
+	// be used with AES_CBC_HMAC_SHA2 content encryption, which folds the
+	// derived key into the content authentication tag.
+	if !isCBCHMACEncryption(headers.getEncryption()) {
+		return nil, errors.New("square/go-jose: ECDH-1PU key wrapping requires AES_CBC_HMAC_SHA2 content encryption")
+	}
+
+	key := deriveKey(string(algorithm), keySize)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return josecipher.KeyUnwrap(block, recipient.encryptedKey)
+}
+
+// Encrypt the given payload and update the object.
+func (ctx x25519OnePUEncrypter) encryptKey(cek []byte, alg KeyAlgorithm) (recipientInfo, error) {
+	switch alg {
+	case ECDH_1PU:
+		// ECDH-1PU mode doesn't wrap a key, the shared secret is used directly as the key.
+		return recipientInfo{
+			header: &rawHeader{},
+		}, nil
+	case ECDH_1PU_A128KW, ECDH_1PU_A192KW, ECDH_1PU_A256KW:
+	default:
+		return recipientInfo{}, ErrUnsupportedAlgorithm
+	}
+
+	generator := x25519OnePUKeyGenerator{
+		algID:      string(alg),
+		senderKey:  ctx.senderKey,
+		publicKey:  ctx.publicKey,
+		rand:       ctx.rand,
+		partyUInfo: ctx.partyUInfo,
+		partyVInfo: ctx.partyVInfo,
+	}
+
+	switch alg {
+	case ECDH_1PU_A128KW:
+		generator.size = 16
+	case ECDH_1PU_A192KW:
+		generator.size = 24
+	case ECDH_1PU_A256KW:
+		generator.size = 32
+	}
+
+	kek, header, err := generator.genKey()
+	if err != nil {
+		return recipientInfo{}, err
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return recipientInfo{}, err
+	}
+
+	jek, err := josecipher.KeyWrap(block, cek)
+	if err != nil {
+		return recipientInfo{}, err
+	}
+
+	return recipientInfo{
+		encryptedKey: jek,
+		header:       &header,
+	}, nil
+}
+
+// Get key size for ECDH-1PU over X25519 key generator
+func (ctx x25519OnePUKeyGenerator) keySize() int {
+	return ctx.size
+}
+
+// Get a content encryption key for ECDH-1PU over X25519
+func (ctx x25519OnePUKeyGenerator) genKey() ([]byte, rawHeader, error) {
+	priv, err := ecdh.X25519().GenerateKey(randSource(ctx.rand))
+	if err != nil {
+		return nil, rawHeader{}, err
+	}
+
+	out, err := josecipher.DeriveECDH1PUX25519(ctx.algID, ctx.partyUInfo, ctx.partyVInfo, priv, ctx.publicKey, ctx.senderKey, ctx.publicKey, ctx.size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b, err := json.Marshal(&JSONWebKey{
+		Key: priv.PublicKey(),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	headers := rawHeader{
+		headerEPK: makeRawMessage(b),
+	}
+
+	if err := setPartyInfoHeaders(headers, ctx.partyUInfo, ctx.partyVInfo); err != nil {
+		return nil, nil, err
+	}
+
+	return out, headers, nil
+}
+
+// Decrypt the given payload and return the content encryption key.
+func (ctx x25519OnePUDecrypter) decryptKey(headers rawHeader, recipient *recipientInfo, generator keyGenerator) ([]byte, error) {
+	epk, err := headers.getEPK()
+	if err != nil {
+		return nil, errors.New("square/go-jose: invalid epk header")
+	}
+	if epk == nil {
+		return nil, errors.New("square/go-jose: missing epk header")
+	}
+
+	publicKey, ok := epk.Key.(*ecdh.PublicKey)
+	if publicKey == nil || !ok || publicKey.Curve() != ecdh.X25519() {
+		return nil, errors.New("square/go-jose: invalid epk header")
+	}
+
+	apuData, err := headers.getAPU()
+	if err != nil {
+		return nil, errors.New("square/go-jose: invalid apu header")
+	}
+	apvData, err := headers.getAPV()
+	if err != nil {
+		return nil, errors.New("square/go-jose: invalid apv header")
+	}
+
+	deriveKey := func(algID string, size int) ([]byte, error) {
+		return josecipher.DeriveECDH1PUX25519(algID, apuData.bytes(), apvData.bytes(), ctx.privateKey, publicKey, ctx.privateKey, ctx.senderKey, size)
+	}
+
+	var keySize int
+
+	algorithm := headers.getAlgorithm()
+	switch algorithm {
+	case ECDH_1PU:
+		// ECDH-1PU uses direct key agreement, no key unwrapping necessary.
+		return deriveKey(string(headers.getEncryption()), generator.keySize())
+	case ECDH_1PU_A128KW:
+		keySize = 16
+	case ECDH_1PU_A192KW:
+		keySize = 24
+	case ECDH_1PU_A256KW:
+		keySize = 32
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	// draft-madden-jose-ecdh-1pu-04 §2.1: the key-wrapping modes must only
+	// be used with AES_CBC_HMAC_SHA2 content encryption, which folds the
+	// derived key into the content authentication tag.
+	if !isCBCHMACEncryption(headers.getEncryption()) {
+		return nil, errors.New("square/go-jose: ECDH-1PU key wrapping requires AES_CBC_HMAC_SHA2 content encryption")
+	}
+
+	key, err := deriveKey(string(algorithm), keySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return josecipher.KeyUnwrap(block, recipient.encryptedKey)
+}
+
+// Encrypt the given payload and update the object.
+func (ctx x25519Encrypter) encryptKey(cek []byte, alg KeyAlgorithm) (recipientInfo, error) {
+	switch alg {
+	case ECDH_ES:
+		// ECDH-ES mode doesn't wrap a key, the shared secret is used directly as the key.
+		return recipientInfo{
+			header: &rawHeader{},
+		}, nil
+	case ECDH_ES_A128KW, ECDH_ES_A192KW, ECDH_ES_A256KW:
+	default:
+		return recipientInfo{}, ErrUnsupportedAlgorithm
+	}
+
+	generator := x25519KeyGenerator{
+		algID:      string(alg),
+		publicKey:  ctx.publicKey,
+		rand:       ctx.rand,
+		partyUInfo: ctx.partyUInfo,
+		partyVInfo: ctx.partyVInfo,
+	}
+
+	switch alg {
+	case ECDH_ES_A128KW:
+		generator.size = 16
+	case ECDH_ES_A192KW:
+		generator.size = 24
+	case ECDH_ES_A256KW:
+		generator.size = 32
+	}
+
+	kek, header, err := generator.genKey()
+	if err != nil {
+		return recipientInfo{}, err
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return recipientInfo{}, err
+	}
+
+	jek, err := josecipher.KeyWrap(block, cek)
+	if err != nil {
+		return recipientInfo{}, err
+	}
+
+	return recipientInfo{
+		encryptedKey: jek,
+		header:       &header,
+	}, nil
+}
+
+// Get key size for X25519 key generator
+func (ctx x25519KeyGenerator) keySize() int {
+	return ctx.size
+}
+
+// Get a content encryption key for ECDH-ES over X25519
+func (ctx x25519KeyGenerator) genKey() ([]byte, rawHeader, error) {
+	priv, err := ecdh.X25519().GenerateKey(randSource(ctx.rand))
+	if err != nil {
+		return nil, rawHeader{}, err
+	}
+
+	out, err := josecipher.DeriveECDHESX25519(ctx.algID, ctx.partyUInfo, ctx.partyVInfo, priv, ctx.publicKey, ctx.size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b, err := json.Marshal(&JSONWebKey{
+		Key: priv.PublicKey(),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	headers := rawHeader{
+		headerEPK: makeRawMessage(b),
+	}
+
+	if err := setPartyInfoHeaders(headers, ctx.partyUInfo, ctx.partyVInfo); err != nil {
+		return nil, nil, err
+	}
+
+	return out, headers, nil
+}
+
+// Decrypt the given payload and return the content encryption key.
+func (ctx x25519Decrypter) decryptKey(headers rawHeader, recipient *recipientInfo, generator keyGenerator) ([]byte, error) {
+	epk, err := headers.getEPK()
+	if err != nil {
+		return nil, errors.New("square/go-jose: invalid epk header")
+	}
+	if epk == nil {
+		return nil, errors.New("square/go-jose: missing epk header")
+	}
+
+	publicKey, ok := epk.Key.(*ecdh.PublicKey)
+	if publicKey == nil || !ok || publicKey.Curve() != ecdh.X25519() {
+		return nil, errors.New("square/go-jose: invalid epk header")
+	}
+
+	apuData, err := headers.getAPU()
+	if err != nil {
+		return nil, errors.New("square/go-jose: invalid apu header")
+	}
+	apvData, err := headers.getAPV()
+	if err != nil {
+		return nil, errors.New("square/go-jose: invalid apv header")
+	}
+
+	deriveKey := func(algID string, size int) ([]byte, error) {
+		return josecipher.DeriveECDHESX25519(algID, apuData.bytes(), apvData.bytes(), ctx.privateKey, publicKey, size)
+	}
+
+	var keySize int
+
+	algorithm := headers.getAlgorithm()
+	switch algorithm {
+	case ECDH_ES:
+		// ECDH-ES uses direct key agreement, no key unwrapping necessary.
+		return deriveKey(string(headers.getEncryption()), generator.keySize())
+	case ECDH_ES_A128KW:
+		keySize = 16
+	case ECDH_ES_A192KW:
+		keySize = 24
+	case ECDH_ES_A256KW:
+		keySize = 32
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	key, err := deriveKey(string(algorithm), keySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return josecipher.KeyUnwrap(block, recipient.encryptedKey)
+}
+
+func (ctx edDecrypterSigner) signPayload(payload []byte, alg SignatureAlgorithm) (Signature, error) {
+	if alg != EdDSA {
+		return Signature{}, ErrUnsupportedAlgorithm
+	}
+
+	sig, err := ctx.privateKey.Sign(randSource(ctx.rand), payload, crypto.Hash(0))
+	if err != nil {
+		return Signature{}, err
+	}
+
+	return Signature{
+		Signature: sig,
+		protected: &rawHeader{},
+	}, nil
+}
+
+func (ctx edEncrypterVerifier) verifyPayload(payload []byte, signature []byte, alg SignatureAlgorithm) error {
+	if alg != EdDSA {
+		return ErrUnsupportedAlgorithm
+	}
+	ok := ed25519.Verify(ctx.publicKey, payload, signature)
+	if !ok {
+		return errors.New("square/go-jose: ed25519 signature failed to verify")
+	}
+	return nil
+}
+
+// Sign the given payload
+func (ctx ecDecrypterSigner) signPayload(payload []byte, alg SignatureAlgorithm) (Signature, error) {
+	var expectedBitSize int
+	var expectedCurve elliptic.Curve
+	var hash crypto.Hash
+
+	switch alg {
+	case ES256:
+		expectedBitSize = 256
+		expectedCurve = elliptic.P256()
+		hash = crypto.SHA256
+	case ES384:
+		expectedBitSize = 384
+		expectedCurve = elliptic.P384()
+		hash = crypto.SHA384
+	case ES512:
+		expectedBitSize = 521
+		expectedCurve = elliptic.P521()
+		hash = crypto.SHA512
+	case ES256K:
+		expectedBitSize = 256
+		expectedCurve = ellipticSecp256k1()
+		hash = crypto.SHA256
+	}
+
+	curveBits := ctx.privateKey.Curve.Params().BitSize
+	if expectedBitSize != curveBits {
+		return Signature{}, fmt.Errorf("square/go-jose: expected %d bit key, got %d bits instead: %w", expectedBitSize, curveBits, ErrInvalidKeySize)
+	}
+
+	// The bit size alone no longer identifies a curve: P-256 and secp256k1
+	// are both 256 bits, so check curve identity to stop ES256 signing with
+	// a secp256k1 key and vice versa.
+	if ctx.privateKey.Curve != expectedCurve {
+		return Signature{}, fmt.Errorf("square/go-jose: alg '%s' requires curve %s, got %s",
+			alg, expectedCurve.Params().Name, ctx.privateKey.Curve.Params().Name)
+	}
+
+	hasher := hash.New()
+
+	// According to documentation, Write() on hash never fails
+	_, _ = hasher.Write(payload)
+	hashed := hasher.Sum(nil)
+
+	r, s, err := ecdsa.Sign(randSource(ctx.rand), ctx.privateKey, hashed)
+	if err != nil {
+		return Signature{}, err
+	}
+
+	keyBytes := curveBits / 8
+	if curveBits%8 > 0 {
+		keyBytes++
+	}
+
+	// We serialize the outputs (r and s) into big-endian byte arrays and pad
+	// them with zeros on the left to make sure the sizes work out. Both arrays
+	// must be keyBytes long, and the output must be 2*keyBytes long.
+	rBytes := r.Bytes()
+	rBytesPadded := make([]byte, keyBytes)
+	copy(rBytesPadded[keyBytes-len(rBytes):], rBytes)
+
+	sBytes := s.Bytes()
+	sBytesPadded := make([]byte, keyBytes)
+	copy(sBytesPadded[keyBytes-len(sBytes):], sBytes)
+
+	out := append(rBytesPadded, sBytesPadded...)
+
+	return Signature{
+		Signature: out,
+		protected: &rawHeader{},
+	}, nil
+}
+
+// Verify the given payload
+func (ctx ecEncrypterVerifier) verifyPayload(payload []byte, signature []byte, alg SignatureAlgorithm) error {
+	var keySize int
+	var expectedCurve elliptic.Curve
+	var hash crypto.Hash
+
+	switch alg {
+	case ES256:
+		keySize = 32
+		expectedCurve = elliptic.P256()
+		hash = crypto.SHA256
+	case ES384:
+		keySize = 48
+		expectedCurve = elliptic.P384()
+		hash = crypto.SHA384
+	case ES512:
+		keySize = 66
+		expectedCurve = elliptic.P521()
+		hash = crypto.SHA512
+	case ES256K:
+		keySize = 32
+		expectedCurve = ellipticSecp256k1()
+		hash = crypto.SHA256
+	default:
+		return ErrUnsupportedAlgorithm
+	}
+
+	// As on the signing side, check curve identity rather than relying on
+	// the key size: P-256 and secp256k1 are both 256 bits.
+	if ctx.publicKey.Curve != expectedCurve {
+		return fmt.Errorf("square/go-jose: alg '%s' requires curve %s, got %s",
+			alg, expectedCurve.Params().Name, ctx.publicKey.Curve.Params().Name)
+	}
+
+	if len(signature) != 2*keySize {
+		return fmt.Errorf("square/go-jose: invalid signature size, have %d bytes, wanted %d", len(signature), 2*keySize)
+	}
+
+	hasher := hash.New()
+
+	// According to documentation, Write() on hash never fails
+	_, _ = hasher.Write(payload)
+	hashed := hasher.Sum(nil)
+
+	r := big.NewInt(0).SetBytes(signature[:keySize])
+	s := big.NewInt(0).SetBytes(signature[keySize:])
+
+	match := ecdsa.Verify(ctx.publicKey, hashed, r, s)
+	if !match {
+		return errors.New("square/go-jose: ecdsa signature failed to verify")
+	}
+
+	return nil
+}
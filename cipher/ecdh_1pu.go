@@ -0,0 +1,57 @@
+/*-
+ * Copyright 2014 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package josecipher
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+)
+
+// DeriveECDH1PU derives a shared encryption key using the one-pass unified
+// model ECDH/ConcatKDF described in draft-madden-jose-ecdh-1pu. The KDF runs
+// over the concatenation Ze || Zs of an ephemeral and a static shared secret,
+// so the derived key authenticates the sender to the recipient. The ephemeral
+// and static arguments each pair a local private key with the remote party's
+// public key: the sender derives Ze from its ephemeral key and Zs from its
+// static key, both against the recipient's public key, while the recipient
+// derives both from its static private key, against the "epk" header key and
+// the sender's static public key respectively. It is an error to call this
+// function with keys that are not all on the same curve. Output size may be
+// at most 1<<16 bytes (64 KiB).
+func DeriveECDH1PU(alg string, apuData, apvData []byte, ephemeralPriv *ecdsa.PrivateKey, ephemeralPub *ecdsa.PublicKey, staticPriv *ecdsa.PrivateKey, staticPub *ecdsa.PublicKey, size int) []byte {
+	ze := deriveZ(ephemeralPriv, ephemeralPub)
+	zs := deriveZ(staticPriv, staticPub)
+	return deriveECDHES(alg, apuData, apvData, append(ze, zs...), size)
+}
+
+// DeriveECDH1PUX25519 derives a shared encryption key like DeriveECDH1PU, but
+// over X25519 keys. It returns an error if the keys are not all on the X25519
+// curve, or if a public key is a low-order point for which no shared secret
+// can be computed; the recipient derives against the attacker-controlled "epk"
+// header key, so these conditions must not panic. Output size may be at most
+// 1<<16 bytes (64 KiB).
+func DeriveECDH1PUX25519(alg string, apuData, apvData []byte, ephemeralPriv *ecdh.PrivateKey, ephemeralPub *ecdh.PublicKey, staticPriv *ecdh.PrivateKey, staticPub *ecdh.PublicKey, size int) ([]byte, error) {
+	ze, err := deriveZX25519(ephemeralPriv, ephemeralPub)
+	if err != nil {
+		return nil, err
+	}
+	zs, err := deriveZX25519(staticPriv, staticPub)
+	if err != nil {
+		return nil, err
+	}
+	return deriveECDHES(alg, apuData, apvData, append(ze, zs...), size), nil
+}
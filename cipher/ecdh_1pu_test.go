@@ -0,0 +1,127 @@
+/*-
+ * Copyright 2014 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package josecipher
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+)
+
+// x25519KeyFrom builds an X25519 private key from a base64url-encoded scalar
+// and checks its public key against the base64url-encoded expected value.
+func x25519KeyFrom(t *testing.T, d, x string) *ecdh.PrivateKey {
+	t.Helper()
+
+	scalar, err := base64.RawURLEncoding.DecodeString(d)
+	if err != nil {
+		t.Fatal("invalid test data:", err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(scalar)
+	if err != nil {
+		t.Fatal("invalid test key:", err)
+	}
+	if got := base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()); got != x {
+		t.Fatalf("test key public part mismatch: got %s, expected %s", got, x)
+	}
+	return key
+}
+
+// Example keys from draft-madden-jose-ecdh-1pu-04, Appendix A: Alice sends to
+// Bob using ECDH-1PU with X25519 and A256GCM in direct key agreement mode.
+func TestVectorECDH1PU(t *testing.T) {
+	aliceEphemeral := x25519KeyFrom(t,
+		"x8EVZH4Fwk673_mUujnliJoSrLz0zYzzCWp5GUX2fc8",
+		"k9of_cpAajy0poW5gaixXGs9nHkwg1AFqUAFa39dyBc")
+	aliceStatic := x25519KeyFrom(t,
+		"i9KuFhSzEBsiv3PKVL5115OCdsqQai5nj_Flzfkw5jU",
+		"Knbm_BcdQr7WIoz-uqit9M0wbcfEr6y-9UfIZ8QnBD4")
+	bobStatic := x25519KeyFrom(t,
+		"1gDirl_r_Y3-qUa3WXHgEXrrEHngWThU3c9zj9A2uBg",
+		"BT7aR0ItXfeDAldeeOlXL_wXqp-j5FltT0vRSG16kRw")
+
+	apuData := []byte("Alice")
+	apvData := []byte("Bob")
+
+	expected := []byte{
+		0x18, 0x93, 0xdd, 0xff, 0x90, 0x91, 0xe1, 0x3e,
+		0xaa, 0x72, 0xa9, 0x38, 0x31, 0x13, 0xca, 0x48,
+		0xec, 0xdb, 0x9d, 0xfa, 0x18, 0xf4, 0x2b, 0x27,
+		0xc7, 0x27, 0x49, 0x1a, 0x1e, 0x89, 0xd0, 0xb4}
+
+	// Alice derives from her ephemeral and static keys against Bob's static
+	// key; Bob derives from his static key against the "epk" and Alice's
+	// static key. Both must land on the same CEK.
+	senderSide, err := DeriveECDH1PUX25519("A256GCM", apuData, apvData,
+		aliceEphemeral, bobStatic.PublicKey(), aliceStatic, bobStatic.PublicKey(), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipientSide, err := DeriveECDH1PUX25519("A256GCM", apuData, apvData,
+		bobStatic, aliceEphemeral.PublicKey(), bobStatic, aliceStatic.PublicKey(), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(senderSide, expected) {
+		t.Errorf("sender-side key derivation gave: %x", senderSide)
+	}
+	if !bytes.Equal(recipientSide, expected) {
+		t.Errorf("recipient-side key derivation gave: %x", recipientSide)
+	}
+}
+
+// Both sides of an ECDH-1PU exchange over a NIST curve must agree on the
+// derived key, and swapping the sender's static key for another must change
+// it.
+func TestECDH1PUAgreement(t *testing.T) {
+	ephemeralKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	senderKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	senderSide := DeriveECDH1PU("ECDH-1PU+A128KW", nil, nil,
+		ephemeralKey, &recipientKey.PublicKey, senderKey, &recipientKey.PublicKey, 16)
+	recipientSide := DeriveECDH1PU("ECDH-1PU+A128KW", nil, nil,
+		recipientKey, &ephemeralKey.PublicKey, recipientKey, &senderKey.PublicKey, 16)
+
+	if !bytes.Equal(senderSide, recipientSide) {
+		t.Error("sender and recipient disagree on the derived key")
+	}
+
+	otherSender, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	impersonated := DeriveECDH1PU("ECDH-1PU+A128KW", nil, nil,
+		ephemeralKey, &recipientKey.PublicKey, otherSender, &recipientKey.PublicKey, 16)
+	if bytes.Equal(senderSide, impersonated) {
+		t.Error("derived key must depend on the sender's static key")
+	}
+}
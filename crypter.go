@@ -0,0 +1,1537 @@
+/*-
+ * Copyright 2014 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jose
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+
+	josecipher "gopkg.in/square/go-jose.v2/cipher"
+	"gopkg.in/square/go-jose.v2/json"
+)
+
+// Encrypter represents an encrypter which produces an encrypted JWE object.
+//
+// Encrypters returned by NewEncrypter and NewMultiEncrypter are safe for
+// concurrent use by multiple goroutines: no per-message state is written
+// to the encrypter, and the internal nonce-reuse guard is mutex-protected.
+type Encrypter interface {
+	Encrypt(plaintext []byte) (*JSONWebEncryption, error)
+	// EncryptContext is like Encrypt, but respects context cancellation
+	// before any key encryption work begins.
+	EncryptContext(ctx context.Context, plaintext []byte) (*JSONWebEncryption, error)
+	EncryptWithAuthData(plaintext []byte, aad []byte) (*JSONWebEncryption, error)
+	// EncryptWithAuthDataContext is like EncryptWithAuthData, but respects
+	// context cancellation before any key encryption work begins.
+	EncryptWithAuthDataContext(ctx context.Context, plaintext []byte, aad []byte) (*JSONWebEncryption, error)
+	// Clone returns an independent copy of this encrypter which shares no
+	// mutable state with the original; both may be used freely afterwards.
+	Clone() Encrypter
+	Options() EncrypterOptions
+}
+
+// CustomDeriveECDHES allows for a custom implementation of deriving the ECDHES cek value
+type CustomDeriveECDHES func(alg string, apuData, apvData []byte, priv *ecdsa.PrivateKey, pub *ecdsa.PublicKey, size int) []byte
+
+// A generic content cipher
+type contentCipher interface {
+	keySize() int
+	encrypt(cek []byte, aad, plaintext []byte) (*aeadParts, error)
+	decrypt(cek []byte, aad []byte, parts *aeadParts) ([]byte, error)
+}
+
+// A key generator (for generating/getting a CEK)
+type keyGenerator interface {
+	keySize() int
+	genKey() ([]byte, rawHeader, error)
+}
+
+// A generic key encrypter
+type keyEncrypter interface {
+	encryptKey(cek []byte, alg KeyAlgorithm) (recipientInfo, error) // Encrypt a key
+}
+
+// A generic key decrypter
+type keyDecrypter interface {
+	decryptKey(headers rawHeader, recipient *recipientInfo, generator keyGenerator) ([]byte, error) // Decrypt a key
+}
+
+// keyDecrypterContext is implemented by key decrypters that can propagate
+// cancellation, such as opaque decrypters backed by network calls.
+type keyDecrypterContext interface {
+	decryptKeyContext(ctx context.Context, headers rawHeader, recipient *recipientInfo, generator keyGenerator) ([]byte, error)
+}
+
+// decryptKeyWithContext decrypts through a context-aware key decrypter when
+// available, otherwise checks the context for cancellation before decrypting.
+func decryptKeyWithContext(ctx context.Context, decrypter keyDecrypter, headers rawHeader, recipient *recipientInfo, generator keyGenerator) ([]byte, error) {
+	if ctxDecrypter, ok := decrypter.(keyDecrypterContext); ok {
+		return ctxDecrypter.decryptKeyContext(ctx, headers, recipient, generator)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return decrypter.decryptKey(headers, recipient, generator)
+}
+
+// isGCMEncryption reports whether the given content encryption algorithm is
+// an AES-GCM mode.
+func isGCMEncryption(enc ContentEncryption) bool {
+	switch enc {
+	case A128GCM, A192GCM, A256GCM:
+		return true
+	}
+	return false
+}
+
+// isCBCHMACEncryption reports whether the given content encryption algorithm
+// is an AES_CBC_HMAC_SHA2 mode.
+func isCBCHMACEncryption(enc ContentEncryption) bool {
+	switch enc {
+	case A128CBC_HS256, A192CBC_HS384, A256CBC_HS512:
+		return true
+	}
+	return false
+}
+
+// nonceGuard remembers recently used content encryption IVs, per CEK, and
+// flags a repeat. Reusing a nonce under the same key voids the
+// confidentiality and authenticity guarantees of AES-GCM, so a collision
+// here always indicates a bug, typically a deterministic RandReader. The
+// guard keeps a bounded window of recent (CEK, IV) pairs, hashed so key
+// material is not retained.
+type nonceGuard struct {
+	mu    sync.Mutex
+	seen  map[[sha256.Size]byte]struct{}
+	order [][sha256.Size]byte
+}
+
+// maxNonceGuardEntries bounds the number of (CEK, IV) pairs a nonceGuard
+// remembers; the oldest entries are evicted first.
+const maxNonceGuardEntries = 4096
+
+func newNonceGuard() *nonceGuard {
+	return &nonceGuard{
+		seen: make(map[[sha256.Size]byte]struct{}),
+	}
+}
+
+// check records the given CEK/IV pair and returns an error if it was seen
+// before within the guard's window.
+func (ng *nonceGuard) check(cek, iv []byte) error {
+	hash := sha256.New()
+	hash.Write([]byte{byte(len(cek))})
+	hash.Write(cek)
+	hash.Write(iv)
+
+	var digest [sha256.Size]byte
+	copy(digest[:], hash.Sum(nil))
+
+	ng.mu.Lock()
+	defer ng.mu.Unlock()
+
+	if _, reused := ng.seen[digest]; reused {
+		return errors.New("square/go-jose: detected AES-GCM nonce reuse with the same content encryption key")
+	}
+
+	if len(ng.order) >= maxNonceGuardEntries {
+		delete(ng.seen, ng.order[0])
+		ng.order = ng.order[1:]
+	}
+	ng.seen[digest] = struct{}{}
+	ng.order = append(ng.order, digest)
+
+	return nil
+}
+
+// A generic encrypter based on the given key encrypter and content cipher.
+type genericEncrypter struct {
+	contentAlg        ContentEncryption
+	compressionAlg    CompressionAlgorithm
+	cipher            contentCipher
+	recipients        []recipientKeyInfo
+	keyGenerator      keyGenerator
+	extraHeaders      map[HeaderKey]interface{}
+	contentType       ContentType
+	minimumRSAKeySize int
+	nonceGuard        *nonceGuard
+	rand              io.Reader
+	partyUInfo        []byte
+	partyVInfo        []byte
+	allowLegacyRSA15  bool
+}
+
+// applyRand points a key encrypter at the encrypter's random source. Key
+// encrypters that draw no randomness are left untouched.
+func applyRand(encrypter keyEncrypter, rand io.Reader) {
+	if rand == nil {
+		return
+	}
+	switch enc := encrypter.(type) {
+	case *rsaEncrypterVerifier:
+		enc.rand = rand
+	case *ecEncrypterVerifier:
+		enc.rand = rand
+	case *ec1PUEncrypter:
+		enc.rand = rand
+	case *x25519Encrypter:
+		enc.rand = rand
+	case *x25519OnePUEncrypter:
+		enc.rand = rand
+	case *symmetricKeyCipher:
+		enc.rand = rand
+	}
+}
+
+// applyPartyInfo points an ECDH-ES key encrypter at the caller-provided
+// "apu"/"apv" agreement parameters. Other key encrypters are left untouched.
+func applyPartyInfo(encrypter keyEncrypter, apu, apv []byte) {
+	if len(apu) == 0 && len(apv) == 0 {
+		return
+	}
+	switch enc := encrypter.(type) {
+	case *ecEncrypterVerifier:
+		enc.partyUInfo, enc.partyVInfo = apu, apv
+	case *ec1PUEncrypter:
+		enc.partyUInfo, enc.partyVInfo = apu, apv
+	case *x25519Encrypter:
+		enc.partyUInfo, enc.partyVInfo = apu, apv
+	case *x25519OnePUEncrypter:
+		enc.partyUInfo, enc.partyVInfo = apu, apv
+	}
+}
+
+type recipientKeyInfo struct {
+	keyID        string
+	keyAlg       KeyAlgorithm
+	keyEncrypter keyEncrypter
+}
+
+// EncrypterOptions represents options that can be set on new encrypters.
+type EncrypterOptions struct {
+	Compression CompressionAlgorithm
+
+	// Optional map of additional keys to be inserted into the protected header
+	// of a JWS object. Some specifications which make use of JWS like to insert
+	// additional values here. All values must be JSON-serializable.
+	ExtraHeaders map[HeaderKey]interface{}
+
+	// ContentType sets the "cty" (content type) header on encrypted
+	// messages, describing the plaintext so receivers can tell a nested JWT
+	// from a raw payload. The zero value omits the header. A "cty" key set
+	// directly in ExtraHeaders takes precedence over this field.
+	ContentType ContentType
+
+	// For ECDH-ES this is an optional function to generate a custom cek
+	CustomDeriveECDHES CustomDeriveECDHES
+
+	// MinimumRSAKeySize is the smallest RSA modulus, in bits, accepted for
+	// recipient keys. The zero value applies the default of 2048; set it
+	// lower explicitly for legacy interop.
+	MinimumRSAKeySize int
+
+	// DetectNonceReuse makes the encrypter track recently used AES-GCM IVs
+	// per content encryption key and fail on a repeat, catching accidental
+	// nonce reuse when a deterministic RandReader is in use. It is opt-in
+	// because deterministic test vectors intentionally reuse IVs. It has no
+	// effect for non-GCM content encryption.
+	DetectNonceReuse bool
+
+	// ContentEncryptionKey, if set, is used as the CEK for every message
+	// instead of generating a random one. Its length must match the chosen
+	// ContentEncryption algorithm. It cannot be combined with the direct
+	// encryption or ECDH-ES key algorithms, which determine the CEK
+	// themselves.
+	ContentEncryptionKey []byte
+
+	// Rand is the source of randomness for this encrypter: CEKs, IVs,
+	// ephemeral keys and salts. It defaults to crypto/rand.Reader via the
+	// package-level RandReader, which this field supersedes; setting it per
+	// instance avoids mutating global state in tests.
+	Rand io.Reader
+
+	// PartyUInfo and PartyVInfo are the PartyUInfo ("apu") and PartyVInfo
+	// ("apv") agreement parameters for ECDH-ES key derivation (RFC 7518,
+	// Section 4.6.1). When set they are base64url-encoded into the
+	// corresponding headers and fed to the Concat KDF; the decrypt side
+	// reads them back from the headers automatically. They have no effect
+	// on other key algorithms.
+	PartyUInfo []byte
+	PartyVInfo []byte
+
+	// AllowLegacyRSA15 permits creating encrypters for the DEPRECATED
+	// RSA1_5 key algorithm, which is refused by default. RSA-PKCS#1v1.5
+	// encryption is vulnerable to Bleichenbacher-style padding oracle
+	// attacks and RFC 8725 prohibits it for new systems; only set this when
+	// a legacy peer leaves no other choice, and prefer RSA-OAEP everywhere
+	// else.
+	AllowLegacyRSA15 bool
+}
+
+// WithHeader adds an arbitrary value to the ExtraHeaders map, initializing it
+// if necessary. It returns itself and so can be used in a fluent style.
+func (eo *EncrypterOptions) WithHeader(k HeaderKey, v interface{}) *EncrypterOptions {
+	if eo.ExtraHeaders == nil {
+		eo.ExtraHeaders = map[HeaderKey]interface{}{}
+	}
+	eo.ExtraHeaders[k] = v
+	return eo
+}
+
+// WithContentType sets the content type ("cty") header and returns the
+// updated EncrypterOptions.
+func (eo *EncrypterOptions) WithContentType(contentType ContentType) *EncrypterOptions {
+	eo.ContentType = contentType
+	return eo
+}
+
+// WithCustomDeriveECDHES sets the function used for ECDH key derivation
+func (eo *EncrypterOptions) WithCustomDeriveECDHES(derive CustomDeriveECDHES) *EncrypterOptions {
+	eo.CustomDeriveECDHES = derive
+	return eo
+}
+
+// DecrypterOptions represents options that can be set when parsing a message
+// for decryption with ParseEncryptedWithOptions.
+type DecrypterOptions struct {
+	// For ECDH-ES this is an optional function to generate a custom cek,
+	// applied to every Decrypt call on the parsed object
+	CustomDeriveECDHES CustomDeriveECDHES
+
+	// MinimumRSAKeySize is the smallest RSA modulus, in bits, accepted for
+	// decryption keys. The zero value applies the default of 2048; set it
+	// lower explicitly for legacy interop.
+	MinimumRSAKeySize int
+
+	// MaxDecompressedSize is the largest decompressed plaintext, in bytes,
+	// accepted for messages with a "zip" header, guarding against
+	// decompression bombs. The zero value applies the default of 250 KiB.
+	MaxDecompressedSize int64
+
+	// MaxCiphertextBytes caps the ciphertext size, in bytes, accepted on
+	// parse and decrypt, guarding public endpoints against memory
+	// exhaustion. Compact input is checked before the ciphertext segment is
+	// base64-decoded, so an oversized token is rejected without allocating
+	// buffers for it. The limit applies to the ciphertext itself, before
+	// decompression, which MaxDecompressedSize bounds separately. Zero
+	// means no limit.
+	MaxCiphertextBytes int64
+
+	// UnderstoodCritical lists critical ("crit") header parameters the caller
+	// understands and processes. Messages carrying a crit parameter outside
+	// this set fail to decrypt, as required by RFC 7516 §4.1.13.
+	UnderstoodCritical []string
+
+	// MinPBES2Count and MaxPBES2Count bound the PBES2 iteration count ("p2c")
+	// accepted on decrypt; values outside the range fail before the key
+	// derivation runs, so a malicious token cannot tie up CPU or downgrade
+	// to a trivially weak count. Zero values apply the defaults of 1000 and
+	// 1000000.
+	MinPBES2Count int
+	MaxPBES2Count int
+
+	// AllowedKeyAlgorithms and AllowedContentEncryption, when non-empty,
+	// restrict the "alg" and "enc" header values accepted on decrypt.
+	// Tokens using anything outside the allowlists fail before any crypto
+	// runs, preventing an attacker from substituting a weaker algorithm
+	// than the one the caller expects.
+	AllowedKeyAlgorithms     []KeyAlgorithm
+	AllowedContentEncryption []ContentEncryption
+
+	// ExpectedType, when non-empty, requires the protected "typ" header to
+	// equal this value for decryption to succeed, per the explicit-typing
+	// guidance of RFC 8725 §3.11. Messages with an absent or different type
+	// fail before any crypto runs.
+	ExpectedType string
+
+	// AllowLegacyRSA15 permits decrypting messages that use the DEPRECATED
+	// RSA1_5 key algorithm, which is refused by default. RSA-PKCS#1v1.5
+	// encryption is vulnerable to Bleichenbacher-style padding oracle
+	// attacks and RFC 8725 prohibits it for new systems; only set this (or
+	// list RSA1_5 in AllowedKeyAlgorithms, which has the same effect) when
+	// legacy senders leave no other choice.
+	AllowLegacyRSA15 bool
+}
+
+// WithUnderstoodCritical adds the given names to the set of understood
+// critical header parameters and returns the updated DecrypterOptions.
+func (do *DecrypterOptions) WithUnderstoodCritical(names ...string) *DecrypterOptions {
+	do.UnderstoodCritical = append(do.UnderstoodCritical, names...)
+	return do
+}
+
+// WithCustomDeriveECDHES sets the function used for ECDH key derivation
+func (do *DecrypterOptions) WithCustomDeriveECDHES(derive CustomDeriveECDHES) *DecrypterOptions {
+	do.CustomDeriveECDHES = derive
+	return do
+}
+
+// NewDirectoryServerECDHES returns the EMV 3-D Secure key derivation for the
+// given directory server ID, for use with WithCustomDeriveECDHES or
+// DecryptWithCustomCek. It derives the first session key; use
+// NewDirectoryServerECDHESWithCounter to derive subsequent keys.
+func NewDirectoryServerECDHES(directoryServerID string) CustomDeriveECDHES {
+	return createCustomDeriveECDHES(directoryServerID, 1)
+}
+
+// NewDirectoryServerECDHESWithCounter returns the derivation for session key
+// number counter, with counter starting at one. The Concat KDF output is
+// treated as a sequence of key-sized blocks and block number counter is
+// returned, so several session keys can be derived deterministically from one
+// ephemeral exchange. Counter one is the plain directory server derivation.
+// Panics if counter is less than one.
+func NewDirectoryServerECDHESWithCounter(directoryServerID string, counter int) CustomDeriveECDHES {
+	return createCustomDeriveECDHES(directoryServerID, counter)
+}
+
+// createCustomDeriveECDHES returns the key derivation used by EMV 3-D Secure
+// for SDK/ACS session keys: the Concat KDF runs with an empty algorithm ID,
+// an empty partyUInfo and the directory server ID as partyVInfo, regardless
+// of the apu/apv values carried in the JWE headers. The counter selects which
+// key-sized block of the KDF output is returned, counting from one.
+func createCustomDeriveECDHES(directoryServerID string, counter int) CustomDeriveECDHES {
+	if counter < 1 {
+		panic("square/go-jose: counter must be at least 1")
+	}
+	return func(alg string, apuData, apvData []byte, priv *ecdsa.PrivateKey, pub *ecdsa.PublicKey, size int) []byte {
+		out := josecipher.DeriveECDHES("", []byte{}, []byte(directoryServerID), priv, pub, size*counter)
+		return out[size*(counter-1):]
+	}
+}
+
+// DirectoryServerSessionKey derives 3DS2 session key number counter from one
+// side of the SDK/ACS ephemeral exchange. Session keys in the challenge flow
+// are fixed at 256 bits; the result can be passed to
+// NewDirectoryServerSessionEncrypter or DecryptDirectoryServerSession to
+// protect CReq/CRes messages in direct mode. Counter starts at one; panics if
+// it is less.
+func DirectoryServerSessionKey(directoryServerID string, counter int, priv *ecdsa.PrivateKey, pub *ecdsa.PublicKey) []byte {
+	derive := createCustomDeriveECDHES(directoryServerID, counter)
+	return derive("", nil, nil, priv, pub, 32)
+}
+
+// NewDirectoryServerSessionEncrypter returns an Encrypter that uses the given
+// 256-bit session key directly as the content encryption key ("dir"), as the
+// CReq/CRes phase of the 3DS2 challenge flow does. Only the two content
+// encryptions from that phase are accepted: A128CBC-HS256 uses the full
+// session key and A128GCM its leftmost 128 bits.
+func NewDirectoryServerSessionEncrypter(enc ContentEncryption, sessionKey []byte) (Encrypter, error) {
+	cek, err := directoryServerSessionCEK(enc, sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	return NewEncrypter(enc, Recipient{Algorithm: DIRECT, Key: cek}, nil)
+}
+
+// directoryServerSessionCEK sizes a 3DS2 session key for use as a direct-mode
+// content encryption key.
+func directoryServerSessionCEK(enc ContentEncryption, sessionKey []byte) ([]byte, error) {
+	if len(sessionKey) != 32 {
+		return nil, fmt.Errorf("square/go-jose: 3DS2 session keys are 32 bytes, got %d bytes: %w", len(sessionKey), ErrInvalidKeySize)
+	}
+	switch enc {
+	case A128CBC_HS256:
+		return sessionKey, nil
+	case A128GCM:
+		return sessionKey[:16], nil
+	}
+	return nil, fmt.Errorf("square/go-jose: content encryption '%s' is not part of the 3DS2 session phase: %w", enc, ErrUnsupportedAlgorithm)
+}
+
+// WithType adds a type ("typ") header and returns the updated EncrypterOptions.
+func (eo *EncrypterOptions) WithType(typ ContentType) *EncrypterOptions {
+	return eo.WithHeader(HeaderType, typ)
+}
+
+// Recipient represents an algorithm/key to encrypt messages to.
+//
+// PBES2Count and PBES2Salt correspond with the  "p2c" and "p2s" headers used
+// on the password-based encryption algorithms PBES2-HS256+A128KW,
+// PBES2-HS384+A192KW, and PBES2-HS512+A256KW. If they are not provided a safe
+// default of 100000 will be used for the count and a 128-bit random salt will
+// be generated.
+type Recipient struct {
+	Algorithm  KeyAlgorithm
+	Key        interface{}
+	KeyID      string
+	PBES2Count int
+	PBES2Salt  []byte
+}
+
+// ECDH1PUEncryptionKey pairs the sender's static private key with the
+// recipient's public key for the authenticated ECDH-1PU key agreement
+// (draft-madden-jose-ecdh-1pu), and is passed as the Key of a Recipient
+// using one of the ECDH_1PU key algorithms. Both keys must be of the same
+// family: *ecdsa keys on the same NIST curve, or *ecdh keys on X25519.
+type ECDH1PUEncryptionKey struct {
+	SenderKey    interface{}
+	RecipientKey interface{}
+}
+
+// ECDH1PUDecryptionKey pairs the recipient's private key with the sender's
+// trusted static public key for ECDH-1PU, and is passed as the decryption
+// key to Decrypt. The sender key authenticates the message: decryption fails
+// unless it was produced with the matching sender private key. Both keys
+// must be of the same family: *ecdsa keys on the same NIST curve, or *ecdh
+// keys on X25519.
+type ECDH1PUDecryptionKey struct {
+	RecipientKey interface{}
+	SenderKey    interface{}
+}
+
+// isKeyAlgorithm reports whether alg is a known key management algorithm.
+func isKeyAlgorithm(alg KeyAlgorithm) bool {
+	switch alg {
+	case ED25519, RSA1_5, RSA_OAEP, RSA_OAEP_256, RSA_OAEP_384, RSA_OAEP_512,
+		A128KW, A192KW, A256KW, DIRECT,
+		ECDH_ES, ECDH_ES_A128KW, ECDH_ES_A192KW, ECDH_ES_A256KW,
+		ECDH_1PU, ECDH_1PU_A128KW, ECDH_1PU_A192KW, ECDH_1PU_A256KW,
+		A128GCMKW, A192GCMKW, A256GCMKW,
+		PBES2_HS256_A128KW, PBES2_HS384_A192KW, PBES2_HS512_A256KW:
+		return true
+	}
+	return false
+}
+
+// resolveKeyAlgorithm returns the effective key algorithm for a recipient,
+// inferring it from the key's "alg" value when the recipient leaves it empty.
+// An explicit algorithm that contradicts the key's own is an error. JWK "alg"
+// values that are not key management algorithms (some issuers put the key
+// type there) are ignored.
+func resolveKeyAlgorithm(alg KeyAlgorithm, key interface{}) (KeyAlgorithm, error) {
+	var fromKey KeyAlgorithm
+	switch k := key.(type) {
+	case JSONWebKey:
+		fromKey = KeyAlgorithm(k.Algorithm)
+	case *JSONWebKey:
+		if k != nil {
+			fromKey = KeyAlgorithm(k.Algorithm)
+		}
+	default:
+		// Nothing to infer from for raw keys.
+		return alg, nil
+	}
+
+	if !isKeyAlgorithm(fromKey) {
+		fromKey = ""
+	}
+
+	switch {
+	case alg == "" && fromKey == "":
+		return "", fmt.Errorf("square/go-jose: no key algorithm set on recipient or key")
+	case alg == "":
+		return fromKey, nil
+	case fromKey != "" && fromKey != alg:
+		return "", fmt.Errorf("square/go-jose: recipient algorithm '%s' conflicts with key algorithm '%s'", alg, fromKey)
+	}
+
+	return alg, nil
+}
+
+// NewEncrypter creates an appropriate encrypter based on the key type
+func NewEncrypter(enc ContentEncryption, rcpt Recipient, opts *EncrypterOptions) (Encrypter, error) {
+	var err error
+	rcpt.Algorithm, err = resolveKeyAlgorithm(rcpt.Algorithm, rcpt.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypter := &genericEncrypter{
+		contentAlg: enc,
+		recipients: []recipientKeyInfo{},
+		cipher:     getContentCipher(enc),
+	}
+	if opts != nil {
+		encrypter.compressionAlg = opts.Compression
+		encrypter.extraHeaders = opts.ExtraHeaders
+		encrypter.contentType = opts.ContentType
+		encrypter.minimumRSAKeySize = opts.MinimumRSAKeySize
+		encrypter.rand = opts.Rand
+		encrypter.partyUInfo = opts.PartyUInfo
+		encrypter.partyVInfo = opts.PartyVInfo
+		encrypter.allowLegacyRSA15 = opts.AllowLegacyRSA15
+		if opts.DetectNonceReuse && isGCMEncryption(enc) {
+			encrypter.nonceGuard = newNonceGuard()
+		}
+	}
+
+	if encrypter.cipher == nil {
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	if aead, ok := encrypter.cipher.(*aeadContentCipher); ok {
+		aead.rand = encrypter.rand
+	}
+
+	if opts != nil && opts.ContentEncryptionKey != nil {
+		switch rcpt.Algorithm {
+		case DIRECT, ECDH_ES:
+			return nil, fmt.Errorf("square/go-jose: cannot use an explicit CEK with key algorithm '%s'", rcpt.Algorithm)
+		}
+		if len(opts.ContentEncryptionKey) != encrypter.cipher.keySize() {
+			return nil, ErrInvalidKeySize
+		}
+	}
+
+	var keyID string
+	var rawKey interface{}
+	switch encryptionKey := rcpt.Key.(type) {
+	case JSONWebKey:
+		if !encryptionKey.allowsAnyKeyOp("encrypt", "wrapKey", "deriveKey") {
+			return nil, errors.New("square/go-jose: JWK key_ops does not permit encryption")
+		}
+		keyID, rawKey = encryptionKey.KeyID, encryptionKey.Key
+	case *JSONWebKey:
+		if !encryptionKey.allowsAnyKeyOp("encrypt", "wrapKey", "deriveKey") {
+			return nil, errors.New("square/go-jose: JWK key_ops does not permit encryption")
+		}
+		keyID, rawKey = encryptionKey.KeyID, encryptionKey.Key
+	case OpaqueKeyEncrypter:
+		keyID, rawKey = encryptionKey.KeyID(), encryptionKey
+	default:
+		rawKey = encryptionKey
+	}
+
+	switch rcpt.Algorithm {
+	case DIRECT:
+		// Direct encryption mode must be treated differently
+		if reflect.TypeOf(rawKey) != reflect.TypeOf([]byte{}) {
+			return nil, ErrUnsupportedKeyType
+		}
+		if expected, actual := encrypter.cipher.keySize(), len(rawKey.([]byte)); expected != actual {
+			return nil, fmt.Errorf("square/go-jose: direct encryption with %s requires a %d-byte key, got %d bytes: %w", enc, expected, actual, ErrInvalidKeySize)
+		}
+		encrypter.keyGenerator = staticKeyGenerator{
+			key: rawKey.([]byte),
+		}
+		recipientInfo, _ := newSymmetricRecipient(rcpt.Algorithm, rawKey.([]byte))
+		applyRand(recipientInfo.keyEncrypter, encrypter.rand)
+		recipientInfo.keyID = keyID
+		if rcpt.KeyID != "" {
+			recipientInfo.keyID = rcpt.KeyID
+		}
+		encrypter.recipients = []recipientKeyInfo{recipientInfo}
+		return encrypter, nil
+	case ECDH_ES:
+		// ECDH-ES (w/o key wrapping) is similar to DIRECT mode
+		var customDeriveECDHES CustomDeriveECDHES
+		if opts != nil && opts.CustomDeriveECDHES != nil {
+			customDeriveECDHES = opts.CustomDeriveECDHES
+		}
+		var recipientInfo recipientKeyInfo
+		switch epk := rawKey.(type) {
+		case *ecdsa.PublicKey:
+			encrypter.keyGenerator = ecKeyGenerator{
+				size:               encrypter.cipher.keySize(),
+				algID:              string(enc),
+				publicKey:          epk,
+				customDeriveECDHES: customDeriveECDHES,
+				rand:               encrypter.rand,
+				partyUInfo:         encrypter.partyUInfo,
+				partyVInfo:         encrypter.partyVInfo,
+			}
+			recipientInfo, _ = newECDHRecipient(rcpt.Algorithm, epk)
+		case *ecdh.PublicKey:
+			encrypter.keyGenerator = x25519KeyGenerator{
+				size:       encrypter.cipher.keySize(),
+				algID:      string(enc),
+				publicKey:  epk,
+				rand:       encrypter.rand,
+				partyUInfo: encrypter.partyUInfo,
+				partyVInfo: encrypter.partyVInfo,
+			}
+			recipientInfo, _ = newX25519Recipient(rcpt.Algorithm, epk)
+		default:
+			return nil, ErrUnsupportedKeyType
+		}
+		applyRand(recipientInfo.keyEncrypter, encrypter.rand)
+		recipientInfo.keyID = keyID
+		if rcpt.KeyID != "" {
+			recipientInfo.keyID = rcpt.KeyID
+		}
+		encrypter.recipients = []recipientKeyInfo{recipientInfo}
+		return encrypter, nil
+	case ECDH_1PU:
+		// ECDH-1PU (w/o key wrapping) is similar to DIRECT mode, with the
+		// sender's static key mixed into the derivation.
+		pair, ok := rawKey.(ECDH1PUEncryptionKey)
+		if !ok {
+			return nil, ErrUnsupportedKeyType
+		}
+		recipientInfo, err := newECDH1PURecipient(rcpt.Algorithm, pair)
+		if err != nil {
+			return nil, err
+		}
+		switch keyEnc := recipientInfo.keyEncrypter.(type) {
+		case *ec1PUEncrypter:
+			encrypter.keyGenerator = ec1PUKeyGenerator{
+				size:       encrypter.cipher.keySize(),
+				algID:      string(enc),
+				senderKey:  keyEnc.senderKey,
+				publicKey:  keyEnc.publicKey,
+				rand:       encrypter.rand,
+				partyUInfo: encrypter.partyUInfo,
+				partyVInfo: encrypter.partyVInfo,
+			}
+		case *x25519OnePUEncrypter:
+			encrypter.keyGenerator = x25519OnePUKeyGenerator{
+				size:       encrypter.cipher.keySize(),
+				algID:      string(enc),
+				senderKey:  keyEnc.senderKey,
+				publicKey:  keyEnc.publicKey,
+				rand:       encrypter.rand,
+				partyUInfo: encrypter.partyUInfo,
+				partyVInfo: encrypter.partyVInfo,
+			}
+		}
+		applyRand(recipientInfo.keyEncrypter, encrypter.rand)
+		recipientInfo.keyID = keyID
+		if rcpt.KeyID != "" {
+			recipientInfo.keyID = rcpt.KeyID
+		}
+		encrypter.recipients = []recipientKeyInfo{recipientInfo}
+		return encrypter, nil
+	default:
+		// Can just add a standard recipient
+		encrypter.keyGenerator = randomKeyGenerator{
+			size: encrypter.cipher.keySize(),
+			rand: encrypter.rand,
+		}
+		if opts != nil && opts.ContentEncryptionKey != nil {
+			encrypter.keyGenerator = staticKeyGenerator{
+				key: opts.ContentEncryptionKey,
+			}
+		}
+		err := encrypter.addRecipient(rcpt)
+		return encrypter, err
+	}
+}
+
+// NewMultiEncrypter creates a multi-encrypter based on the given parameters
+func NewMultiEncrypter(enc ContentEncryption, rcpts []Recipient, opts *EncrypterOptions) (Encrypter, error) {
+	cipher := getContentCipher(enc)
+
+	if cipher == nil {
+		return nil, ErrUnsupportedAlgorithm
+	}
+	if rcpts == nil || len(rcpts) == 0 {
+		return nil, fmt.Errorf("square/go-jose: recipients is nil or empty")
+	}
+
+	encrypter := &genericEncrypter{
+		contentAlg: enc,
+		recipients: []recipientKeyInfo{},
+		cipher:     cipher,
+		keyGenerator: randomKeyGenerator{
+			size: cipher.keySize(),
+		},
+	}
+
+	if opts != nil {
+		encrypter.compressionAlg = opts.Compression
+		encrypter.extraHeaders = opts.ExtraHeaders
+		encrypter.contentType = opts.ContentType
+		encrypter.minimumRSAKeySize = opts.MinimumRSAKeySize
+		encrypter.rand = opts.Rand
+		encrypter.partyUInfo = opts.PartyUInfo
+		encrypter.partyVInfo = opts.PartyVInfo
+		encrypter.allowLegacyRSA15 = opts.AllowLegacyRSA15
+		if opts.DetectNonceReuse && isGCMEncryption(enc) {
+			encrypter.nonceGuard = newNonceGuard()
+		}
+		if opts.ContentEncryptionKey != nil {
+			if len(opts.ContentEncryptionKey) != cipher.keySize() {
+				return nil, ErrInvalidKeySize
+			}
+			encrypter.keyGenerator = staticKeyGenerator{
+				key: opts.ContentEncryptionKey,
+			}
+		} else {
+			encrypter.keyGenerator = randomKeyGenerator{
+				size: cipher.keySize(),
+				rand: opts.Rand,
+			}
+		}
+	}
+
+	if aead, ok := cipher.(*aeadContentCipher); ok {
+		aead.rand = encrypter.rand
+	}
+
+	for _, recipient := range rcpts {
+		err := encrypter.addRecipient(recipient)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return encrypter, nil
+}
+
+// NewEncrypterForJWK creates an encrypter for the key in the given set
+// matching kid, for callers who receive a recipient's JWKS rather than a bare
+// key. The key management algorithm is taken from the key's "alg" value when
+// present, otherwise inferred from its key type, and the key ID is carried
+// through to the "kid" header of produced messages. Keys marked with "use"
+// of "sig" are rejected.
+func NewEncrypterForJWK(enc ContentEncryption, set JSONWebKeySet, kid string, opts *EncrypterOptions) (Encrypter, error) {
+	keys := set.Key(kid)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("square/go-jose: no key in set with kid '%s'", kid)
+	}
+
+	key := keys[0]
+	if key.Use == "sig" {
+		return nil, fmt.Errorf("square/go-jose: key '%s' is marked for signing, not encryption", kid)
+	}
+	if !key.IsPublic() {
+		key = key.Public()
+	}
+
+	alg, err := inferKeyAlgorithm(&key)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewEncrypter(enc, Recipient{Algorithm: alg, Key: &key}, opts)
+}
+
+// inferKeyAlgorithm picks the key management algorithm for a JWK: the key's
+// "alg" value when present, otherwise a default based on its key type.
+func inferKeyAlgorithm(key *JSONWebKey) (KeyAlgorithm, error) {
+	if key.Algorithm != "" {
+		return KeyAlgorithm(key.Algorithm), nil
+	}
+
+	switch key.Key.(type) {
+	case *rsa.PublicKey:
+		return RSA_OAEP_256, nil
+	case *ecdsa.PublicKey:
+		return ECDH_ES_A256KW, nil
+	case *ecdh.PublicKey:
+		return ECDH_ES_A256KW, nil
+	}
+
+	return "", fmt.Errorf("square/go-jose: cannot infer key algorithm for key '%s'", key.KeyID)
+}
+
+func (ctx *genericEncrypter) addRecipient(recipient Recipient) (err error) {
+	var recipientInfo recipientKeyInfo
+
+	recipient.Algorithm, err = resolveKeyAlgorithm(recipient.Algorithm, recipient.Key)
+	if err != nil {
+		return err
+	}
+
+	switch recipient.Algorithm {
+	case DIRECT, ECDH_ES, ECDH_1PU:
+		return fmt.Errorf("square/go-jose: key algorithm '%s' not supported in multi-recipient mode", recipient.Algorithm)
+	case ECDH_1PU_A128KW, ECDH_1PU_A192KW, ECDH_1PU_A256KW:
+		// draft-madden-jose-ecdh-1pu-04 §2.1: the key-wrapping modes must
+		// only be used with AES_CBC_HMAC_SHA2 content encryption. With an
+		// AEAD like GCM any recipient of a message can forge messages that
+		// appear to come from the sender, defeating the authentication this
+		// mode exists to provide.
+		if !isCBCHMACEncryption(ctx.contentAlg) {
+			return fmt.Errorf("square/go-jose: key algorithm '%s' must be used with AES_CBC_HMAC_SHA2 content encryption", recipient.Algorithm)
+		}
+	}
+
+	if recipient.Algorithm == RSA1_5 && !ctx.allowLegacyRSA15 {
+		return ErrLegacyRSA15Disabled
+	}
+
+	if err := validateRSAKeySize(recipient.Key, ctx.minimumRSAKeySize); err != nil {
+		return err
+	}
+
+	recipientInfo, err = makeJWERecipient(recipient.Algorithm, recipient.Key)
+	applyRand(recipientInfo.keyEncrypter, ctx.rand)
+	applyPartyInfo(recipientInfo.keyEncrypter, ctx.partyUInfo, ctx.partyVInfo)
+	if recipient.KeyID != "" {
+		recipientInfo.keyID = recipient.KeyID
+	}
+
+	switch recipient.Algorithm {
+	case PBES2_HS256_A128KW, PBES2_HS384_A192KW, PBES2_HS512_A256KW:
+		if sr, ok := recipientInfo.keyEncrypter.(*symmetricKeyCipher); ok {
+			sr.p2c = recipient.PBES2Count
+			sr.p2s = recipient.PBES2Salt
+		}
+	}
+
+	if err == nil {
+		ctx.recipients = append(ctx.recipients, recipientInfo)
+	}
+	return err
+}
+
+func makeJWERecipient(alg KeyAlgorithm, encryptionKey interface{}) (recipientKeyInfo, error) {
+	switch encryptionKey := encryptionKey.(type) {
+	case *rsa.PublicKey:
+		return newRSARecipient(alg, encryptionKey)
+	case *ecdsa.PublicKey:
+		return newECDHRecipient(alg, encryptionKey)
+	case *ecdh.PublicKey:
+		return newX25519Recipient(alg, encryptionKey)
+	case ECDH1PUEncryptionKey:
+		return newECDH1PURecipient(alg, encryptionKey)
+	case []byte:
+		return newSymmetricRecipient(alg, encryptionKey)
+	case string:
+		return newSymmetricRecipient(alg, []byte(encryptionKey))
+	case *JSONWebKey:
+		if !encryptionKey.allowsAnyKeyOp("encrypt", "wrapKey", "deriveKey") {
+			return recipientKeyInfo{}, errors.New("square/go-jose: JWK key_ops does not permit encryption")
+		}
+		recipient, err := makeJWERecipient(alg, encryptionKey.Key)
+		recipient.keyID = encryptionKey.KeyID
+		return recipient, err
+	}
+	if encrypter, ok := encryptionKey.(OpaqueKeyEncrypter); ok {
+		return newOpaqueKeyEncrypter(alg, encrypter)
+	}
+	return recipientKeyInfo{}, ErrUnsupportedKeyType
+}
+
+// newDecrypter creates an appropriate decrypter based on the key type
+func newDecrypter(decryptionKey interface{}, customDeriveECDHES CustomDeriveECDHES) (keyDecrypter, error) {
+	switch decryptionKey := decryptionKey.(type) {
+	case *rsa.PrivateKey:
+		return &rsaDecrypterSigner{
+			privateKey: decryptionKey,
+		}, nil
+	case *ecdsa.PrivateKey:
+		return &ecDecrypterSigner{
+			privateKey:         decryptionKey,
+			customDeriveECDHES: customDeriveECDHES,
+		}, nil
+	case *ecdh.PrivateKey:
+		return &x25519Decrypter{
+			privateKey: decryptionKey,
+		}, nil
+	case ECDH1PUDecryptionKey:
+		return newECDH1PUDecrypter(decryptionKey)
+	case []byte:
+		return &symmetricKeyCipher{
+			key: decryptionKey,
+		}, nil
+	case string:
+		return &symmetricKeyCipher{
+			key: []byte(decryptionKey),
+		}, nil
+	case JSONWebKey:
+		if !decryptionKey.allowsAnyKeyOp("decrypt", "unwrapKey", "deriveKey") {
+			return nil, errors.New("square/go-jose: JWK key_ops does not permit decryption")
+		}
+		return newDecrypter(decryptionKey.Key, nil)
+	case *JSONWebKey:
+		if !decryptionKey.allowsAnyKeyOp("decrypt", "unwrapKey", "deriveKey") {
+			return nil, errors.New("square/go-jose: JWK key_ops does not permit decryption")
+		}
+		return newDecrypter(decryptionKey.Key, nil)
+	}
+	if okd, ok := decryptionKey.(OpaqueKeyDecrypter); ok {
+		return &opaqueKeyDecrypter{decrypter: okd}, nil
+	}
+	return nil, ErrUnsupportedKeyType
+}
+
+// Implementation of encrypt method producing a JWE object.
+func (ctx *genericEncrypter) Encrypt(plaintext []byte) (*JSONWebEncryption, error) {
+	return ctx.EncryptWithAuthDataContext(context.Background(), plaintext, nil)
+}
+
+// EncryptContext is like Encrypt but respects context cancellation before
+// any key encryption work begins.
+func (ctx *genericEncrypter) EncryptContext(cctx context.Context, plaintext []byte) (*JSONWebEncryption, error) {
+	return ctx.EncryptWithAuthDataContext(cctx, plaintext, nil)
+}
+
+// Implementation of encrypt method producing a JWE object.
+func (ctx *genericEncrypter) EncryptWithAuthData(plaintext, aad []byte) (*JSONWebEncryption, error) {
+	return ctx.EncryptWithAuthDataContext(context.Background(), plaintext, aad)
+}
+
+// EncryptWithAuthDataContext is like EncryptWithAuthData but respects context
+// cancellation before any key encryption work begins.
+func (ge *genericEncrypter) EncryptWithAuthDataContext(ctx context.Context, plaintext, aad []byte) (*JSONWebEncryption, error) {
+	obj := &JSONWebEncryption{}
+	obj.aad = aad
+
+	// An encrypter that was explicitly allowed to produce RSA1_5 messages may
+	// also decrypt its own output without a second opt-in.
+	obj.allowLegacyRSA15 = ge.allowLegacyRSA15
+
+	obj.protected = &rawHeader{}
+	err := obj.protected.set(headerEncryption, ge.contentAlg)
+	if err != nil {
+		return nil, err
+	}
+
+	obj.recipients = make([]recipientInfo, len(ge.recipients))
+
+	if len(ge.recipients) == 0 {
+		return nil, fmt.Errorf("square/go-jose: no recipients to encrypt to")
+	}
+
+	cek, headers, err := ge.keyGenerator.genKey()
+	if err != nil {
+		return nil, err
+	}
+
+	obj.protected.merge(&headers)
+
+	for i, info := range ge.recipients {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		recipient, err := info.keyEncrypter.encryptKey(cek, info.keyAlg)
+		if err != nil {
+			return nil, err
+		}
+
+		err = recipient.header.set(headerAlgorithm, info.keyAlg)
+		if err != nil {
+			return nil, err
+		}
+
+		if info.keyID != "" {
+			err = recipient.header.set(headerKeyID, info.keyID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		obj.recipients[i] = recipient
+	}
+
+	if len(ge.recipients) == 1 {
+		// Move per-recipient headers into main protected header if there's
+		// only a single recipient.
+		obj.protected.merge(obj.recipients[0].header)
+		obj.recipients[0].header = nil
+	}
+
+	if ge.compressionAlg != NONE {
+		plaintext, err = compress(ge.compressionAlg, plaintext)
+		if err != nil {
+			return nil, err
+		}
+
+		err = obj.protected.set(headerCompression, ge.compressionAlg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if ge.contentType != "" {
+		err = obj.protected.set(HeaderContentType, ge.contentType)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for k, v := range ge.extraHeaders {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		(*obj.protected)[k] = makeRawMessage(b)
+	}
+
+	authData := obj.computeAuthData()
+	parts, err := ge.cipher.encrypt(cek, authData, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	if ge.nonceGuard != nil {
+		if err := ge.nonceGuard.check(cek, parts.iv); err != nil {
+			return nil, err
+		}
+	}
+
+	obj.iv = parts.iv
+	obj.ciphertext = parts.ciphertext
+	obj.tag = parts.tag
+
+	return obj, nil
+}
+
+// Clone returns an independent copy of this encrypter. The clone gets its
+// own recipient list and nonce-reuse guard, so neither instance can observe
+// state changes in the other.
+func (ctx *genericEncrypter) Clone() Encrypter {
+	clone := *ctx
+
+	clone.recipients = make([]recipientKeyInfo, len(ctx.recipients))
+	copy(clone.recipients, ctx.recipients)
+
+	if ctx.extraHeaders != nil {
+		clone.extraHeaders = make(map[HeaderKey]interface{}, len(ctx.extraHeaders))
+		for k, v := range ctx.extraHeaders {
+			clone.extraHeaders[k] = v
+		}
+	}
+
+	if ctx.nonceGuard != nil {
+		clone.nonceGuard = newNonceGuard()
+	}
+
+	return &clone
+}
+
+func (ctx *genericEncrypter) Options() EncrypterOptions {
+	return EncrypterOptions{
+		Compression:  ctx.compressionAlg,
+		ExtraHeaders: ctx.extraHeaders,
+		ContentType:  ctx.contentType,
+	}
+}
+
+// DecryptWithCustomCek decrypts and validates the object like Decrypt, but
+// derives the ECDH-ES content encryption key with the given custom derivation.
+func (obj JSONWebEncryption) DecryptWithCustomCek(decryptionKey interface{}, customDeriveECDHES CustomDeriveECDHES) ([]byte, error) {
+	return obj.decrypt(context.Background(), decryptionKey, customDeriveECDHES)
+}
+
+// KeyUnwrapper unwraps a wrapped content encryption key. The callback
+// receives the key algorithm from the message headers together with the
+// wrapped key bytes and returns the raw content encryption key.
+type KeyUnwrapper func(alg KeyAlgorithm, encryptedKey []byte) ([]byte, error)
+
+// keyUnwrapDecrypter adapts a KeyUnwrapper to the opaque key decrypter
+// machinery, restricted to the RSA key management algorithms.
+type keyUnwrapDecrypter struct {
+	unwrap KeyUnwrapper
+}
+
+func (kud keyUnwrapDecrypter) DecryptKey(encryptedKey []byte, header Header) ([]byte, error) {
+	switch alg := KeyAlgorithm(header.Algorithm); alg {
+	case RSA1_5, RSA_OAEP, RSA_OAEP_256:
+		return kud.unwrap(alg, encryptedKey)
+	default:
+		return nil, fmt.Errorf("square/go-jose: key unwrap callback only supports RSA key algorithms, got '%s': %w", alg, ErrUnsupportedAlgorithm)
+	}
+}
+
+// DecryptWithKeyUnwrapper decrypts and validates the object like Decrypt, but
+// delegates unwrapping of the content encryption key for the RSA key
+// algorithms (RSA1_5, RSA-OAEP, RSA-OAEP-256) to the given callback. This
+// suits private keys held in an external service such as a KMS: only the
+// wrapped key bytes are handed off and content decryption happens locally, so
+// the private key never leaves the service.
+func (obj JSONWebEncryption) DecryptWithKeyUnwrapper(unwrap KeyUnwrapper) ([]byte, error) {
+	if unwrap == nil {
+		return nil, errors.New("square/go-jose: no key unwrap callback given")
+	}
+	return obj.decrypt(context.Background(), keyUnwrapDecrypter{unwrap: unwrap}, nil)
+}
+
+// DecryptDirectoryServerSession decrypts a direct-mode 3DS2 CReq/CRes message
+// with the given 256-bit session key, sizing it for the message's content
+// encryption like NewDirectoryServerSessionEncrypter does on the encrypt side.
+func (obj JSONWebEncryption) DecryptDirectoryServerSession(sessionKey []byte) ([]byte, error) {
+	headers := obj.mergedHeaders(nil)
+	if alg := headers.getAlgorithm(); alg != DIRECT {
+		return nil, fmt.Errorf("square/go-jose: expected a 'dir' message, got '%s': %w", alg, ErrUnsupportedAlgorithm)
+	}
+
+	cek, err := directoryServerSessionCEK(headers.getEncryption(), sessionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return obj.Decrypt(cek)
+}
+
+// DecryptStream decrypts and validates the object and serves the plaintext
+// through an io.Reader. JWE protects the payload with a single-shot AEAD, so
+// the ciphertext cannot be decrypted incrementally: releasing output before
+// the authentication tag verifies would hand untrusted data to the caller.
+// The whole message is therefore decrypted and verified up front and the
+// reader yields the already-verified plaintext. This keeps peak memory at
+// one copy of the plaintext for callers that consume it as a stream, but it
+// cannot go below the plaintext size.
+func (obj JSONWebEncryption) DecryptStream(decryptionKey interface{}) (io.Reader, error) {
+	plaintext, err := obj.Decrypt(decryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(plaintext), nil
+}
+
+// DecryptWithAuthData decrypts and validates the object like Decrypt, and
+// additionally returns the authenticated additional data and the sanitized
+// protected header. Both are covered by the AEAD tag along with the
+// ciphertext, so tampering with any of them fails the whole call; acting on
+// the returned AAD or header fields is therefore safe. Note that this
+// function does not support multi-recipient, if you desire multi-recipient
+// decryption use DecryptMulti instead.
+func (obj JSONWebEncryption) DecryptWithAuthData(decryptionKey interface{}) ([]byte, []byte, Header, error) {
+	plaintext, err := obj.decrypt(context.Background(), decryptionKey, obj.customDeriveECDHES)
+	if err != nil {
+		return nil, nil, Header{}, err
+	}
+
+	var header Header
+	if obj.protected != nil {
+		header, err = obj.protected.sanitized()
+		if err != nil {
+			return nil, nil, Header{}, fmt.Errorf("square/go-jose: failed to sanitize header: %v", err)
+		}
+	}
+
+	return plaintext, obj.GetAuthData(), header, nil
+}
+
+// Decrypt and validate the object and return the plaintext. Note that this
+// function does not support multi-recipient, if you desire multi-recipient
+// decryption use DecryptMulti instead. If the object was parsed with
+// ParseEncryptedWithOptions, any custom ECDH-ES derivation set there is used.
+func (obj JSONWebEncryption) Decrypt(decryptionKey interface{}) ([]byte, error) {
+	return obj.decrypt(context.Background(), decryptionKey, obj.customDeriveECDHES)
+}
+
+// DecryptContext is like Decrypt but threads the context down to any
+// context-aware opaque key decrypter and respects cancellation before
+// decrypting with in-memory keys.
+func (obj JSONWebEncryption) DecryptContext(ctx context.Context, decryptionKey interface{}) ([]byte, error) {
+	return obj.decrypt(ctx, decryptionKey, obj.customDeriveECDHES)
+}
+
+// DecryptForDebug decrypts the object like Decrypt but additionally returns
+// the unwrapped or derived content encryption key, so it can be compared
+// against the CEK hex values printed in worked samples such as the 3DS2
+// specification examples. The key is returned whenever key decryption
+// succeeded, even when content decryption subsequently fails.
+//
+// This is a diagnostic aid only and is UNSAFE for production use: exposing
+// the content encryption key for attacker-supplied messages undoes the
+// deliberately generic decryption errors and hands out the exact material an
+// oracle attack needs. Never log or transmit the returned key outside a
+// debugging session.
+func (obj JSONWebEncryption) DecryptForDebug(decryptionKey interface{}) (plaintext, cek []byte, err error) {
+	return obj.decryptWithCEK(context.Background(), decryptionKey, obj.customDeriveECDHES)
+}
+
+// DecryptWithKeyFunc decrypts and validates the object using a key resolved
+// by the given callback and returns the plaintext. The resolver receives the
+// merged (protected and per-recipient) header of each recipient, including
+// fields such as KeyID and Algorithm, and returns the decryption key to use —
+// letting callers pick a private key from a keystore by "kid". Recipients are
+// tried in order until one decrypts; an error from the resolver fails that
+// recipient and is returned if none succeed.
+func (obj JSONWebEncryption) DecryptWithKeyFunc(keyFunc func(Header) (interface{}, error)) ([]byte, error) {
+	if keyFunc == nil {
+		return nil, errors.New("square/go-jose: nil key resolver")
+	}
+
+	lastErr := error(ErrCryptoFailure)
+	for i := range obj.recipients {
+		headers, err := obj.mergedHeaders(&obj.recipients[i]).sanitized()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		key, err := keyFunc(headers)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if len(obj.recipients) == 1 {
+			plaintext, err := obj.Decrypt(key)
+			if err == nil {
+				return plaintext, nil
+			}
+			lastErr = err
+		} else {
+			_, _, plaintext, err := obj.DecryptMulti(key)
+			if err == nil {
+				return plaintext, nil
+			}
+			lastErr = err
+		}
+	}
+
+	return nil, lastErr
+}
+
+// DecryptAny decrypts and validates the object by trying each of the given
+// keys and returns the index of the key that succeeded along with the
+// plaintext. It suits deployments holding several private keys where
+// incoming messages do not carry a usable key ID. Every key is attempted
+// even after one has matched, keeping the work performed roughly
+// independent of which key succeeds.
+func (obj JSONWebEncryption) DecryptAny(decryptionKeys []interface{}) (int, []byte, error) {
+	if len(decryptionKeys) == 0 {
+		return -1, nil, errors.New("square/go-jose: no decryption keys provided")
+	}
+
+	matched := -1
+	var plaintext []byte
+	lastErr := error(ErrCryptoFailure)
+
+	for i, key := range decryptionKeys {
+		var output []byte
+		var err error
+		if len(obj.recipients) == 1 {
+			output, err = obj.Decrypt(key)
+		} else {
+			_, _, output, err = obj.DecryptMulti(key)
+		}
+
+		if err == nil && matched == -1 {
+			matched = i
+			plaintext = output
+		} else if err != nil {
+			lastErr = err
+		}
+	}
+
+	if matched == -1 {
+		return -1, nil, lastErr
+	}
+	return matched, plaintext, nil
+}
+
+func (obj JSONWebEncryption) decrypt(ctx context.Context, decryptionKey interface{}, customDeriveECDHES CustomDeriveECDHES) ([]byte, error) {
+	plaintext, _, err := obj.decryptWithCEK(ctx, decryptionKey, customDeriveECDHES)
+	return plaintext, err
+}
+
+// decryptWithCEK is decrypt with the unwrapped or derived content encryption
+// key exposed alongside the plaintext. The key is returned whenever key
+// decryption succeeded, even when content decryption subsequently fails.
+func (obj JSONWebEncryption) decryptWithCEK(ctx context.Context, decryptionKey interface{}, customDeriveECDHES CustomDeriveECDHES) ([]byte, []byte, error) {
+	if err := validateRSAKeySize(decryptionKey, obj.minimumRSAKeySize); err != nil {
+		return nil, nil, err
+	}
+
+	headers := obj.mergedHeaders(nil)
+
+	if len(obj.recipients) > 1 {
+		return nil, nil, errors.New("square/go-jose: too many recipients in payload; expecting only one")
+	}
+
+	critical, err := headers.getCritical()
+	if err != nil {
+		return nil, nil, fmt.Errorf("square/go-jose: invalid crit header")
+	}
+
+	for _, name := range critical {
+		if !criticalUnderstood(obj.understoodCritical, name) {
+			return nil, nil, fmt.Errorf("square/go-jose: unsupported crit header")
+		}
+	}
+
+	if enc := headers.getEncryption(); !obj.contentEncryptionAllowed(enc) {
+		return nil, nil, fmt.Errorf("square/go-jose: content encryption algorithm '%s' is not on the allowlist", enc)
+	}
+	if alg := obj.mergedHeaders(&obj.recipients[0]).getAlgorithm(); !obj.keyAlgorithmAllowed(alg) {
+		return nil, nil, fmt.Errorf("square/go-jose: key algorithm '%s' is not on the allowlist", alg)
+	}
+	if obj.mergedHeaders(&obj.recipients[0]).getAlgorithm() == RSA1_5 && !obj.legacyRSA15Allowed() {
+		return nil, nil, ErrLegacyRSA15Disabled
+	}
+	if obj.protected != nil {
+		if err := validateCompression(obj.protected.getCompression()); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := validateExpectedType(obj.protected, obj.expectedType); err != nil {
+		return nil, nil, err
+	}
+	if err := obj.checkCiphertextLimit(); err != nil {
+		return nil, nil, err
+	}
+
+	decrypter, err := newDecrypter(decryptionKey, customDeriveECDHES)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if sym, ok := decrypter.(*symmetricKeyCipher); ok {
+		sym.minP2C = obj.minPBES2Count
+		sym.maxP2C = obj.maxPBES2Count
+	}
+
+	cipher := getContentCipher(headers.getEncryption())
+	if cipher == nil {
+		return nil, nil, fmt.Errorf("square/go-jose: unsupported enc value '%s': %w", string(headers.getEncryption()), ErrUnsupportedAlgorithm)
+	}
+
+	generator := randomKeyGenerator{
+		size: cipher.keySize(),
+	}
+
+	parts := &aeadParts{
+		iv:         obj.iv,
+		ciphertext: obj.ciphertext,
+		tag:        obj.tag,
+	}
+
+	authData := obj.computeAuthData()
+
+	var plaintext []byte
+	recipient := obj.recipients[0]
+	recipientHeaders := obj.mergedHeaders(&recipient)
+
+	cek, err := decryptKeyWithContext(ctx, decrypter, recipientHeaders, &recipient, generator)
+	if err == nil {
+		// Found a valid CEK -- let's try to decrypt.
+		plaintext, err = cipher.decrypt(cek, authData, parts)
+	} else if ctx.Err() != nil {
+		// Don't collapse cancellation into ErrCryptoFailure.
+		return nil, nil, ctx.Err()
+	} else if errors.Is(err, ErrInvalidKeySize) {
+		// A mis-sized key is a configuration problem on the caller's side,
+		// not an attacker-controlled condition; surface it as-is.
+		return nil, nil, err
+	}
+
+	if plaintext == nil {
+		return nil, cek, ErrCryptoFailure
+	}
+
+	// The "zip" header parameter may only be present in the protected header.
+	if comp := obj.protected.getCompression(); comp != "" {
+		plaintext, err = decompress(comp, plaintext, obj.maxDecompressedSize)
+	}
+
+	return plaintext, cek, err
+}
+
+// DecryptMulti decrypts and validates the object and returns the plaintexts,
+// with support for multiple recipients. It returns the index of the recipient
+// for which the decryption was successful, the merged headers for that recipient,
+// and the plaintext.
+func (obj JSONWebEncryption) DecryptMulti(decryptionKey interface{}) (int, Header, []byte, error) {
+	if err := validateRSAKeySize(decryptionKey, obj.minimumRSAKeySize); err != nil {
+		return -1, Header{}, nil, err
+	}
+
+	globalHeaders := obj.mergedHeaders(nil)
+
+	critical, err := globalHeaders.getCritical()
+	if err != nil {
+		return -1, Header{}, nil, fmt.Errorf("square/go-jose: invalid crit header")
+	}
+
+	for _, name := range critical {
+		if !criticalUnderstood(obj.understoodCritical, name) {
+			return -1, Header{}, nil, fmt.Errorf("square/go-jose: unsupported crit header")
+		}
+	}
+
+	if enc := globalHeaders.getEncryption(); !obj.contentEncryptionAllowed(enc) {
+		return -1, Header{}, nil, fmt.Errorf("square/go-jose: content encryption algorithm '%s' is not on the allowlist", enc)
+	}
+	if obj.protected != nil {
+		if err := validateCompression(obj.protected.getCompression()); err != nil {
+			return -1, Header{}, nil, err
+		}
+	}
+	if err := validateExpectedType(obj.protected, obj.expectedType); err != nil {
+		return -1, Header{}, nil, err
+	}
+	if err := obj.checkCiphertextLimit(); err != nil {
+		return -1, Header{}, nil, err
+	}
+
+	decrypter, err := newDecrypter(decryptionKey, nil)
+	if err != nil {
+		return -1, Header{}, nil, err
+	}
+
+	if sym, ok := decrypter.(*symmetricKeyCipher); ok {
+		sym.minP2C = obj.minPBES2Count
+		sym.maxP2C = obj.maxPBES2Count
+	}
+
+	encryption := globalHeaders.getEncryption()
+	cipher := getContentCipher(encryption)
+	if cipher == nil {
+		return -1, Header{}, nil, fmt.Errorf("square/go-jose: unsupported enc value '%s': %w", string(encryption), ErrUnsupportedAlgorithm)
+	}
+
+	generator := randomKeyGenerator{
+		size: cipher.keySize(),
+	}
+
+	parts := &aeadParts{
+		iv:         obj.iv,
+		ciphertext: obj.ciphertext,
+		tag:        obj.tag,
+	}
+
+	authData := obj.computeAuthData()
+
+	index := -1
+	var plaintext []byte
+	var headers rawHeader
+
+	for i, recipient := range obj.recipients {
+		recipientHeaders := obj.mergedHeaders(&recipient)
+		if !obj.keyAlgorithmAllowed(recipientHeaders.getAlgorithm()) {
+			continue
+		}
+		if recipientHeaders.getAlgorithm() == RSA1_5 && !obj.legacyRSA15Allowed() {
+			continue
+		}
+
+		cek, err := decrypter.decryptKey(recipientHeaders, &recipient, generator)
+		if err == nil {
+			// Found a valid CEK -- let's try to decrypt.
+			plaintext, err = cipher.decrypt(cek, authData, parts)
+			if err == nil {
+				index = i
+				headers = recipientHeaders
+				break
+			}
+		}
+	}
+
+	if plaintext == nil || err != nil {
+		return -1, Header{}, nil, ErrCryptoFailure
+	}
+
+	// The "zip" header parameter may only be present in the protected header.
+	if comp := obj.protected.getCompression(); comp != "" {
+		plaintext, err = decompress(comp, plaintext, obj.maxDecompressedSize)
+	}
+
+	sanitized, err := headers.sanitized()
+	if err != nil {
+		return -1, Header{}, nil, fmt.Errorf("square/go-jose: failed to sanitize header: %v", err)
+	}
+
+	return index, sanitized, plaintext, err
+}
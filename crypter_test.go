@@ -0,0 +1,2990 @@
+/*-
+ * Copyright 2014 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jose
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+
+	"gopkg.in/square/go-jose.v2/json"
+)
+
+// We generate only a single RSA and EC key for testing, speeds up tests.
+var rsaTestKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+
+var ecTestKey256, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+var ecTestKey384, _ = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+var ecTestKey521, _ = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+
+var ed25519PublicKey, ed25519PrivateKey, _ = ed25519.GenerateKey(rand.Reader)
+
+func RoundtripJWE(keyAlg KeyAlgorithm, encAlg ContentEncryption, compressionAlg CompressionAlgorithm, serializer func(*JSONWebEncryption) (string, error), corrupter func(*JSONWebEncryption) bool, aad []byte, encryptionKey interface{}, decryptionKey interface{}) error {
+	var rcpt Recipient
+	switch keyAlg {
+	case PBES2_HS256_A128KW, PBES2_HS384_A192KW, PBES2_HS512_A256KW:
+		// use 1k iterations instead of 100k to reduce computational cost
+		rcpt = Recipient{Algorithm: keyAlg, Key: encryptionKey, PBES2Count: 1000}
+	default:
+		rcpt = Recipient{Algorithm: keyAlg, Key: encryptionKey}
+	}
+	opts := &EncrypterOptions{Compression: compressionAlg}
+	if keyAlg == RSA1_5 {
+		opts.AllowLegacyRSA15 = true
+	}
+	enc, err := NewEncrypter(encAlg, rcpt, opts)
+	if err != nil {
+		return fmt.Errorf("error on new encrypter: %s", err)
+	}
+
+	input := []byte("Lorem ipsum dolor sit amet")
+	obj, err := enc.EncryptWithAuthData(input, aad)
+	if err != nil {
+		return fmt.Errorf("error in encrypt: %s", err)
+	}
+
+	msg, err := serializer(obj)
+	if err != nil {
+		return fmt.Errorf("error in serializer: %s", err)
+	}
+
+	parsed, err := ParseEncryptedWithOptions(msg, &DecrypterOptions{AllowLegacyRSA15: keyAlg == RSA1_5})
+	if err != nil {
+		return fmt.Errorf("error in parse: %s, on msg '%s'", err, msg)
+	}
+
+	// (Maybe) mangle object
+	skip := corrupter(parsed)
+	if skip {
+		return fmt.Errorf("corrupter indicated message should be skipped")
+	}
+
+	if bytes.Compare(parsed.GetAuthData(), aad) != 0 {
+		return fmt.Errorf("auth data in parsed object does not match")
+	}
+
+	output, err := parsed.Decrypt(decryptionKey)
+	if err != nil {
+		return fmt.Errorf("error on decrypt: %s", err)
+	}
+
+	if bytes.Compare(input, output) != 0 {
+		return fmt.Errorf("Decrypted output does not match input, got '%s' but wanted '%s'", output, input)
+	}
+
+	return nil
+}
+
+func TestRoundtripsJWE(t *testing.T) {
+	// Test matrix
+	keyAlgs := []KeyAlgorithm{
+		DIRECT, ECDH_ES, ECDH_ES_A128KW, ECDH_ES_A192KW, ECDH_ES_A256KW, A128KW, A192KW, A256KW,
+		RSA1_5, RSA_OAEP, RSA_OAEP_256, RSA_OAEP_384, RSA_OAEP_512,
+		A128GCMKW, A192GCMKW, A256GCMKW,
+		PBES2_HS256_A128KW, PBES2_HS384_A192KW, PBES2_HS512_A256KW,
+	}
+	encAlgs := []ContentEncryption{A128GCM, A192GCM, A256GCM, A128CBC_HS256, A192CBC_HS384, A256CBC_HS512, XC20P}
+	zipAlgs := []CompressionAlgorithm{NONE, DEFLATE}
+
+	serializers := []func(*JSONWebEncryption) (string, error){
+		func(obj *JSONWebEncryption) (string, error) { return obj.CompactSerialize() },
+		func(obj *JSONWebEncryption) (string, error) { return obj.FullSerialize(), nil },
+	}
+
+	corrupter := func(obj *JSONWebEncryption) bool { return false }
+
+	// Note: can't use AAD with compact serialization
+	aads := [][]byte{
+		nil,
+		[]byte("Ut enim ad minim veniam"),
+	}
+
+	// Test all different configurations
+	for _, alg := range keyAlgs {
+		for _, enc := range encAlgs {
+			for _, key := range generateTestKeys(alg, enc) {
+				for _, zip := range zipAlgs {
+					for i, serializer := range serializers {
+						err := RoundtripJWE(alg, enc, zip, serializer, corrupter, aads[i], key.enc, key.dec)
+						if err != nil {
+							t.Error(err, alg, enc, zip, i)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestRoundtripsJWEX25519(t *testing.T) {
+	keyAlgs := []KeyAlgorithm{ECDH_ES, ECDH_ES_A128KW, ECDH_ES_A192KW, ECDH_ES_A256KW}
+	encAlgs := []ContentEncryption{A128GCM, A192GCM, A256GCM, A128CBC_HS256, A192CBC_HS384, A256CBC_HS512, XC20P}
+	zipAlgs := []CompressionAlgorithm{NONE, DEFLATE}
+
+	serializers := []func(*JSONWebEncryption) (string, error){
+		func(obj *JSONWebEncryption) (string, error) { return obj.CompactSerialize() },
+		func(obj *JSONWebEncryption) (string, error) { return obj.FullSerialize(), nil },
+	}
+
+	corrupter := func(obj *JSONWebEncryption) bool { return false }
+
+	x25519Key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, alg := range keyAlgs {
+		for _, enc := range encAlgs {
+			for _, zip := range zipAlgs {
+				for _, serializer := range serializers {
+					err := RoundtripJWE(alg, enc, zip, serializer, corrupter, nil, x25519Key.PublicKey(), x25519Key)
+					if err != nil {
+						t.Error(err, alg, enc, zip)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestRoundtripsJWECorrupted(t *testing.T) {
+	// Test matrix
+	keyAlgs := []KeyAlgorithm{DIRECT, ECDH_ES, ECDH_ES_A128KW, A128KW, RSA1_5, RSA_OAEP, RSA_OAEP_256, A128GCMKW, PBES2_HS256_A128KW}
+	encAlgs := []ContentEncryption{A128GCM, A192GCM, A256GCM, A128CBC_HS256, A192CBC_HS384, A256CBC_HS512, XC20P}
+	zipAlgs := []CompressionAlgorithm{NONE, DEFLATE}
+
+	serializers := []func(*JSONWebEncryption) (string, error){
+		func(obj *JSONWebEncryption) (string, error) { return obj.CompactSerialize() },
+		func(obj *JSONWebEncryption) (string, error) { return obj.FullSerialize(), nil },
+	}
+
+	bitflip := func(slice []byte) bool {
+		if len(slice) > 0 {
+			slice[0] ^= 0xFF
+			return false
+		}
+		return true
+	}
+
+	corrupters := []func(*JSONWebEncryption) bool{
+		func(obj *JSONWebEncryption) bool {
+			// Set invalid ciphertext
+			return bitflip(obj.ciphertext)
+		},
+		func(obj *JSONWebEncryption) bool {
+			// Set invalid auth tag
+			return bitflip(obj.tag)
+		},
+		func(obj *JSONWebEncryption) bool {
+			// Set invalid AAD
+			return bitflip(obj.aad)
+		},
+		func(obj *JSONWebEncryption) bool {
+			// Mess with encrypted key
+			return bitflip(obj.recipients[0].encryptedKey)
+		},
+		func(obj *JSONWebEncryption) bool {
+			// Mess with GCM-KW auth tag
+			tag, _ := obj.protected.getTag()
+			skip := bitflip(tag.bytes())
+			if skip {
+				return true
+			}
+			obj.protected.set(headerTag, tag)
+			return false
+		},
+	}
+
+	// Note: can't use AAD with compact serialization
+	aads := [][]byte{
+		nil,
+		[]byte("Ut enim ad minim veniam"),
+	}
+
+	// Test all different configurations
+	for _, alg := range keyAlgs {
+		for _, enc := range encAlgs {
+			for _, key := range generateTestKeys(alg, enc) {
+				for _, zip := range zipAlgs {
+					for i, serializer := range serializers {
+						for j, corrupter := range corrupters {
+							err := RoundtripJWE(alg, enc, zip, serializer, corrupter, aads[i], key.enc, key.dec)
+							if err == nil {
+								t.Error("failed to detect corrupt data", err, alg, enc, zip, i, j)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestRSA15DisabledByDefault(t *testing.T) {
+	rcpt := Recipient{Algorithm: RSA1_5, Key: &rsaTestKey.PublicKey}
+
+	// Encrypting to RSA1_5 requires an explicit opt-in.
+	_, err := NewEncrypter(A128CBC_HS256, rcpt, nil)
+	if err != ErrLegacyRSA15Disabled {
+		t.Errorf("expected ErrLegacyRSA15Disabled from NewEncrypter, got %v", err)
+	}
+	_, err = NewMultiEncrypter(A128CBC_HS256, []Recipient{rcpt}, nil)
+	if err != ErrLegacyRSA15Disabled {
+		t.Errorf("expected ErrLegacyRSA15Disabled from NewMultiEncrypter, got %v", err)
+	}
+
+	enc, err := NewEncrypter(A128CBC_HS256, rcpt, &EncrypterOptions{AllowLegacyRSA15: true})
+	if err != nil {
+		t.Fatal("error on new encrypter:", err)
+	}
+	obj, err := enc.Encrypt([]byte("Lorem ipsum dolor sit amet"))
+	if err != nil {
+		t.Fatal("error in encrypt:", err)
+	}
+	msg, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal("error in serialize:", err)
+	}
+
+	// Decrypting an RSA1_5 message requires an opt-in too, either through
+	// the explicit option or by allowlisting the algorithm.
+	parsed, err := ParseEncrypted(msg)
+	if err != nil {
+		t.Fatal("error in parse:", err)
+	}
+	_, err = parsed.Decrypt(rsaTestKey)
+	if err != ErrLegacyRSA15Disabled {
+		t.Errorf("expected ErrLegacyRSA15Disabled from Decrypt, got %v", err)
+	}
+
+	for _, opts := range []*DecrypterOptions{
+		{AllowLegacyRSA15: true},
+		{AllowedKeyAlgorithms: []KeyAlgorithm{RSA1_5}},
+	} {
+		parsed, err := ParseEncryptedWithOptions(msg, opts)
+		if err != nil {
+			t.Fatal("error in parse:", err)
+		}
+		out, err := parsed.Decrypt(rsaTestKey)
+		if err != nil {
+			t.Errorf("error on decrypt with opt-in %+v: %v", opts, err)
+		} else if string(out) != "Lorem ipsum dolor sit amet" {
+			t.Errorf("decrypted output does not match input, got '%s'", out)
+		}
+	}
+}
+
+func TestEncrypterWithJWKAndKeyID(t *testing.T) {
+	enc, err := NewEncrypter(A128GCM, Recipient{Algorithm: A128KW, Key: &JSONWebKey{
+		KeyID: "test-id",
+		Key:   []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	}}, nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	ciphertext, _ := enc.Encrypt([]byte("Lorem ipsum dolor sit amet"))
+
+	serialized1, _ := ciphertext.CompactSerialize()
+	serialized2 := ciphertext.FullSerialize()
+
+	parsed1, _ := ParseEncrypted(serialized1)
+	parsed2, _ := ParseEncrypted(serialized2)
+
+	if parsed1.Header.KeyID != "test-id" {
+		t.Errorf("expected message to have key id from JWK, but found '%s' instead", parsed1.Header.KeyID)
+	}
+	if parsed2.Header.KeyID != "test-id" {
+		t.Errorf("expected message to have key id from JWK, but found '%s' instead", parsed2.Header.KeyID)
+	}
+}
+
+func TestEncrypterWithBrokenRand(t *testing.T) {
+	keyAlgs := []KeyAlgorithm{ECDH_ES_A128KW, A128KW, RSA1_5, RSA_OAEP, RSA_OAEP_256, A128GCMKW, PBES2_HS256_A128KW}
+	encAlgs := []ContentEncryption{A128GCM, A192GCM, A256GCM, A128CBC_HS256, A192CBC_HS384, A256CBC_HS512, XC20P}
+
+	serializer := func(obj *JSONWebEncryption) (string, error) { return obj.CompactSerialize() }
+	corrupter := func(obj *JSONWebEncryption) bool { return false }
+
+	// Break rand reader
+	readers := []func() io.Reader{
+		// Totally broken
+		func() io.Reader { return bytes.NewReader([]byte{}) },
+		// Not enough bytes
+		func() io.Reader { return io.LimitReader(rand.Reader, 20) },
+	}
+
+	defer resetRandReader()
+
+	for _, alg := range keyAlgs {
+		for _, enc := range encAlgs {
+			for _, key := range generateTestKeys(alg, enc) {
+				for i, getReader := range readers {
+					RandReader = getReader()
+					err := RoundtripJWE(alg, enc, NONE, serializer, corrupter, nil, key.enc, key.dec)
+					if err == nil {
+						t.Error("encrypter should fail if rand is broken", i)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestNewEncrypterErrors(t *testing.T) {
+	_, err := NewEncrypter("XYZ", Recipient{}, nil)
+	if err == nil {
+		t.Error("was able to instantiate encrypter with invalid cipher")
+	}
+
+	_, err = NewMultiEncrypter("XYZ", []Recipient{}, nil)
+	if err == nil {
+		t.Error("was able to instantiate multi-encrypter with invalid cipher")
+	}
+
+	_, err = NewEncrypter(A128GCM, Recipient{Algorithm: DIRECT, Key: nil}, nil)
+	if err == nil {
+		t.Error("was able to instantiate encrypter with invalid direct key")
+	}
+
+	_, err = NewEncrypter(A128GCM, Recipient{Algorithm: ECDH_ES, Key: nil}, nil)
+	if err == nil {
+		t.Error("was able to instantiate encrypter with invalid EC key")
+	}
+}
+
+func TestMultiRecipientJWE(t *testing.T) {
+	sharedKey := []byte{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+	}
+
+	enc, err := NewMultiEncrypter(A128GCM, []Recipient{
+		{Algorithm: RSA_OAEP, Key: &rsaTestKey.PublicKey},
+		{Algorithm: A256GCMKW, Key: sharedKey},
+	}, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	input := []byte("Lorem ipsum dolor sit amet")
+	obj, err := enc.Encrypt(input)
+	if err != nil {
+		t.Fatal("error in encrypt: ", err)
+	}
+
+	msg := obj.FullSerialize()
+
+	parsed, err := ParseEncrypted(msg)
+	if err != nil {
+		t.Fatal("error in parse: ", err)
+	}
+
+	i, _, output, err := parsed.DecryptMulti(rsaTestKey)
+	if err != nil {
+		t.Fatal("error on decrypt with RSA: ", err)
+	}
+
+	if i != 0 {
+		t.Fatal("recipient index should be 0 for RSA key")
+	}
+
+	if bytes.Compare(input, output) != 0 {
+		t.Fatal("Decrypted output does not match input: ", output, input)
+	}
+
+	i, _, output, err = parsed.DecryptMulti(sharedKey)
+	if err != nil {
+		t.Fatal("error on decrypt with AES: ", err)
+	}
+
+	if i != 1 {
+		t.Fatal("recipient index should be 1 for shared key")
+	}
+
+	if bytes.Compare(input, output) != 0 {
+		t.Fatal("Decrypted output does not match input", output, input)
+	}
+}
+
+func TestMultiRecipientJWERSAAndECDH(t *testing.T) {
+	enc, err := NewMultiEncrypter(A128GCM, []Recipient{
+		{Algorithm: RSA_OAEP, Key: &rsaTestKey.PublicKey},
+		{Algorithm: ECDH_ES_A128KW, Key: &ecTestKey256.PublicKey},
+	}, nil)
+	if err != nil {
+		t.Fatal("error in NewMultiEncrypter: ", err)
+	}
+
+	input := []byte("Lorem ipsum dolor sit amet")
+	obj, err := enc.Encrypt(input)
+	if err != nil {
+		t.Fatal("error in encrypt: ", err)
+	}
+
+	parsed, err := ParseEncrypted(obj.FullSerialize())
+	if err != nil {
+		t.Fatal("error in parse: ", err)
+	}
+
+	i, _, output, err := parsed.DecryptMulti(rsaTestKey)
+	if err != nil {
+		t.Fatal("error on decrypt with RSA: ", err)
+	}
+
+	if i != 0 {
+		t.Fatal("recipient index should be 0 for RSA key")
+	}
+
+	if bytes.Compare(input, output) != 0 {
+		t.Fatal("Decrypted output does not match input: ", output, input)
+	}
+
+	i, _, output, err = parsed.DecryptMulti(ecTestKey256)
+	if err != nil {
+		t.Fatal("error on decrypt with EC: ", err)
+	}
+
+	if i != 1 {
+		t.Fatal("recipient index should be 1 for EC key")
+	}
+
+	if bytes.Compare(input, output) != 0 {
+		t.Fatal("Decrypted output does not match input", output, input)
+	}
+}
+
+func TestMultiRecipientErrors(t *testing.T) {
+	_, err := NewMultiEncrypter(A128GCM, []Recipient{}, nil)
+	if err == nil {
+		t.Error("should fail to instantiate with zero recipients")
+	}
+
+	// Direct key agreement derives the CEK from the recipient key, so it
+	// cannot share a CEK with other recipients.
+	_, err = NewMultiEncrypter(A128GCM, []Recipient{
+		{Algorithm: RSA_OAEP, Key: &rsaTestKey.PublicKey},
+		{Algorithm: ECDH_ES, Key: &ecTestKey256.PublicKey},
+	}, nil)
+	if err == nil {
+		t.Error("should fail to instantiate with direct ECDH-ES recipient")
+	}
+}
+
+func TestCompactSerializeWithAAD(t *testing.T) {
+	enc, err := NewEncrypter(A128GCM, Recipient{Algorithm: RSA_OAEP, Key: &rsaTestKey.PublicKey}, nil)
+	if err != nil {
+		t.Fatal("error in NewEncrypter: ", err)
+	}
+
+	input := []byte("Lorem ipsum dolor sit amet")
+	aad := []byte("Ut enim ad minim veniam")
+
+	obj, err := enc.EncryptWithAuthData(input, aad)
+	if err != nil {
+		t.Fatal("error in encrypt: ", err)
+	}
+
+	// Explicit AAD cannot be represented in compact serialization.
+	if _, err := obj.CompactSerialize(); err != ErrNotSupported {
+		t.Error("compact serialization should be unsupported with AAD, got", err)
+	}
+
+	// The full serialization carries the aad field and still round-trips.
+	parsed, err := ParseEncrypted(obj.FullSerialize())
+	if err != nil {
+		t.Fatal("error in parse: ", err)
+	}
+
+	output, err := parsed.Decrypt(rsaTestKey)
+	if err != nil {
+		t.Fatal("error on decrypt: ", err)
+	}
+
+	if bytes.Compare(input, output) != 0 {
+		t.Fatal("Decrypted output does not match input: ", output, input)
+	}
+
+	if bytes.Compare(parsed.GetAuthData(), aad) != 0 {
+		t.Fatal("Auth data does not match input: ", parsed.GetAuthData(), aad)
+	}
+}
+
+func TestEncrypterOptions(t *testing.T) {
+	sharedKey := []byte{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+	}
+
+	opts := &EncrypterOptions{
+		Compression: DEFLATE,
+	}
+	opts.WithType("JWT")
+	opts.WithContentType("JWT")
+	enc, err := NewEncrypter(A256GCM, Recipient{Algorithm: A256GCMKW, Key: sharedKey}, opts)
+	if err != nil {
+		fmt.Println(err)
+		t.Error("Failed to create encrypter")
+	}
+
+	if !reflect.DeepEqual(*opts, enc.Options()) {
+		t.Error("Encrypter options do not match")
+	}
+}
+
+// Test that extra headers are generated and parsed in a round trip.
+func TestEncrypterExtraHeaderInclusion(t *testing.T) {
+	sharedKey := []byte{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+	}
+
+	opts := &EncrypterOptions{
+		Compression: DEFLATE,
+	}
+	opts.WithType("JWT")
+	opts.WithContentType("JWT")
+	opts.WithHeader(HeaderKey("myCustomHeader"), "xyz")
+	enc, err := NewEncrypter(A256GCM, Recipient{Algorithm: A256GCMKW, Key: sharedKey}, opts)
+	if err != nil {
+		fmt.Println(err)
+		t.Error("Failed to create encrypter")
+	}
+
+	if !reflect.DeepEqual(*opts, enc.Options()) {
+		t.Error("Encrypter options do not match")
+	}
+
+	input := []byte("Lorem ipsum dolor sit amet")
+	obj, err := enc.Encrypt(input)
+	if err != nil {
+		t.Fatal("error in encrypt: ", err)
+	}
+
+	parsed, err := ParseEncrypted(obj.FullSerialize())
+	if err != nil {
+		t.Fatal("error in parse: ", err)
+	}
+
+	output, err := parsed.Decrypt(sharedKey)
+	if err != nil {
+		t.Fatal("error on decrypt: ", err)
+	}
+
+	if bytes.Compare(input, output) != 0 {
+		t.Fatal("Decrypted output does not match input: ", output, input)
+	}
+
+	if parsed.Header.ExtraHeaders[HeaderType] != "JWT" ||
+		parsed.Header.ContentType != "JWT" ||
+		parsed.Header.ExtraHeaders[HeaderKey("myCustomHeader")] != "xyz" {
+		t.Fatalf("Mismatch in headers: %#v", parsed.Header)
+	}
+
+	if v, ok := parsed.Header.Get(HeaderKey("myCustomHeader")); !ok || v != "xyz" {
+		t.Fatalf("Custom header lookup failed, got %#v (present: %v)", v, ok)
+	}
+}
+
+// TestPBES2JWKEncryption uses the plaintext and serialization reference of
+// JWK RFC https://tools.ietf.org/html/rfc7517#appendix-C.4
+func TestPBES2JWKEncryption(t *testing.T) {
+	passphrase := []byte("Thus from my lips, by yours, my sin is purged.")
+
+	plaintext := []byte(`{
+      "kty":"RSA",
+      "kid":"juliet@capulet.lit",
+      "use":"enc",
+      "n":"t6Q8PWSi1dkJj9hTP8hNYFlvadM7DflW9mWepOJhJ66w7nyoK1gPNqFMSQRy
+           O125Gp-TEkodhWr0iujjHVx7BcV0llS4w5ACGgPrcAd6ZcSR0-Iqom-QFcNP
+           8Sjg086MwoqQU_LYywlAGZ21WSdS_PERyGFiNnj3QQlO8Yns5jCtLCRwLHL0
+           Pb1fEv45AuRIuUfVcPySBWYnDyGxvjYGDSM-AqWS9zIQ2ZilgT-GqUmipg0X
+           OC0Cc20rgLe2ymLHjpHciCKVAbY5-L32-lSeZO-Os6U15_aXrk9Gw8cPUaX1
+           _I8sLGuSiVdt3C_Fn2PZ3Z8i744FPFGGcG1qs2Wz-Q",
+      "e":"AQAB",
+      "d":"GRtbIQmhOZtyszfgKdg4u_N-R_mZGU_9k7JQ_jn1DnfTuMdSNprTeaSTyWfS
+           NkuaAwnOEbIQVy1IQbWVV25NY3ybc_IhUJtfri7bAXYEReWaCl3hdlPKXy9U
+           vqPYGR0kIXTQRqns-dVJ7jahlI7LyckrpTmrM8dWBo4_PMaenNnPiQgO0xnu
+           ToxutRZJfJvG4Ox4ka3GORQd9CsCZ2vsUDmsXOfUENOyMqADC6p1M3h33tsu
+           rY15k9qMSpG9OX_IJAXmxzAh_tWiZOwk2K4yxH9tS3Lq1yX8C1EWmeRDkK2a
+           hecG85-oLKQt5VEpWHKmjOi_gJSdSgqcN96X52esAQ",
+      "p":"2rnSOV4hKSN8sS4CgcQHFbs08XboFDqKum3sc4h3GRxrTmQdl1ZK9uw-PIHf
+           QP0FkxXVrx-WE-ZEbrqivH_2iCLUS7wAl6XvARt1KkIaUxPPSYB9yk31s0Q8
+           UK96E3_OrADAYtAJs-M3JxCLfNgqh56HDnETTQhH3rCT5T3yJws",
+      "q":"1u_RiFDP7LBYh3N4GXLT9OpSKYP0uQZyiaZwBtOCBNJgQxaj10RWjsZu0c6I
+           edis4S7B_coSKB0Kj9PaPaBzg-IySRvvcQuPamQu66riMhjVtG6TlV8CLCYK
+           rYl52ziqK0E_ym2QnkwsUX7eYTB7LbAHRK9GqocDE5B0f808I4s",
+      "dp":"KkMTWqBUefVwZ2_Dbj1pPQqyHSHjj90L5x_MOzqYAJMcLMZtbUtwKqvVDq3
+           tbEo3ZIcohbDtt6SbfmWzggabpQxNxuBpoOOf_a_HgMXK_lhqigI4y_kqS1w
+           Y52IwjUn5rgRrJ-yYo1h41KR-vz2pYhEAeYrhttWtxVqLCRViD6c",
+      "dq":"AvfS0-gRxvn0bwJoMSnFxYcK1WnuEjQFluMGfwGitQBWtfZ1Er7t1xDkbN9
+           GQTB9yqpDoYaN06H7CFtrkxhJIBQaj6nkF5KKS3TQtQ5qCzkOkmxIe3KRbBy
+           mXxkb5qwUpX5ELD5xFc6FeiafWYY63TmmEAu_lRFCOJ3xDea-ots",
+      "qi":"lSQi-w9CpyUReMErP1RsBLk7wNtOvs5EQpPqmuMvqW57NBUczScEoPwmUqq
+           abu9V0-Py4dQ57_bapoKRu1R90bvuFnU63SHWEFglZQvJDMeAvmj4sm-Fp0o
+           Yu_neotgQ0hzbI5gry7ajdYy9-2lNx_76aBZoOUu9HCJ-UsfSOI8"
+     }`)
+
+	serializationReference := `
+	  eyJhbGciOiJQQkVTMi1IUzI1NitBMTI4S1ciLCJwMnMiOiIyV0NUY0paMVJ2ZF9DSn
+	  VKcmlwUTF3IiwicDJjIjo0MDk2LCJlbmMiOiJBMTI4Q0JDLUhTMjU2IiwiY3R5Ijoi
+	  andrK2pzb24ifQ.
+	  TrqXOwuNUfDV9VPTNbyGvEJ9JMjefAVn-TR1uIxR9p6hsRQh9Tk7BA.
+	  Ye9j1qs22DmRSAddIh-VnA.
+	  AwhB8lxrlKjFn02LGWEqg27H4Tg9fyZAbFv3p5ZicHpj64QyHC44qqlZ3JEmnZTgQo
+	  wIqZJ13jbyHB8LgePiqUJ1hf6M2HPLgzw8L-mEeQ0jvDUTrE07NtOerBk8bwBQyZ6g
+	  0kQ3DEOIglfYxV8-FJvNBYwbqN1Bck6d_i7OtjSHV-8DIrp-3JcRIe05YKy3Oi34Z_
+	  GOiAc1EK21B11c_AE11PII_wvvtRiUiG8YofQXakWd1_O98Kap-UgmyWPfreUJ3lJP
+	  nbD4Ve95owEfMGLOPflo2MnjaTDCwQokoJ_xplQ2vNPz8iguLcHBoKllyQFJL2mOWB
+	  wqhBo9Oj-O800as5mmLsvQMTflIrIEbbTMzHMBZ8EFW9fWwwFu0DWQJGkMNhmBZQ-3
+	  lvqTc-M6-gWA6D8PDhONfP2Oib2HGizwG1iEaX8GRyUpfLuljCLIe1DkGOewhKuKkZ
+	  h04DKNM5Nbugf2atmU9OP0Ldx5peCUtRG1gMVl7Qup5ZXHTjgPDr5b2N731UooCGAU
+	  qHdgGhg0JVJ_ObCTdjsH4CF1SJsdUhrXvYx3HJh2Xd7CwJRzU_3Y1GxYU6-s3GFPbi
+	  rfqqEipJDBTHpcoCmyrwYjYHFgnlqBZRotRrS95g8F95bRXqsaDY7UgQGwBQBwy665
+	  d0zpvTasvfXf_c0MWAl-neFaKOW_Px6g4EUDjG1GWSXV9cLStLw_0ovdApDIFLHYHe
+	  PyagyHjouQUuGiq7BsYwYrwaF06tgB8hV8omLNfMEmDPJaZUzMuHw6tBDwGkzD-tS_
+	  ub9hxrpJ4UsOWnt5rGUyoN2N_c1-TQlXxm5oto14MxnoAyBQBpwIEgSH3Y4ZhwKBhH
+	  PjSo0cdwuNdYbGPpb-YUvF-2NZzODiQ1OvWQBRHSbPWYz_xbGkgD504LRtqRwCO7CC
+	  _CyyURi1sEssPVsMJRX_U4LFEOc82TiDdqjKOjRUfKK5rqLi8nBE9soQ0DSaOoFQZi
+	  GrBrqxDsNYiAYAmxxkos-i3nX4qtByVx85sCE5U_0MqG7COxZWMOPEFrDaepUV-cOy
+	  rvoUIng8i8ljKBKxETY2BgPegKBYCxsAUcAkKamSCC9AiBxA0UOHyhTqtlvMksO7AE
+	  hNC2-YzPyx1FkhMoS4LLe6E_pFsMlmjA6P1NSge9C5G5tETYXGAn6b1xZbHtmwrPSc
+	  ro9LWhVmAaA7_bxYObnFUxgWtK4vzzQBjZJ36UTk4OTB-JvKWgfVWCFsaw5WCHj6Oo
+	  4jpO7d2yN7WMfAj2hTEabz9wumQ0TMhBduZ-QON3pYObSy7TSC1vVme0NJrwF_cJRe
+	  hKTFmdlXGVldPxZCplr7ZQqRQhF8JP-l4mEQVnCaWGn9ONHlemczGOS-A-wwtnmwjI
+	  B1V_vgJRf4FdpV-4hUk4-QLpu3-1lWFxrtZKcggq3tWTduRo5_QebQbUUT_VSCgsFc
+	  OmyWKoj56lbxthN19hq1XGWbLGfrrR6MWh23vk01zn8FVwi7uFwEnRYSafsnWLa1Z5
+	  TpBj9GvAdl2H9NHwzpB5NqHpZNkQ3NMDj13Fn8fzO0JB83Etbm_tnFQfcb13X3bJ15
+	  Cz-Ww1MGhvIpGGnMBT_ADp9xSIyAM9dQ1yeVXk-AIgWBUlN5uyWSGyCxp0cJwx7HxM
+	  38z0UIeBu-MytL-eqndM7LxytsVzCbjOTSVRmhYEMIzUAnS1gs7uMQAGRdgRIElTJE
+	  SGMjb_4bZq9s6Ve1LKkSi0_QDsrABaLe55UY0zF4ZSfOV5PMyPtocwV_dcNPlxLgNA
+	  D1BFX_Z9kAdMZQW6fAmsfFle0zAoMe4l9pMESH0JB4sJGdCKtQXj1cXNydDYozF7l8
+	  H00BV_Er7zd6VtIw0MxwkFCTatsv_R-GsBCH218RgVPsfYhwVuT8R4HarpzsDBufC4
+	  r8_c8fc9Z278sQ081jFjOja6L2x0N_ImzFNXU6xwO-Ska-QeuvYZ3X_L31ZOX4Llp-
+	  7QSfgDoHnOxFv1Xws-D5mDHD3zxOup2b2TppdKTZb9eW2vxUVviM8OI9atBfPKMGAO
+	  v9omA-6vv5IxUH0-lWMiHLQ_g8vnswp-Jav0c4t6URVUzujNOoNd_CBGGVnHiJTCHl
+	  88LQxsqLHHIu4Fz-U2SGnlxGTj0-ihit2ELGRv4vO8E1BosTmf0cx3qgG0Pq0eOLBD
+	  IHsrdZ_CCAiTc0HVkMbyq1M6qEhM-q5P6y1QCIrwg.
+	  0HFmhOzsQ98nNWJjIHkR7A`
+
+	// remove white spaces and line breaks
+	r := regexp.MustCompile(`\s`)
+	plaintext = r.ReplaceAll(plaintext, []byte(""))
+	serializationReference = r.ReplaceAllString(serializationReference, "")
+
+	rcpt := Recipient{
+		Algorithm:  PBES2_HS256_A128KW,
+		Key:        passphrase,
+		PBES2Count: 4096,
+		PBES2Salt: []byte{
+			217, 96, 147, 112, 150, 117, 70,
+			247, 127, 8, 155, 137, 174, 42, 80, 215,
+		},
+	}
+
+	enc, err := NewEncrypter(A128CBC_HS256, rcpt, nil)
+	if err != nil {
+		t.Fatal("error on NewEncrypter:", err)
+	}
+
+	obj, err := enc.Encrypt(plaintext)
+	if err != nil {
+		t.Fatal("error on new Encrypt:", err)
+	}
+
+	serialized, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal("error on CompactSerialize")
+	}
+
+	jwe1, err := ParseEncrypted(serialized)
+	if err != nil {
+		t.Fatal("error in ParseEncrypted")
+	}
+
+	jwe2, err := ParseEncrypted(serializationReference)
+	if err != nil {
+		t.Fatal("error in ParseEncrypted")
+	}
+
+	original1, err := jwe1.Decrypt(passphrase)
+	if err != nil {
+		t.Fatal("error in Decrypt:", err)
+	}
+
+	original2, err := jwe2.Decrypt(passphrase)
+	if err != nil {
+		t.Fatal("error in Decrypt reference:", err)
+	}
+
+	if bytes.Compare(original1, original2) != 0 {
+		t.Error("decryption does not match reference decryption")
+	}
+
+	if bytes.Compare(plaintext, original1) != 0 {
+		t.Error("decryption does not match plaintext")
+	}
+
+	if bytes.Compare(plaintext, original2) != 0 {
+		t.Error("reference decryption does not match plaintext")
+	}
+}
+
+func TestEncrypterWithPBES2(t *testing.T) {
+	expected := []byte("Lorem ipsum dolor sit amet")
+	algs := []KeyAlgorithm{
+		PBES2_HS256_A128KW, PBES2_HS384_A192KW, PBES2_HS512_A256KW,
+	}
+
+	// Check with both strings and []byte
+	recipientKeys := []interface{}{"password", []byte("password")}
+	for _, key := range recipientKeys {
+		for _, alg := range algs {
+			enc, err := NewEncrypter(A128GCM, Recipient{Algorithm: alg, Key: &JSONWebKey{
+				KeyID: "test-id",
+				Key:   key,
+			}}, nil)
+			if err != nil {
+				t.Error(err)
+			}
+
+			ciphertext, _ := enc.Encrypt(expected)
+
+			serialized1, _ := ciphertext.CompactSerialize()
+			serialized2 := ciphertext.FullSerialize()
+
+			parsed1, _ := ParseEncrypted(serialized1)
+			parsed2, _ := ParseEncrypted(serialized2)
+
+			actual1, err := parsed1.Decrypt("password")
+			if err != nil {
+				t.Fatal("error on Decrypt:", err)
+			}
+
+			actual2, err := parsed2.Decrypt([]byte("password"))
+			if err != nil {
+				t.Fatal("error on Decrypt:", err)
+			}
+
+			if bytes.Compare(actual1, expected) != 0 {
+				t.Errorf("error comparing decrypted message (%s) and expected (%s)", actual1, expected)
+			}
+
+			if bytes.Compare(actual2, expected) != 0 {
+				t.Errorf("error comparing decrypted message (%s) and expected (%s)", actual2, expected)
+			}
+		}
+	}
+}
+
+type testKey struct {
+	enc, dec interface{}
+}
+
+func symmetricTestKey(size int) []testKey {
+	key, _, _ := randomKeyGenerator{size: size}.genKey()
+
+	return []testKey{
+		{
+			enc: key,
+			dec: key,
+		},
+		{
+			enc: &JSONWebKey{KeyID: "test", Key: key},
+			dec: &JSONWebKey{KeyID: "test", Key: key},
+		},
+	}
+}
+
+func TestDirectEncryptionKeySizeCheck(t *testing.T) {
+	// 16-byte key
+	key16 := []byte("0123456789ABCDEF")
+
+	// 32-byte key
+	key32 := []byte("0123456789ABCDEF0123456789ABCDEF")
+
+	// AES-128 with 32-byte key should reject
+	_, err := NewEncrypter(A128GCM, Recipient{Algorithm: DIRECT, Key: key32}, nil)
+	if !errors.Is(err, ErrInvalidKeySize) {
+		t.Error("Should reject AES-128 with 32-byte key")
+	}
+
+	// AES-256 with 16-byte key should reject
+	_, err = NewEncrypter(A256GCM, Recipient{Algorithm: DIRECT, Key: key16}, nil)
+	if !errors.Is(err, ErrInvalidKeySize) {
+		t.Error("Should reject AES-256 with 16-byte key")
+	}
+}
+
+func TestDirectEncryptionKeyLengths(t *testing.T) {
+	cases := []struct {
+		enc     ContentEncryption
+		keySize int
+	}{
+		{A128GCM, 16},
+		{A192GCM, 24},
+		{A256GCM, 32},
+		{A128CBC_HS256, 32},
+		{A192CBC_HS384, 48},
+		{A256CBC_HS512, 64},
+	}
+
+	for _, tc := range cases {
+		// A key of the exact required length is accepted.
+		_, err := NewEncrypter(tc.enc, Recipient{Algorithm: DIRECT, Key: make([]byte, tc.keySize)}, nil)
+		if err != nil {
+			t.Errorf("%s: error with %d-byte key: %v", tc.enc, tc.keySize, err)
+		}
+
+		// A key of the wrong length is rejected with an error naming the
+		// expected length.
+		_, err = NewEncrypter(tc.enc, Recipient{Algorithm: DIRECT, Key: make([]byte, tc.keySize/2)}, nil)
+		if !errors.Is(err, ErrInvalidKeySize) {
+			t.Errorf("%s: expected ErrInvalidKeySize with %d-byte key, got %v", tc.enc, tc.keySize/2, err)
+			continue
+		}
+		expected := fmt.Sprintf("requires a %d-byte key, got %d bytes", tc.keySize, tc.keySize/2)
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: error does not name the expected key length: %v", tc.enc, err)
+		}
+	}
+}
+
+func generateTestKeys(keyAlg KeyAlgorithm, encAlg ContentEncryption) []testKey {
+	switch keyAlg {
+	case DIRECT:
+		return symmetricTestKey(getContentCipher(encAlg).keySize())
+	case ECDH_ES, ECDH_ES_A128KW, ECDH_ES_A192KW, ECDH_ES_A256KW:
+		return []testKey{
+			{
+				dec: ecTestKey256,
+				enc: &ecTestKey256.PublicKey,
+			},
+			{
+				dec: ecTestKey384,
+				enc: &ecTestKey384.PublicKey,
+			},
+			{
+				dec: ecTestKey521,
+				enc: &ecTestKey521.PublicKey,
+			},
+			{
+				dec: &JSONWebKey{KeyID: "test", Key: ecTestKey256},
+				enc: &JSONWebKey{KeyID: "test", Key: &ecTestKey256.PublicKey},
+			},
+		}
+	case A128GCMKW, A128KW:
+		return symmetricTestKey(16)
+	case A192GCMKW, A192KW:
+		return symmetricTestKey(24)
+	case A256GCMKW, A256KW:
+		return symmetricTestKey(32)
+	case RSA1_5, RSA_OAEP, RSA_OAEP_256, RSA_OAEP_384, RSA_OAEP_512:
+		return []testKey{{
+			dec: rsaTestKey,
+			enc: &rsaTestKey.PublicKey,
+		}}
+	case PBES2_HS256_A128KW, PBES2_HS384_A192KW, PBES2_HS512_A256KW:
+		// size does not matter, use random integer
+		i, err := rand.Int(rand.Reader, big.NewInt(64))
+		if err != nil {
+			panic(err)
+		}
+		return symmetricTestKey(int(i.Int64()))
+	}
+
+	panic("Must update test case")
+}
+
+func RunRoundtripsJWE(b *testing.B, alg KeyAlgorithm, enc ContentEncryption, zip CompressionAlgorithm, priv, pub interface{}) {
+	serializer := func(obj *JSONWebEncryption) (string, error) {
+		return obj.CompactSerialize()
+	}
+
+	corrupter := func(obj *JSONWebEncryption) bool { return false }
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := RoundtripJWE(alg, enc, zip, serializer, corrupter, nil, pub, priv)
+		if err != nil {
+			b.Error(err)
+		}
+	}
+}
+
+var (
+	chunks = map[string][]byte{
+		"1B":   make([]byte, 1),
+		"64B":  make([]byte, 64),
+		"1KB":  make([]byte, 1024),
+		"64KB": make([]byte, 65536),
+		"1MB":  make([]byte, 1048576),
+		"64MB": make([]byte, 67108864),
+	}
+
+	symKey16, _, _ = randomKeyGenerator{size: 16}.genKey()
+	symKey32, _, _ = randomKeyGenerator{size: 32}.genKey()
+	symKey64, _, _ = randomKeyGenerator{size: 64}.genKey()
+
+	encrypters = map[string]Encrypter{
+		"OAEPAndGCM":          mustEncrypter(RSA_OAEP, A128GCM, &rsaTestKey.PublicKey),
+		"PKCSAndGCM":          mustEncrypter(RSA1_5, A128GCM, &rsaTestKey.PublicKey),
+		"OAEPAndCBC":          mustEncrypter(RSA_OAEP, A128CBC_HS256, &rsaTestKey.PublicKey),
+		"PKCSAndCBC":          mustEncrypter(RSA1_5, A128CBC_HS256, &rsaTestKey.PublicKey),
+		"DirectGCM128":        mustEncrypter(DIRECT, A128GCM, symKey16),
+		"DirectCBC128":        mustEncrypter(DIRECT, A128CBC_HS256, symKey32),
+		"DirectGCM256":        mustEncrypter(DIRECT, A256GCM, symKey32),
+		"DirectCBC256":        mustEncrypter(DIRECT, A256CBC_HS512, symKey64),
+		"AESKWAndGCM128":      mustEncrypter(A128KW, A128GCM, symKey16),
+		"AESKWAndCBC256":      mustEncrypter(A256KW, A256GCM, symKey32),
+		"ECDHOnP256AndGCM128": mustEncrypter(ECDH_ES, A128GCM, &ecTestKey256.PublicKey),
+		"ECDHOnP384AndGCM128": mustEncrypter(ECDH_ES, A128GCM, &ecTestKey384.PublicKey),
+		"ECDHOnP521AndGCM128": mustEncrypter(ECDH_ES, A128GCM, &ecTestKey521.PublicKey),
+	}
+)
+
+func BenchmarkEncrypt1BWithOAEPAndGCM(b *testing.B)   { benchEncrypt("1B", "OAEPAndGCM", b) }
+func BenchmarkEncrypt64BWithOAEPAndGCM(b *testing.B)  { benchEncrypt("64B", "OAEPAndGCM", b) }
+func BenchmarkEncrypt1KBWithOAEPAndGCM(b *testing.B)  { benchEncrypt("1KB", "OAEPAndGCM", b) }
+func BenchmarkEncrypt64KBWithOAEPAndGCM(b *testing.B) { benchEncrypt("64KB", "OAEPAndGCM", b) }
+func BenchmarkEncrypt1MBWithOAEPAndGCM(b *testing.B)  { benchEncrypt("1MB", "OAEPAndGCM", b) }
+func BenchmarkEncrypt64MBWithOAEPAndGCM(b *testing.B) { benchEncrypt("64MB", "OAEPAndGCM", b) }
+
+func BenchmarkEncrypt1BWithPKCSAndGCM(b *testing.B)   { benchEncrypt("1B", "PKCSAndGCM", b) }
+func BenchmarkEncrypt64BWithPKCSAndGCM(b *testing.B)  { benchEncrypt("64B", "PKCSAndGCM", b) }
+func BenchmarkEncrypt1KBWithPKCSAndGCM(b *testing.B)  { benchEncrypt("1KB", "PKCSAndGCM", b) }
+func BenchmarkEncrypt64KBWithPKCSAndGCM(b *testing.B) { benchEncrypt("64KB", "PKCSAndGCM", b) }
+func BenchmarkEncrypt1MBWithPKCSAndGCM(b *testing.B)  { benchEncrypt("1MB", "PKCSAndGCM", b) }
+func BenchmarkEncrypt64MBWithPKCSAndGCM(b *testing.B) { benchEncrypt("64MB", "PKCSAndGCM", b) }
+
+func BenchmarkEncrypt1BWithOAEPAndCBC(b *testing.B)   { benchEncrypt("1B", "OAEPAndCBC", b) }
+func BenchmarkEncrypt64BWithOAEPAndCBC(b *testing.B)  { benchEncrypt("64B", "OAEPAndCBC", b) }
+func BenchmarkEncrypt1KBWithOAEPAndCBC(b *testing.B)  { benchEncrypt("1KB", "OAEPAndCBC", b) }
+func BenchmarkEncrypt64KBWithOAEPAndCBC(b *testing.B) { benchEncrypt("64KB", "OAEPAndCBC", b) }
+func BenchmarkEncrypt1MBWithOAEPAndCBC(b *testing.B)  { benchEncrypt("1MB", "OAEPAndCBC", b) }
+func BenchmarkEncrypt64MBWithOAEPAndCBC(b *testing.B) { benchEncrypt("64MB", "OAEPAndCBC", b) }
+
+func BenchmarkEncrypt1BWithPKCSAndCBC(b *testing.B)   { benchEncrypt("1B", "PKCSAndCBC", b) }
+func BenchmarkEncrypt64BWithPKCSAndCBC(b *testing.B)  { benchEncrypt("64B", "PKCSAndCBC", b) }
+func BenchmarkEncrypt1KBWithPKCSAndCBC(b *testing.B)  { benchEncrypt("1KB", "PKCSAndCBC", b) }
+func BenchmarkEncrypt64KBWithPKCSAndCBC(b *testing.B) { benchEncrypt("64KB", "PKCSAndCBC", b) }
+func BenchmarkEncrypt1MBWithPKCSAndCBC(b *testing.B)  { benchEncrypt("1MB", "PKCSAndCBC", b) }
+func BenchmarkEncrypt64MBWithPKCSAndCBC(b *testing.B) { benchEncrypt("64MB", "PKCSAndCBC", b) }
+
+func BenchmarkEncrypt1BWithDirectGCM128(b *testing.B)   { benchEncrypt("1B", "DirectGCM128", b) }
+func BenchmarkEncrypt64BWithDirectGCM128(b *testing.B)  { benchEncrypt("64B", "DirectGCM128", b) }
+func BenchmarkEncrypt1KBWithDirectGCM128(b *testing.B)  { benchEncrypt("1KB", "DirectGCM128", b) }
+func BenchmarkEncrypt64KBWithDirectGCM128(b *testing.B) { benchEncrypt("64KB", "DirectGCM128", b) }
+func BenchmarkEncrypt1MBWithDirectGCM128(b *testing.B)  { benchEncrypt("1MB", "DirectGCM128", b) }
+func BenchmarkEncrypt64MBWithDirectGCM128(b *testing.B) { benchEncrypt("64MB", "DirectGCM128", b) }
+
+func BenchmarkEncrypt1BWithDirectCBC128(b *testing.B)   { benchEncrypt("1B", "DirectCBC128", b) }
+func BenchmarkEncrypt64BWithDirectCBC128(b *testing.B)  { benchEncrypt("64B", "DirectCBC128", b) }
+func BenchmarkEncrypt1KBWithDirectCBC128(b *testing.B)  { benchEncrypt("1KB", "DirectCBC128", b) }
+func BenchmarkEncrypt64KBWithDirectCBC128(b *testing.B) { benchEncrypt("64KB", "DirectCBC128", b) }
+func BenchmarkEncrypt1MBWithDirectCBC128(b *testing.B)  { benchEncrypt("1MB", "DirectCBC128", b) }
+func BenchmarkEncrypt64MBWithDirectCBC128(b *testing.B) { benchEncrypt("64MB", "DirectCBC128", b) }
+
+func BenchmarkEncrypt1BWithDirectGCM256(b *testing.B)   { benchEncrypt("1B", "DirectGCM256", b) }
+func BenchmarkEncrypt64BWithDirectGCM256(b *testing.B)  { benchEncrypt("64B", "DirectGCM256", b) }
+func BenchmarkEncrypt1KBWithDirectGCM256(b *testing.B)  { benchEncrypt("1KB", "DirectGCM256", b) }
+func BenchmarkEncrypt64KBWithDirectGCM256(b *testing.B) { benchEncrypt("64KB", "DirectGCM256", b) }
+func BenchmarkEncrypt1MBWithDirectGCM256(b *testing.B)  { benchEncrypt("1MB", "DirectGCM256", b) }
+func BenchmarkEncrypt64MBWithDirectGCM256(b *testing.B) { benchEncrypt("64MB", "DirectGCM256", b) }
+
+func BenchmarkEncrypt1BWithDirectCBC256(b *testing.B)   { benchEncrypt("1B", "DirectCBC256", b) }
+func BenchmarkEncrypt64BWithDirectCBC256(b *testing.B)  { benchEncrypt("64B", "DirectCBC256", b) }
+func BenchmarkEncrypt1KBWithDirectCBC256(b *testing.B)  { benchEncrypt("1KB", "DirectCBC256", b) }
+func BenchmarkEncrypt64KBWithDirectCBC256(b *testing.B) { benchEncrypt("64KB", "DirectCBC256", b) }
+func BenchmarkEncrypt1MBWithDirectCBC256(b *testing.B)  { benchEncrypt("1MB", "DirectCBC256", b) }
+func BenchmarkEncrypt64MBWithDirectCBC256(b *testing.B) { benchEncrypt("64MB", "DirectCBC256", b) }
+
+func BenchmarkEncrypt1BWithAESKWAndGCM128(b *testing.B)   { benchEncrypt("1B", "AESKWAndGCM128", b) }
+func BenchmarkEncrypt64BWithAESKWAndGCM128(b *testing.B)  { benchEncrypt("64B", "AESKWAndGCM128", b) }
+func BenchmarkEncrypt1KBWithAESKWAndGCM128(b *testing.B)  { benchEncrypt("1KB", "AESKWAndGCM128", b) }
+func BenchmarkEncrypt64KBWithAESKWAndGCM128(b *testing.B) { benchEncrypt("64KB", "AESKWAndGCM128", b) }
+func BenchmarkEncrypt1MBWithAESKWAndGCM128(b *testing.B)  { benchEncrypt("1MB", "AESKWAndGCM128", b) }
+func BenchmarkEncrypt64MBWithAESKWAndGCM128(b *testing.B) { benchEncrypt("64MB", "AESKWAndGCM128", b) }
+
+func BenchmarkEncrypt1BWithAESKWAndCBC256(b *testing.B)   { benchEncrypt("1B", "AESKWAndCBC256", b) }
+func BenchmarkEncrypt64BWithAESKWAndCBC256(b *testing.B)  { benchEncrypt("64B", "AESKWAndCBC256", b) }
+func BenchmarkEncrypt1KBWithAESKWAndCBC256(b *testing.B)  { benchEncrypt("1KB", "AESKWAndCBC256", b) }
+func BenchmarkEncrypt64KBWithAESKWAndCBC256(b *testing.B) { benchEncrypt("64KB", "AESKWAndCBC256", b) }
+func BenchmarkEncrypt1MBWithAESKWAndCBC256(b *testing.B)  { benchEncrypt("1MB", "AESKWAndCBC256", b) }
+func BenchmarkEncrypt64MBWithAESKWAndCBC256(b *testing.B) { benchEncrypt("64MB", "AESKWAndCBC256", b) }
+
+func BenchmarkEncrypt1BWithECDHOnP256AndGCM128(b *testing.B) {
+	benchEncrypt("1B", "ECDHOnP256AndGCM128", b)
+}
+func BenchmarkEncrypt64BWithECDHOnP256AndGCM128(b *testing.B) {
+	benchEncrypt("64B", "ECDHOnP256AndGCM128", b)
+}
+func BenchmarkEncrypt1KBWithECDHOnP256AndGCM128(b *testing.B) {
+	benchEncrypt("1KB", "ECDHOnP256AndGCM128", b)
+}
+func BenchmarkEncrypt64KBWithECDHOnP256AndGCM128(b *testing.B) {
+	benchEncrypt("64KB", "ECDHOnP256AndGCM128", b)
+}
+func BenchmarkEncrypt1MBWithECDHOnP256AndGCM128(b *testing.B) {
+	benchEncrypt("1MB", "ECDHOnP256AndGCM128", b)
+}
+func BenchmarkEncrypt64MBWithECDHOnP256AndGCM128(b *testing.B) {
+	benchEncrypt("64MB", "ECDHOnP256AndGCM128", b)
+}
+
+func BenchmarkEncrypt1BWithECDHOnP384AndGCM128(b *testing.B) {
+	benchEncrypt("1B", "ECDHOnP384AndGCM128", b)
+}
+func BenchmarkEncrypt64BWithECDHOnP384AndGCM128(b *testing.B) {
+	benchEncrypt("64B", "ECDHOnP384AndGCM128", b)
+}
+func BenchmarkEncrypt1KBWithECDHOnP384AndGCM128(b *testing.B) {
+	benchEncrypt("1KB", "ECDHOnP384AndGCM128", b)
+}
+func BenchmarkEncrypt64KBWithECDHOnP384AndGCM128(b *testing.B) {
+	benchEncrypt("64KB", "ECDHOnP384AndGCM128", b)
+}
+func BenchmarkEncrypt1MBWithECDHOnP384AndGCM128(b *testing.B) {
+	benchEncrypt("1MB", "ECDHOnP384AndGCM128", b)
+}
+func BenchmarkEncrypt64MBWithECDHOnP384AndGCM128(b *testing.B) {
+	benchEncrypt("64MB", "ECDHOnP384AndGCM128", b)
+}
+
+func BenchmarkEncrypt1BWithECDHOnP521AndGCM128(b *testing.B) {
+	benchEncrypt("1B", "ECDHOnP521AndGCM128", b)
+}
+func BenchmarkEncrypt64BWithECDHOnP521AndGCM128(b *testing.B) {
+	benchEncrypt("64B", "ECDHOnP521AndGCM128", b)
+}
+func BenchmarkEncrypt1KBWithECDHOnP521AndGCM128(b *testing.B) {
+	benchEncrypt("1KB", "ECDHOnP521AndGCM128", b)
+}
+func BenchmarkEncrypt64KBWithECDHOnP521AndGCM128(b *testing.B) {
+	benchEncrypt("64KB", "ECDHOnP521AndGCM128", b)
+}
+func BenchmarkEncrypt1MBWithECDHOnP521AndGCM128(b *testing.B) {
+	benchEncrypt("1MB", "ECDHOnP521AndGCM128", b)
+}
+func BenchmarkEncrypt64MBWithECDHOnP521AndGCM128(b *testing.B) {
+	benchEncrypt("64MB", "ECDHOnP521AndGCM128", b)
+}
+
+func benchEncrypt(chunkKey, primKey string, b *testing.B) {
+	data, ok := chunks[chunkKey]
+	if !ok {
+		b.Fatalf("unknown chunk size %s", chunkKey)
+	}
+
+	enc, ok := encrypters[primKey]
+	if !ok {
+		b.Fatalf("unknown encrypter %s", primKey)
+	}
+
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		enc.Encrypt(data)
+	}
+}
+
+var (
+	decryptionKeys = map[string]interface{}{
+		"OAEPAndGCM": rsaTestKey,
+		"PKCSAndGCM": rsaTestKey,
+		"OAEPAndCBC": rsaTestKey,
+		"PKCSAndCBC": rsaTestKey,
+
+		"DirectGCM128": symKey16,
+		"DirectCBC128": symKey32,
+		"DirectGCM256": symKey32,
+		"DirectCBC256": symKey64,
+
+		"AESKWAndGCM128": symKey16,
+		"AESKWAndCBC256": symKey32,
+
+		"ECDHOnP256AndGCM128": ecTestKey256,
+		"ECDHOnP384AndGCM128": ecTestKey384,
+		"ECDHOnP521AndGCM128": ecTestKey521,
+	}
+)
+
+func BenchmarkDecrypt1BWithOAEPAndGCM(b *testing.B)   { benchDecrypt("1B", "OAEPAndGCM", b) }
+func BenchmarkDecrypt64BWithOAEPAndGCM(b *testing.B)  { benchDecrypt("64B", "OAEPAndGCM", b) }
+func BenchmarkDecrypt1KBWithOAEPAndGCM(b *testing.B)  { benchDecrypt("1KB", "OAEPAndGCM", b) }
+func BenchmarkDecrypt64KBWithOAEPAndGCM(b *testing.B) { benchDecrypt("64KB", "OAEPAndGCM", b) }
+func BenchmarkDecrypt1MBWithOAEPAndGCM(b *testing.B)  { benchDecrypt("1MB", "OAEPAndGCM", b) }
+func BenchmarkDecrypt64MBWithOAEPAndGCM(b *testing.B) { benchDecrypt("64MB", "OAEPAndGCM", b) }
+
+func BenchmarkDecrypt1BWithPKCSAndGCM(b *testing.B)   { benchDecrypt("1B", "PKCSAndGCM", b) }
+func BenchmarkDecrypt64BWithPKCSAndGCM(b *testing.B)  { benchDecrypt("64B", "PKCSAndGCM", b) }
+func BenchmarkDecrypt1KBWithPKCSAndGCM(b *testing.B)  { benchDecrypt("1KB", "PKCSAndGCM", b) }
+func BenchmarkDecrypt64KBWithPKCSAndGCM(b *testing.B) { benchDecrypt("64KB", "PKCSAndGCM", b) }
+func BenchmarkDecrypt1MBWithPKCSAndGCM(b *testing.B)  { benchDecrypt("1MB", "PKCSAndGCM", b) }
+func BenchmarkDecrypt64MBWithPKCSAndGCM(b *testing.B) { benchDecrypt("64MB", "PKCSAndGCM", b) }
+
+func BenchmarkDecrypt1BWithOAEPAndCBC(b *testing.B)   { benchDecrypt("1B", "OAEPAndCBC", b) }
+func BenchmarkDecrypt64BWithOAEPAndCBC(b *testing.B)  { benchDecrypt("64B", "OAEPAndCBC", b) }
+func BenchmarkDecrypt1KBWithOAEPAndCBC(b *testing.B)  { benchDecrypt("1KB", "OAEPAndCBC", b) }
+func BenchmarkDecrypt64KBWithOAEPAndCBC(b *testing.B) { benchDecrypt("64KB", "OAEPAndCBC", b) }
+func BenchmarkDecrypt1MBWithOAEPAndCBC(b *testing.B)  { benchDecrypt("1MB", "OAEPAndCBC", b) }
+func BenchmarkDecrypt64MBWithOAEPAndCBC(b *testing.B) { benchDecrypt("64MB", "OAEPAndCBC", b) }
+
+func BenchmarkDecrypt1BWithPKCSAndCBC(b *testing.B)   { benchDecrypt("1B", "PKCSAndCBC", b) }
+func BenchmarkDecrypt64BWithPKCSAndCBC(b *testing.B)  { benchDecrypt("64B", "PKCSAndCBC", b) }
+func BenchmarkDecrypt1KBWithPKCSAndCBC(b *testing.B)  { benchDecrypt("1KB", "PKCSAndCBC", b) }
+func BenchmarkDecrypt64KBWithPKCSAndCBC(b *testing.B) { benchDecrypt("64KB", "PKCSAndCBC", b) }
+func BenchmarkDecrypt1MBWithPKCSAndCBC(b *testing.B)  { benchDecrypt("1MB", "PKCSAndCBC", b) }
+func BenchmarkDecrypt64MBWithPKCSAndCBC(b *testing.B) { benchDecrypt("64MB", "PKCSAndCBC", b) }
+
+func BenchmarkDecrypt1BWithDirectGCM128(b *testing.B)   { benchDecrypt("1B", "DirectGCM128", b) }
+func BenchmarkDecrypt64BWithDirectGCM128(b *testing.B)  { benchDecrypt("64B", "DirectGCM128", b) }
+func BenchmarkDecrypt1KBWithDirectGCM128(b *testing.B)  { benchDecrypt("1KB", "DirectGCM128", b) }
+func BenchmarkDecrypt64KBWithDirectGCM128(b *testing.B) { benchDecrypt("64KB", "DirectGCM128", b) }
+func BenchmarkDecrypt1MBWithDirectGCM128(b *testing.B)  { benchDecrypt("1MB", "DirectGCM128", b) }
+func BenchmarkDecrypt64MBWithDirectGCM128(b *testing.B) { benchDecrypt("64MB", "DirectGCM128", b) }
+
+func BenchmarkDecrypt1BWithDirectCBC128(b *testing.B)   { benchDecrypt("1B", "DirectCBC128", b) }
+func BenchmarkDecrypt64BWithDirectCBC128(b *testing.B)  { benchDecrypt("64B", "DirectCBC128", b) }
+func BenchmarkDecrypt1KBWithDirectCBC128(b *testing.B)  { benchDecrypt("1KB", "DirectCBC128", b) }
+func BenchmarkDecrypt64KBWithDirectCBC128(b *testing.B) { benchDecrypt("64KB", "DirectCBC128", b) }
+func BenchmarkDecrypt1MBWithDirectCBC128(b *testing.B)  { benchDecrypt("1MB", "DirectCBC128", b) }
+func BenchmarkDecrypt64MBWithDirectCBC128(b *testing.B) { benchDecrypt("64MB", "DirectCBC128", b) }
+
+func BenchmarkDecrypt1BWithDirectGCM256(b *testing.B)   { benchDecrypt("1B", "DirectGCM256", b) }
+func BenchmarkDecrypt64BWithDirectGCM256(b *testing.B)  { benchDecrypt("64B", "DirectGCM256", b) }
+func BenchmarkDecrypt1KBWithDirectGCM256(b *testing.B)  { benchDecrypt("1KB", "DirectGCM256", b) }
+func BenchmarkDecrypt64KBWithDirectGCM256(b *testing.B) { benchDecrypt("64KB", "DirectGCM256", b) }
+func BenchmarkDecrypt1MBWithDirectGCM256(b *testing.B)  { benchDecrypt("1MB", "DirectGCM256", b) }
+func BenchmarkDecrypt64MBWithDirectGCM256(b *testing.B) { benchDecrypt("64MB", "DirectGCM256", b) }
+
+func BenchmarkDecrypt1BWithDirectCBC256(b *testing.B)   { benchDecrypt("1B", "DirectCBC256", b) }
+func BenchmarkDecrypt64BWithDirectCBC256(b *testing.B)  { benchDecrypt("64B", "DirectCBC256", b) }
+func BenchmarkDecrypt1KBWithDirectCBC256(b *testing.B)  { benchDecrypt("1KB", "DirectCBC256", b) }
+func BenchmarkDecrypt64KBWithDirectCBC256(b *testing.B) { benchDecrypt("64KB", "DirectCBC256", b) }
+func BenchmarkDecrypt1MBWithDirectCBC256(b *testing.B)  { benchDecrypt("1MB", "DirectCBC256", b) }
+func BenchmarkDecrypt64MBWithDirectCBC256(b *testing.B) { benchDecrypt("64MB", "DirectCBC256", b) }
+
+func BenchmarkDecrypt1BWithAESKWAndGCM128(b *testing.B)   { benchDecrypt("1B", "AESKWAndGCM128", b) }
+func BenchmarkDecrypt64BWithAESKWAndGCM128(b *testing.B)  { benchDecrypt("64B", "AESKWAndGCM128", b) }
+func BenchmarkDecrypt1KBWithAESKWAndGCM128(b *testing.B)  { benchDecrypt("1KB", "AESKWAndGCM128", b) }
+func BenchmarkDecrypt64KBWithAESKWAndGCM128(b *testing.B) { benchDecrypt("64KB", "AESKWAndGCM128", b) }
+func BenchmarkDecrypt1MBWithAESKWAndGCM128(b *testing.B)  { benchDecrypt("1MB", "AESKWAndGCM128", b) }
+func BenchmarkDecrypt64MBWithAESKWAndGCM128(b *testing.B) { benchDecrypt("64MB", "AESKWAndGCM128", b) }
+
+func BenchmarkDecrypt1BWithAESKWAndCBC256(b *testing.B)   { benchDecrypt("1B", "AESKWAndCBC256", b) }
+func BenchmarkDecrypt64BWithAESKWAndCBC256(b *testing.B)  { benchDecrypt("64B", "AESKWAndCBC256", b) }
+func BenchmarkDecrypt1KBWithAESKWAndCBC256(b *testing.B)  { benchDecrypt("1KB", "AESKWAndCBC256", b) }
+func BenchmarkDecrypt64KBWithAESKWAndCBC256(b *testing.B) { benchDecrypt("64KB", "AESKWAndCBC256", b) }
+func BenchmarkDecrypt1MBWithAESKWAndCBC256(b *testing.B)  { benchDecrypt("1MB", "AESKWAndCBC256", b) }
+func BenchmarkDecrypt64MBWithAESKWAndCBC256(b *testing.B) { benchDecrypt("64MB", "AESKWAndCBC256", b) }
+
+func BenchmarkDecrypt1BWithECDHOnP256AndGCM128(b *testing.B) {
+	benchDecrypt("1B", "ECDHOnP256AndGCM128", b)
+}
+func BenchmarkDecrypt64BWithECDHOnP256AndGCM128(b *testing.B) {
+	benchDecrypt("64B", "ECDHOnP256AndGCM128", b)
+}
+func BenchmarkDecrypt1KBWithECDHOnP256AndGCM128(b *testing.B) {
+	benchDecrypt("1KB", "ECDHOnP256AndGCM128", b)
+}
+func BenchmarkDecrypt64KBWithECDHOnP256AndGCM128(b *testing.B) {
+	benchDecrypt("64KB", "ECDHOnP256AndGCM128", b)
+}
+func BenchmarkDecrypt1MBWithECDHOnP256AndGCM128(b *testing.B) {
+	benchDecrypt("1MB", "ECDHOnP256AndGCM128", b)
+}
+func BenchmarkDecrypt64MBWithECDHOnP256AndGCM128(b *testing.B) {
+	benchDecrypt("64MB", "ECDHOnP256AndGCM128", b)
+}
+
+func BenchmarkDecrypt1BWithECDHOnP384AndGCM128(b *testing.B) {
+	benchDecrypt("1B", "ECDHOnP384AndGCM128", b)
+}
+func BenchmarkDecrypt64BWithECDHOnP384AndGCM128(b *testing.B) {
+	benchDecrypt("64B", "ECDHOnP384AndGCM128", b)
+}
+func BenchmarkDecrypt1KBWithECDHOnP384AndGCM128(b *testing.B) {
+	benchDecrypt("1KB", "ECDHOnP384AndGCM128", b)
+}
+func BenchmarkDecrypt64KBWithECDHOnP384AndGCM128(b *testing.B) {
+	benchDecrypt("64KB", "ECDHOnP384AndGCM128", b)
+}
+func BenchmarkDecrypt1MBWithECDHOnP384AndGCM128(b *testing.B) {
+	benchDecrypt("1MB", "ECDHOnP384AndGCM128", b)
+}
+func BenchmarkDecrypt64MBWithECDHOnP384AndGCM128(b *testing.B) {
+	benchDecrypt("64MB", "ECDHOnP384AndGCM128", b)
+}
+
+func BenchmarkDecrypt1BWithECDHOnP521AndGCM128(b *testing.B) {
+	benchDecrypt("1B", "ECDHOnP521AndGCM128", b)
+}
+func BenchmarkDecrypt64BWithECDHOnP521AndGCM128(b *testing.B) {
+	benchDecrypt("64B", "ECDHOnP521AndGCM128", b)
+}
+func BenchmarkDecrypt1KBWithECDHOnP521AndGCM128(b *testing.B) {
+	benchDecrypt("1KB", "ECDHOnP521AndGCM128", b)
+}
+func BenchmarkDecrypt64KBWithECDHOnP521AndGCM128(b *testing.B) {
+	benchDecrypt("64KB", "ECDHOnP521AndGCM128", b)
+}
+func BenchmarkDecrypt1MBWithECDHOnP521AndGCM128(b *testing.B) {
+	benchDecrypt("1MB", "ECDHOnP521AndGCM128", b)
+}
+func BenchmarkDecrypt64MBWithECDHOnP521AndGCM128(b *testing.B) {
+	benchDecrypt("64MB", "ECDHOnP521AndGCM128", b)
+}
+
+func benchDecrypt(chunkKey, primKey string, b *testing.B) {
+	chunk, ok := chunks[chunkKey]
+	if !ok {
+		b.Fatalf("unknown chunk size %s", chunkKey)
+	}
+
+	enc, ok := encrypters[primKey]
+	if !ok {
+		b.Fatalf("unknown encrypter %s", primKey)
+	}
+
+	dec, ok := decryptionKeys[primKey]
+	if !ok {
+		b.Fatalf("unknown decryption key %s", primKey)
+	}
+
+	data, err := enc.Encrypt(chunk)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.SetBytes(int64(len(chunk)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data.Decrypt(dec)
+	}
+}
+
+func mustEncrypter(keyAlg KeyAlgorithm, encAlg ContentEncryption, encryptionKey interface{}) Encrypter {
+	enc, err := NewEncrypter(encAlg, Recipient{Algorithm: keyAlg, Key: encryptionKey}, &EncrypterOptions{AllowLegacyRSA15: keyAlg == RSA1_5})
+	if err != nil {
+		panic(err)
+	}
+	return enc
+}
+
+func TestDecryptStreamLargePayload(t *testing.T) {
+	// 10MB of non-trivial data
+	input := make([]byte, 10*1024*1024)
+	for i := range input {
+		input[i] = byte(i * 127)
+	}
+
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := NewEncrypter(A256GCM, Recipient{Algorithm: DIRECT, Key: key}, nil)
+	if err != nil {
+		t.Fatal("error on new encrypter:", err)
+	}
+
+	obj, err := enc.Encrypt(input)
+	if err != nil {
+		t.Fatal("error on encrypt:", err)
+	}
+
+	parsed, err := ParseEncrypted(obj.FullSerialize())
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+
+	buffered, err := parsed.Decrypt(key)
+	if err != nil {
+		t.Fatal("error on decrypt:", err)
+	}
+
+	reader, err := parsed.DecryptStream(key)
+	if err != nil {
+		t.Fatal("error on streaming decrypt:", err)
+	}
+
+	streamed, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal("error reading plaintext stream:", err)
+	}
+
+	if !bytes.Equal(streamed, buffered) || !bytes.Equal(streamed, input) {
+		t.Error("streamed plaintext does not match buffered plaintext")
+	}
+
+	// Corrupted tag must fail before any output is produced
+	corrupt, err := ParseEncrypted(obj.FullSerialize())
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+	corrupt.tag[0] ^= 0xFF
+	if _, err := corrupt.DecryptStream(key); err == nil {
+		t.Error("should not return a reader for a message with an invalid tag")
+	}
+}
+
+func TestEncrypterMinimumRSAKeySize(t *testing.T) {
+	smallKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewEncrypter(A128GCM, Recipient{Algorithm: RSA_OAEP, Key: &smallKey.PublicKey}, nil)
+	if err == nil {
+		t.Error("expected 1024-bit recipient key to be rejected by default")
+	}
+
+	enc, err := NewEncrypter(A128GCM, Recipient{Algorithm: RSA_OAEP, Key: &smallKey.PublicKey},
+		&EncrypterOptions{MinimumRSAKeySize: 1024})
+	if err != nil {
+		t.Fatal("error on new encrypter with lowered minimum:", err)
+	}
+
+	input := []byte("Lorem ipsum dolor sit amet")
+	obj, err := enc.Encrypt(input)
+	if err != nil {
+		t.Fatal("error on encrypt:", err)
+	}
+	msg, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal("error on serialize:", err)
+	}
+
+	parsed, err := ParseEncrypted(msg)
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+	if _, err = parsed.Decrypt(smallKey); err == nil {
+		t.Error("expected 1024-bit decryption key to be rejected by default")
+	}
+
+	parsed, err = ParseEncryptedWithOptions(msg, &DecrypterOptions{MinimumRSAKeySize: 1024})
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+	output, err := parsed.Decrypt(smallKey)
+	if err != nil {
+		t.Fatal("error on decrypt with lowered minimum:", err)
+	}
+	if !bytes.Equal(output, input) {
+		t.Error("decrypted output does not match input")
+	}
+}
+
+func TestDeflateRoundTrip(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	enc, err := NewEncrypter(A128GCM, Recipient{Algorithm: DIRECT, Key: key},
+		&EncrypterOptions{Compression: DEFLATE})
+	if err != nil {
+		t.Fatal("error on new encrypter:", err)
+	}
+
+	input := bytes.Repeat([]byte("Lorem ipsum dolor sit amet. "), 100)
+	obj, err := enc.Encrypt(input)
+	if err != nil {
+		t.Fatal("error on encrypt:", err)
+	}
+	msg, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal("error on serialize:", err)
+	}
+
+	parsed, err := ParseEncrypted(msg)
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+	if comp := parsed.protected.getCompression(); comp != DEFLATE {
+		t.Errorf("expected zip header DEF, got '%s'", comp)
+	}
+
+	output, err := parsed.Decrypt(key)
+	if err != nil {
+		t.Fatal("error on decrypt:", err)
+	}
+	if !bytes.Equal(output, input) {
+		t.Error("decrypted output does not match input")
+	}
+}
+
+func TestDetectNonceReuse(t *testing.T) {
+	key := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	rcpt := Recipient{Algorithm: DIRECT, Key: key}
+
+	// A deterministic RandReader makes direct-mode AES-GCM draw the same IV
+	// on every Encrypt, which the guard must flag on the second call.
+	RandReader = bytes.NewReader(make([]byte, 64))
+	defer resetRandReader()
+
+	enc, err := NewEncrypter(A128GCM, rcpt, &EncrypterOptions{DetectNonceReuse: true})
+	if err != nil {
+		t.Fatal("error on new encrypter:", err)
+	}
+
+	if _, err := enc.Encrypt([]byte("Lorem ipsum dolor sit amet")); err != nil {
+		t.Fatal("error on first encrypt:", err)
+	}
+	if _, err := enc.Encrypt([]byte("Lorem ipsum dolor sit amet")); err == nil {
+		t.Error("expected nonce reuse to be detected on second encrypt")
+	}
+
+	// Without the option, reuse goes undetected (deterministic test vectors
+	// depend on this).
+	RandReader = bytes.NewReader(make([]byte, 64))
+
+	enc, err = NewEncrypter(A128GCM, rcpt, nil)
+	if err != nil {
+		t.Fatal("error on new encrypter:", err)
+	}
+	if _, err := enc.Encrypt([]byte("Lorem ipsum dolor sit amet")); err != nil {
+		t.Fatal("error on first encrypt:", err)
+	}
+	if _, err := enc.Encrypt([]byte("Lorem ipsum dolor sit amet")); err != nil {
+		t.Error("unexpected error without detection enabled:", err)
+	}
+
+	// With a real random source, the guard should not interfere.
+	resetRandReader()
+
+	enc, err = NewEncrypter(A128GCM, rcpt, &EncrypterOptions{DetectNonceReuse: true})
+	if err != nil {
+		t.Fatal("error on new encrypter:", err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := enc.Encrypt([]byte("Lorem ipsum dolor sit amet")); err != nil {
+			t.Fatal("error on encrypt with random nonces:", err)
+		}
+	}
+}
+
+func TestEncrypterWithStaticCEK(t *testing.T) {
+	sharedKey := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	cek := []byte{15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0}
+
+	enc, err := NewEncrypter(A128GCM, Recipient{Algorithm: A128KW, Key: sharedKey}, &EncrypterOptions{
+		ContentEncryptionKey: cek,
+	})
+	if err != nil {
+		t.Fatal("error on new encrypter:", err)
+	}
+
+	input := []byte("Lorem ipsum dolor sit amet")
+	obj, err := enc.Encrypt(input)
+	if err != nil {
+		t.Fatal("error on encrypt:", err)
+	}
+	msg, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal("error on serialize:", err)
+	}
+
+	parsed, err := ParseEncrypted(msg)
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+	output, err := parsed.Decrypt(sharedKey)
+	if err != nil {
+		t.Fatal("error on decrypt:", err)
+	}
+	if !bytes.Equal(output, input) {
+		t.Error("decrypted output does not match input")
+	}
+
+	// The ciphertext must decrypt directly under the supplied CEK, proving
+	// the encrypter used it rather than a generated key.
+	parts := &aeadParts{iv: parsed.iv, ciphertext: parsed.ciphertext, tag: parsed.tag}
+	direct, err := getContentCipher(A128GCM).decrypt(cek, parsed.computeAuthData(), parts)
+	if err != nil {
+		t.Fatal("ciphertext does not decrypt under the supplied CEK:", err)
+	}
+	if !bytes.Equal(direct, input) {
+		t.Error("plaintext under supplied CEK does not match input")
+	}
+
+	// A CEK of the wrong length for the content encryption must be rejected.
+	_, err = NewEncrypter(A256GCM, Recipient{Algorithm: A128KW, Key: sharedKey}, &EncrypterOptions{
+		ContentEncryptionKey: cek,
+	})
+	if err != ErrInvalidKeySize {
+		t.Error("expected ErrInvalidKeySize for short CEK, got", err)
+	}
+
+	// Direct encryption determines the CEK itself, so an explicit CEK is an error.
+	_, err = NewEncrypter(A128GCM, Recipient{Algorithm: DIRECT, Key: sharedKey}, &EncrypterOptions{
+		ContentEncryptionKey: cek,
+	})
+	if err == nil {
+		t.Error("expected error combining an explicit CEK with direct encryption")
+	}
+}
+
+func TestEncrypterWithPerInstanceRand(t *testing.T) {
+	key := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+	newEncrypterWithFill := func(fill byte) Encrypter {
+		enc, err := NewEncrypter(A128GCM, Recipient{Algorithm: DIRECT, Key: key}, &EncrypterOptions{
+			Rand: bytes.NewReader(bytes.Repeat([]byte{fill}, 4096)),
+		})
+		if err != nil {
+			t.Fatal("error on new encrypter:", err)
+		}
+		return enc
+	}
+
+	// Two encrypters with different deterministic readers, used concurrently,
+	// must each draw IVs from their own source rather than shared state.
+	fills := []byte{0x00, 0xFF}
+	objects := make([]*JSONWebEncryption, len(fills))
+
+	var wg sync.WaitGroup
+	for i, fill := range fills {
+		wg.Add(1)
+		go func(i int, enc Encrypter) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				obj, err := enc.Encrypt([]byte("Lorem ipsum dolor sit amet"))
+				if err != nil {
+					t.Error("error on encrypt:", err)
+					return
+				}
+				objects[i] = obj
+			}
+		}(i, newEncrypterWithFill(fill))
+	}
+	wg.Wait()
+
+	for i, fill := range fills {
+		if objects[i] == nil {
+			t.Fatal("missing encrypted object")
+		}
+		if !bytes.Equal(objects[i].iv, bytes.Repeat([]byte{fill}, len(objects[i].iv))) {
+			t.Errorf("encrypter %d did not draw its IV from its own rand source: %x", i, objects[i].iv)
+		}
+		if _, err := objects[i].Decrypt(key); err != nil {
+			t.Error("error on decrypt:", err)
+		}
+	}
+}
+
+func TestDecryptWithUnderstoodCritical(t *testing.T) {
+	key := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+	opts := (&EncrypterOptions{}).WithHeader(headerCritical, []string{"http://example.invalid/custom"})
+	opts.WithHeader("http://example.invalid/custom", "value")
+	enc, err := NewEncrypter(A128GCM, Recipient{Algorithm: DIRECT, Key: key}, opts)
+	if err != nil {
+		t.Fatal("error on new encrypter:", err)
+	}
+
+	obj, err := enc.Encrypt([]byte("Lorem ipsum dolor sit amet"))
+	if err != nil {
+		t.Fatal("error on encrypt:", err)
+	}
+	msg, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal("error on serialize:", err)
+	}
+
+	// An unregistered critical parameter must fail decryption.
+	parsed, err := ParseEncrypted(msg)
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+	if _, err = parsed.Decrypt(key); err == nil {
+		t.Error("should not decrypt message with unknown crit header")
+	}
+
+	// Registering the parameter as understood makes decryption succeed.
+	parsed, err = ParseEncryptedWithOptions(msg, (&DecrypterOptions{}).
+		WithUnderstoodCritical("http://example.invalid/custom"))
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+	output, err := parsed.Decrypt(key)
+	if err != nil {
+		t.Fatal("error on decrypt with understood crit header:", err)
+	}
+	if string(output) != "Lorem ipsum dolor sit amet" {
+		t.Error("decrypted output does not match input")
+	}
+}
+
+func TestNewEncrypterForJWK(t *testing.T) {
+	set := JSONWebKeySet{Keys: []JSONWebKey{
+		{Key: &rsaTestKey.PublicKey, KeyID: "rsa-key", Use: "enc"},
+		{Key: ecTestKey256.Public(), KeyID: "ec-key"},
+		{Key: &rsaTestKey.PublicKey, KeyID: "sig-key", Use: "sig"},
+	}}
+
+	cases := []struct {
+		kid string
+		key interface{}
+	}{
+		{"rsa-key", rsaTestKey},
+		{"ec-key", ecTestKey256},
+	}
+
+	for _, tc := range cases {
+		enc, err := NewEncrypterForJWK(A128GCM, set, tc.kid, nil)
+		if err != nil {
+			t.Fatalf("%s: error on new encrypter: %v", tc.kid, err)
+		}
+
+		obj, err := enc.Encrypt([]byte("Lorem ipsum dolor sit amet"))
+		if err != nil {
+			t.Fatalf("%s: error on encrypt: %v", tc.kid, err)
+		}
+		msg, err := obj.CompactSerialize()
+		if err != nil {
+			t.Fatalf("%s: error on serialize: %v", tc.kid, err)
+		}
+
+		parsed, err := ParseEncrypted(msg)
+		if err != nil {
+			t.Fatalf("%s: error on parse: %v", tc.kid, err)
+		}
+		if parsed.Header.KeyID != tc.kid {
+			t.Errorf("%s: expected kid header, got '%s'", tc.kid, parsed.Header.KeyID)
+		}
+
+		output, err := parsed.Decrypt(tc.key)
+		if err != nil {
+			t.Fatalf("%s: error on decrypt: %v", tc.kid, err)
+		}
+		if string(output) != "Lorem ipsum dolor sit amet" {
+			t.Errorf("%s: decrypted output does not match input", tc.kid)
+		}
+	}
+
+	if _, err := NewEncrypterForJWK(A128GCM, set, "missing-key", nil); err == nil {
+		t.Error("expected error for kid not in set")
+	}
+	if _, err := NewEncrypterForJWK(A128GCM, set, "sig-key", nil); err == nil {
+		t.Error("expected error for key marked use 'sig'")
+	}
+}
+
+func TestInferredKeyAlgorithm(t *testing.T) {
+	cases := []struct {
+		name string
+		jwk  *JSONWebKey
+		key  interface{}
+	}{
+		{"RSA-OAEP-256", &JSONWebKey{Key: &rsaTestKey.PublicKey, Algorithm: "RSA-OAEP-256"}, rsaTestKey},
+		{"ECDH-ES", &JSONWebKey{Key: ecTestKey256.Public(), Algorithm: "ECDH-ES"}, ecTestKey256},
+	}
+
+	for _, tc := range cases {
+		// The recipient algorithm is omitted and inferred from the JWK.
+		enc, err := NewEncrypter(A128GCM, Recipient{Key: tc.jwk}, nil)
+		if err != nil {
+			t.Fatalf("%s: error on new encrypter: %v", tc.name, err)
+		}
+
+		obj, err := enc.Encrypt([]byte("Lorem ipsum dolor sit amet"))
+		if err != nil {
+			t.Fatalf("%s: error on encrypt: %v", tc.name, err)
+		}
+		msg, err := obj.CompactSerialize()
+		if err != nil {
+			t.Fatalf("%s: error on serialize: %v", tc.name, err)
+		}
+
+		parsed, err := ParseEncrypted(msg)
+		if err != nil {
+			t.Fatalf("%s: error on parse: %v", tc.name, err)
+		}
+		if parsed.Header.Algorithm != tc.name {
+			t.Errorf("%s: expected inferred alg header, got '%s'", tc.name, parsed.Header.Algorithm)
+		}
+		if _, err := parsed.Decrypt(tc.key); err != nil {
+			t.Errorf("%s: error on decrypt: %v", tc.name, err)
+		}
+	}
+
+	// Neither the recipient nor the key carries an algorithm.
+	_, err := NewEncrypter(A128GCM, Recipient{Key: &JSONWebKey{Key: &rsaTestKey.PublicKey}}, nil)
+	if err == nil {
+		t.Error("expected error when no algorithm is set anywhere")
+	}
+
+	// The recipient algorithm conflicts with the key's.
+	_, err = NewEncrypter(A128GCM, Recipient{
+		Algorithm: RSA_OAEP,
+		Key:       &JSONWebKey{Key: &rsaTestKey.PublicKey, Algorithm: "RSA-OAEP-256"},
+	}, nil)
+	if err == nil {
+		t.Error("expected error for conflicting algorithms")
+	}
+}
+
+func TestPBES2IterationCountBounds(t *testing.T) {
+	password := []byte("Thus from my lips, by yours, my sin is purged.")
+
+	encryptWithCount := func(count int) string {
+		enc, err := NewEncrypter(A128GCM, Recipient{
+			Algorithm:  PBES2_HS256_A128KW,
+			Key:        password,
+			PBES2Count: count,
+		}, nil)
+		if err != nil {
+			t.Fatal("error on new encrypter:", err)
+		}
+		obj, err := enc.Encrypt([]byte("Lorem ipsum dolor sit amet"))
+		if err != nil {
+			t.Fatal("error on encrypt:", err)
+		}
+		msg, err := obj.CompactSerialize()
+		if err != nil {
+			t.Fatal("error on serialize:", err)
+		}
+		return msg
+	}
+
+	decryptWith := func(msg string, opts *DecrypterOptions) error {
+		parsed, err := ParseEncryptedWithOptions(msg, opts)
+		if err != nil {
+			t.Fatal("error on parse:", err)
+		}
+		_, err = parsed.Decrypt(password)
+		return err
+	}
+
+	// The default minimum of 1000 is inclusive; one below it is rejected
+	// unless the caller lowers the bound.
+	if err := decryptWith(encryptWithCount(1000), nil); err != nil {
+		t.Error("error on decrypt with count at the minimum:", err)
+	}
+	if err := decryptWith(encryptWithCount(999), nil); err == nil {
+		t.Error("should reject count below the minimum")
+	}
+	if err := decryptWith(encryptWithCount(999), &DecrypterOptions{MinPBES2Count: 999}); err != nil {
+		t.Error("error on decrypt with lowered minimum:", err)
+	}
+
+	// A caller-supplied maximum is inclusive too.
+	if err := decryptWith(encryptWithCount(1500), &DecrypterOptions{MaxPBES2Count: 1500}); err != nil {
+		t.Error("error on decrypt with count at the maximum:", err)
+	}
+	if err := decryptWith(encryptWithCount(1500), &DecrypterOptions{MaxPBES2Count: 1499}); err == nil {
+		t.Error("should reject count above the maximum")
+	}
+
+	// A token doctored to ask for an enormous count must fail fast, before
+	// any key derivation runs.
+	msg := encryptWithCount(1000)
+	parts := strings.Split(msg, ".")
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		t.Fatal("error on decode:", err)
+	}
+	doctored := strings.Replace(string(header), `"p2c":1000`, `"p2c":2000000000`, 1)
+	if doctored == string(header) {
+		t.Fatal("failed to doctor p2c header")
+	}
+	parts[0] = base64.RawURLEncoding.EncodeToString([]byte(doctored))
+
+	start := time.Now()
+	err = decryptWith(strings.Join(parts, "."), nil)
+	if err == nil {
+		t.Error("should reject doctored count far above the cap")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("rejection took %v, expected a fast error before key derivation", elapsed)
+	}
+}
+
+func TestDecompressionBomb(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	enc, err := NewEncrypter(A128GCM, Recipient{Algorithm: DIRECT, Key: key},
+		&EncrypterOptions{Compression: DEFLATE})
+	if err != nil {
+		t.Fatal("error on new encrypter:", err)
+	}
+
+	// Highly compressible input that inflates well past the default limit.
+	input := make([]byte, 1<<20)
+	obj, err := enc.Encrypt(input)
+	if err != nil {
+		t.Fatal("error on encrypt:", err)
+	}
+	msg, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal("error on serialize:", err)
+	}
+
+	parsed, err := ParseEncrypted(msg)
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+	if _, err = parsed.Decrypt(key); err == nil {
+		t.Error("expected decompressed output past the default limit to be rejected")
+	}
+
+	// Raising the limit makes the same message decrypt again.
+	parsed, err = ParseEncryptedWithOptions(msg, &DecrypterOptions{MaxDecompressedSize: 1 << 20})
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+	output, err := parsed.Decrypt(key)
+	if err != nil {
+		t.Fatal("error on decrypt with raised limit:", err)
+	}
+	if !bytes.Equal(output, input) {
+		t.Error("decrypted output does not match input")
+	}
+}
+
+func TestProtectedHeaderAfterDecrypt(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	enc, err := NewEncrypter(A128GCM,
+		Recipient{Algorithm: DIRECT, Key: key, KeyID: "audit-key-1"},
+		(&EncrypterOptions{}).WithHeader(HeaderKey("txn_id"), "abc-123"))
+	if err != nil {
+		t.Fatal("error on new encrypter:", err)
+	}
+
+	input := []byte("Lorem ipsum dolor sit amet")
+	obj, err := enc.Encrypt(input)
+	if err != nil {
+		t.Fatal("error on encrypt:", err)
+	}
+	msg, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal("error on serialize:", err)
+	}
+
+	parsed, err := ParseEncrypted(msg)
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+	if _, err = parsed.Decrypt(key); err != nil {
+		t.Fatal("error on decrypt:", err)
+	}
+
+	header, err := parsed.ProtectedHeader()
+	if err != nil {
+		t.Fatal("error on protected header:", err)
+	}
+	if header.KeyID != "audit-key-1" {
+		t.Errorf("expected kid 'audit-key-1', got '%s'", header.KeyID)
+	}
+	if alg, ok := header.Get(HeaderKey("alg")); !ok || alg != string(DIRECT) {
+		t.Errorf("expected alg 'dir', got '%v'", alg)
+	}
+	if txn, ok := header.Get(HeaderKey("txn_id")); !ok || txn != "abc-123" {
+		t.Errorf("expected txn_id 'abc-123', got '%v'", txn)
+	}
+
+	// An object without a protected header yields the zero Header.
+	bare, _ := ParseEncrypted(`{"unprotected":{"alg":"dir","enc":"A128GCM"}}`)
+	header, err = bare.ProtectedHeader()
+	if err != nil {
+		t.Fatal("error on protected header:", err)
+	}
+	if header.KeyID != "" || len(header.ExtraHeaders) != 0 {
+		t.Error("expected zero header for object without protected header")
+	}
+}
+
+func TestDecryptAlgorithmAllowlist(t *testing.T) {
+	enc, err := NewEncrypter(A128GCM, Recipient{Algorithm: RSA_OAEP_256, Key: &rsaTestKey.PublicKey}, nil)
+	if err != nil {
+		t.Fatal("error creating encrypter:", err)
+	}
+	obj, err := enc.Encrypt([]byte("Lorem ipsum dolor sit amet"))
+	if err != nil {
+		t.Fatal("error on encrypt:", err)
+	}
+	serialized, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal("error on serialize:", err)
+	}
+
+	// Both algorithms on the allowlist: decryption succeeds.
+	parsed, err := ParseEncryptedWithOptions(serialized, &DecrypterOptions{
+		AllowedKeyAlgorithms:     []KeyAlgorithm{RSA_OAEP_256},
+		AllowedContentEncryption: []ContentEncryption{A128GCM},
+	})
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+	if _, err = parsed.Decrypt(rsaTestKey); err != nil {
+		t.Error("decrypt should succeed with allowed algorithms:", err)
+	}
+
+	// Key algorithm not on the allowlist: rejected before any crypto runs.
+	parsed, err = ParseEncryptedWithOptions(serialized, &DecrypterOptions{
+		AllowedKeyAlgorithms: []KeyAlgorithm{ECDH_ES_A128KW},
+	})
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+	_, err = parsed.Decrypt(rsaTestKey)
+	if err == nil || !strings.Contains(err.Error(), "not on the allowlist") {
+		t.Error("decrypt should reject key algorithm outside the allowlist, got:", err)
+	}
+	if _, _, _, err = parsed.DecryptMulti(rsaTestKey); err == nil {
+		t.Error("multi-decrypt should reject key algorithm outside the allowlist")
+	}
+
+	// Content encryption not on the allowlist: rejected as well.
+	parsed, err = ParseEncryptedWithOptions(serialized, &DecrypterOptions{
+		AllowedContentEncryption: []ContentEncryption{A256GCM},
+	})
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+	_, err = parsed.Decrypt(rsaTestKey)
+	if err == nil || !strings.Contains(err.Error(), "not on the allowlist") {
+		t.Error("decrypt should reject content encryption outside the allowlist, got:", err)
+	}
+}
+
+func TestECDHESPartyInfo(t *testing.T) {
+	// PartyUInfo/PartyVInfo values from RFC 7518, Appendix C.
+	opts := &EncrypterOptions{
+		PartyUInfo: []byte("Alice"),
+		PartyVInfo: []byte("Bob"),
+	}
+
+	for _, alg := range []KeyAlgorithm{ECDH_ES, ECDH_ES_A128KW} {
+		enc, err := NewEncrypter(A128GCM, Recipient{Algorithm: alg, Key: &ecTestKey256.PublicKey}, opts)
+		if err != nil {
+			t.Fatalf("%s: error creating encrypter: %v", alg, err)
+		}
+		obj, err := enc.Encrypt([]byte("Lorem ipsum dolor sit amet"))
+		if err != nil {
+			t.Fatalf("%s: error on encrypt: %v", alg, err)
+		}
+
+		serialized := obj.FullSerialize()
+		parsed, err := ParseEncrypted(serialized)
+		if err != nil {
+			t.Fatalf("%s: error on parse: %v", alg, err)
+		}
+
+		// The agreement parameters must be present in the headers ...
+		headers := parsed.mergedHeaders(&parsed.recipients[0])
+		apu, err := headers.getAPU()
+		if err != nil || string(apu.bytes()) != "Alice" {
+			t.Errorf("%s: expected apu header 'Alice', got '%s' (%v)", alg, apu.bytes(), err)
+		}
+		apv, err := headers.getAPV()
+		if err != nil || string(apv.bytes()) != "Bob" {
+			t.Errorf("%s: expected apv header 'Bob', got '%s' (%v)", alg, apv.bytes(), err)
+		}
+
+		// ... and feed the KDF, so decryption works from the headers alone.
+		plaintext, err := parsed.Decrypt(ecTestKey256)
+		if err != nil {
+			t.Errorf("%s: error on decrypt: %v", alg, err)
+		} else if string(plaintext) != "Lorem ipsum dolor sit amet" {
+			t.Errorf("%s: plaintext mismatch", alg)
+		}
+
+		// Tampering with apu inside the protected header changes the KDF
+		// input (and the additional authenticated data) and must break
+		// decryption.
+		var envelope map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(serialized), &envelope); err != nil {
+			t.Fatalf("%s: error decoding envelope: %v", alg, err)
+		}
+		var protectedB64 string
+		if err := json.Unmarshal(envelope["protected"], &protectedB64); err != nil {
+			t.Fatalf("%s: error decoding protected member: %v", alg, err)
+		}
+		protectedJSON, err := base64.RawURLEncoding.DecodeString(protectedB64)
+		if err != nil {
+			t.Fatalf("%s: error decoding protected header: %v", alg, err)
+		}
+		tamperedJSON := strings.Replace(string(protectedJSON),
+			base64.RawURLEncoding.EncodeToString([]byte("Alice")),
+			base64.RawURLEncoding.EncodeToString([]byte("Malice")), 1)
+		tampered := strings.Replace(serialized, protectedB64,
+			base64.RawURLEncoding.EncodeToString([]byte(tamperedJSON)), 1)
+		parsedTampered, err := ParseEncrypted(tampered)
+		if err != nil {
+			t.Fatalf("%s: error on parse of tampered message: %v", alg, err)
+		}
+		if _, err = parsedTampered.Decrypt(ecTestKey256); err == nil {
+			t.Errorf("%s: decrypt should fail with tampered apu", alg)
+		}
+	}
+}
+
+func TestDecryptWithKeyFunc(t *testing.T) {
+	secondKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal("error generating key:", err)
+	}
+
+	enc, err := NewMultiEncrypter(A128GCM, []Recipient{
+		{Algorithm: RSA_OAEP_256, Key: &rsaTestKey.PublicKey, KeyID: "key-1"},
+		{Algorithm: RSA_OAEP_256, Key: &secondKey.PublicKey, KeyID: "key-2"},
+	}, nil)
+	if err != nil {
+		t.Fatal("error creating encrypter:", err)
+	}
+	input := []byte("Lorem ipsum dolor sit amet")
+	obj, err := enc.Encrypt(input)
+	if err != nil {
+		t.Fatal("error on encrypt:", err)
+	}
+	parsed, err := ParseEncrypted(obj.FullSerialize())
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+
+	// The resolver picks a different private key depending on the kid.
+	keystore := map[string]interface{}{
+		"key-1": rsaTestKey,
+		"key-2": secondKey,
+	}
+	calls := 0
+	resolver := func(header Header) (interface{}, error) {
+		calls++
+		if key, found := keystore[header.KeyID]; found {
+			return key, nil
+		}
+		return nil, fmt.Errorf("unknown kid '%s'", header.KeyID)
+	}
+
+	plaintext, err := parsed.DecryptWithKeyFunc(resolver)
+	if err != nil {
+		t.Fatal("error on decrypt with resolver:", err)
+	}
+	if !bytes.Equal(plaintext, input) {
+		t.Error("plaintext does not match original")
+	}
+	if calls == 0 {
+		t.Error("resolver was never invoked")
+	}
+
+	// Holding only the second key still decrypts via the second recipient.
+	secondOnly := func(header Header) (interface{}, error) {
+		if header.KeyID == "key-2" {
+			return secondKey, nil
+		}
+		return nil, fmt.Errorf("unknown kid '%s'", header.KeyID)
+	}
+	if _, err = parsed.DecryptWithKeyFunc(secondOnly); err != nil {
+		t.Error("error decrypting with second key only:", err)
+	}
+
+	// An unknown kid surfaces the resolver's error.
+	unknown := func(header Header) (interface{}, error) {
+		return nil, fmt.Errorf("unknown kid '%s'", header.KeyID)
+	}
+	_, err = parsed.DecryptWithKeyFunc(unknown)
+	if err == nil || !strings.Contains(err.Error(), "unknown kid") {
+		t.Error("expected resolver error for unknown kid, got:", err)
+	}
+
+	// Single-recipient objects work through the same API.
+	single, err := NewEncrypter(A128GCM, Recipient{Algorithm: RSA_OAEP_256, Key: &rsaTestKey.PublicKey, KeyID: "key-1"}, nil)
+	if err != nil {
+		t.Fatal("error creating encrypter:", err)
+	}
+	singleObj, err := single.Encrypt(input)
+	if err != nil {
+		t.Fatal("error on encrypt:", err)
+	}
+	singleParsed, err := ParseEncrypted(singleObj.FullSerialize())
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+	if _, err = singleParsed.DecryptWithKeyFunc(resolver); err != nil {
+		t.Error("error on single-recipient decrypt with resolver:", err)
+	}
+}
+
+func TestEncrypterCloneConcurrent(t *testing.T) {
+	key := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	enc, err := NewEncrypter(A128GCM, Recipient{Algorithm: DIRECT, Key: key}, nil)
+	if err != nil {
+		t.Fatal("error creating encrypter:", err)
+	}
+
+	// Hammer Encrypt from many goroutines, each on its own clone; run with
+	// the race detector to validate the concurrency guarantees.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clone := enc.Clone()
+			for j := 0; j < 32; j++ {
+				obj, err := clone.Encrypt([]byte("Lorem ipsum dolor sit amet"))
+				if err != nil {
+					t.Error("error on encrypt:", err)
+					return
+				}
+				output, err := obj.Decrypt(key)
+				if err != nil {
+					t.Error("error on decrypt:", err)
+					return
+				}
+				if !bytes.Equal(output, []byte("Lorem ipsum dolor sit amet")) {
+					t.Error("decrypted wrong plaintext")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestEncrypterCloneIndependent(t *testing.T) {
+	enc, err := NewEncrypter(A128GCM, Recipient{Algorithm: RSA_OAEP, Key: &rsaTestKey.PublicKey},
+		&EncrypterOptions{ExtraHeaders: map[HeaderKey]interface{}{HeaderContentType: "text/plain"}})
+	if err != nil {
+		t.Fatal("error creating encrypter:", err)
+	}
+
+	clone := enc.Clone()
+	if !reflect.DeepEqual(enc.Options(), clone.Options()) {
+		t.Error("clone should carry the same options")
+	}
+
+	// Mutating the clone's extra headers must not leak into the original.
+	clone.Options().ExtraHeaders[HeaderContentType] = "application/json"
+	if enc.Options().ExtraHeaders[HeaderContentType] != "text/plain" {
+		t.Error("mutating the clone's headers affected the original")
+	}
+}
+
+func TestDecryptRejectsUnknownCompression(t *testing.T) {
+	key := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+	protected := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"dir","enc":"A128GCM","zip":"GZIP"}`))
+	serialized := fmt.Sprintf(`{"protected":"%s","iv":"AAAAAAAAAAAAAAAA","ciphertext":"AAAA","tag":"AAAAAAAAAAAAAAAAAAAAAA"}`, protected)
+
+	obj, err := ParseEncrypted(serialized)
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+
+	_, err = obj.Decrypt(key)
+	if err == nil {
+		t.Fatal("decrypt should reject unknown zip value")
+	}
+	if !strings.Contains(err.Error(), "unsupported compression algorithm 'GZIP'") {
+		t.Errorf("error should name the offending zip value, got: %v", err)
+	}
+}
+
+func TestSentinelErrorsMatch(t *testing.T) {
+	key := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+	// Direct encryption with a key of the wrong size.
+	_, err := NewEncrypter(A256GCM, Recipient{Algorithm: DIRECT, Key: key}, nil)
+	if !errors.Is(err, ErrInvalidKeySize) {
+		t.Errorf("expected errors.Is(err, ErrInvalidKeySize), got: %v", err)
+	}
+
+	// Unknown enc value on decrypt.
+	protected := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"dir","enc":"A999GCM"}`))
+	serialized := fmt.Sprintf(`{"protected":"%s","iv":"AAAAAAAAAAAAAAAA","ciphertext":"AAAA","tag":"AAAAAAAAAAAAAAAAAAAAAA"}`, protected)
+	obj, err := ParseEncrypted(serialized)
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+	_, err = obj.Decrypt(key)
+	if !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("expected errors.Is(err, ErrUnsupportedAlgorithm), got: %v", err)
+	}
+
+	// Unknown zip value on decrypt.
+	protected = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"dir","enc":"A128GCM","zip":"GZIP"}`))
+	serialized = fmt.Sprintf(`{"protected":"%s","iv":"AAAAAAAAAAAAAAAA","ciphertext":"AAAA","tag":"AAAAAAAAAAAAAAAAAAAAAA"}`, protected)
+	obj, err = ParseEncrypted(serialized)
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+	_, err = obj.Decrypt(key)
+	if !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("expected errors.Is(err, ErrUnsupportedAlgorithm), got: %v", err)
+	}
+}
+
+func TestDecryptAny(t *testing.T) {
+	keys := make([]*rsa.PrivateKey, 3)
+	for i := range keys {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal("error generating key:", err)
+		}
+		keys[i] = key
+	}
+
+	enc, err := NewEncrypter(A128GCM, Recipient{Algorithm: RSA_OAEP, Key: &keys[1].PublicKey}, nil)
+	if err != nil {
+		t.Fatal("error creating encrypter:", err)
+	}
+	obj, err := enc.Encrypt([]byte("Lorem ipsum dolor sit amet"))
+	if err != nil {
+		t.Fatal("error on encrypt:", err)
+	}
+	parsed, err := ParseEncrypted(obj.FullSerialize())
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+
+	index, plaintext, err := parsed.DecryptAny([]interface{}{keys[0], keys[1], keys[2]})
+	if err != nil {
+		t.Fatal("error on decrypt:", err)
+	}
+	if index != 1 {
+		t.Errorf("expected key index 1 to match, got %d", index)
+	}
+	if !bytes.Equal(plaintext, []byte("Lorem ipsum dolor sit amet")) {
+		t.Error("decrypted wrong plaintext")
+	}
+
+	if _, _, err := parsed.DecryptAny([]interface{}{keys[0], keys[2]}); err == nil {
+		t.Error("decryption should fail when no key matches")
+	}
+	if _, _, err := parsed.DecryptAny(nil); err == nil {
+		t.Error("decryption should fail with no keys")
+	}
+}
+
+func TestDecryptExpectedType(t *testing.T) {
+	key := []byte("0123456789abcdef")
+
+	typedEnc, err := NewEncrypter(A128GCM, Recipient{Algorithm: DIRECT, Key: key},
+		(&EncrypterOptions{}).WithType("JWT"))
+	if err != nil {
+		t.Fatal("error on new encrypter:", err)
+	}
+	obj, err := typedEnc.Encrypt([]byte("Lorem ipsum dolor sit amet"))
+	if err != nil {
+		t.Fatal("error on encrypt:", err)
+	}
+	typed, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal("error on serialize:", err)
+	}
+
+	untypedEnc, err := NewEncrypter(A128GCM, Recipient{Algorithm: DIRECT, Key: key}, nil)
+	if err != nil {
+		t.Fatal("error on new encrypter:", err)
+	}
+	obj, err = untypedEnc.Encrypt([]byte("Lorem ipsum dolor sit amet"))
+	if err != nil {
+		t.Fatal("error on encrypt:", err)
+	}
+	untyped, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal("error on serialize:", err)
+	}
+
+	cases := []struct {
+		token        string
+		expectedType string
+		valid        bool
+	}{
+		{typed, "JWT", true},
+		{typed, "", true},
+		{typed, "JOSE", false},
+		{untyped, "JWT", false},
+		{untyped, "", true},
+	}
+
+	for _, tc := range cases {
+		parsed, err := ParseEncryptedWithOptions(tc.token, &DecrypterOptions{ExpectedType: tc.expectedType})
+		if err != nil {
+			t.Fatal("error on parse:", err)
+		}
+
+		_, err = parsed.Decrypt(key)
+		if tc.valid && err != nil {
+			t.Errorf("expected type '%s': unexpected decrypt error: %v", tc.expectedType, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("expected type '%s': decryption should have failed", tc.expectedType)
+		}
+	}
+}
+
+func TestDecryptWithAuthData(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	enc, err := NewEncrypter(A128GCM, Recipient{Algorithm: DIRECT, Key: key, KeyID: "aad-key"}, nil)
+	if err != nil {
+		t.Fatal("error on new encrypter:", err)
+	}
+
+	aad := []byte(`{"txn":"12345"}`)
+	obj, err := enc.EncryptWithAuthData([]byte("Lorem ipsum dolor sit amet"), aad)
+	if err != nil {
+		t.Fatal("error on encrypt:", err)
+	}
+
+	parsed, err := ParseEncrypted(obj.FullSerialize())
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+
+	plaintext, gotAad, header, err := parsed.DecryptWithAuthData(key)
+	if err != nil {
+		t.Fatal("error on decrypt:", err)
+	}
+	if string(plaintext) != "Lorem ipsum dolor sit amet" {
+		t.Errorf("unexpected plaintext '%s'", plaintext)
+	}
+	if !bytes.Equal(gotAad, aad) {
+		t.Errorf("expected aad '%s', got '%s'", aad, gotAad)
+	}
+	if header.KeyID != "aad-key" {
+		t.Errorf("expected key ID 'aad-key' in header, got '%s'", header.KeyID)
+	}
+
+	// Flipping a bit in the aad must invalidate the whole result.
+	parsed.aad[0] ^= 1
+	if _, _, _, err := parsed.DecryptWithAuthData(key); err == nil {
+		t.Error("expected decryption to fail with tampered aad")
+	}
+	parsed.aad[0] ^= 1
+
+	// As must flipping a bit in the ciphertext.
+	parsed.ciphertext[0] ^= 1
+	if _, _, _, err := parsed.DecryptWithAuthData(key); err == nil {
+		t.Error("expected decryption to fail with tampered ciphertext")
+	}
+}
+
+func TestRoundtripECDH1PU(t *testing.T) {
+	senderKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, alg := range []KeyAlgorithm{ECDH_1PU, ECDH_1PU_A128KW, ECDH_1PU_A192KW, ECDH_1PU_A256KW} {
+		// The key-wrapping modes are restricted to AES_CBC_HMAC_SHA2 content
+		// encryption; only direct key agreement may use an AEAD mode.
+		contentEnc := A128CBC_HS256
+		if alg == ECDH_1PU {
+			contentEnc = A128GCM
+		}
+		enc, err := NewEncrypter(contentEnc, Recipient{
+			Algorithm: alg,
+			Key: ECDH1PUEncryptionKey{
+				SenderKey:    senderKey,
+				RecipientKey: &recipientKey.PublicKey,
+			},
+		}, nil)
+		if err != nil {
+			t.Fatalf("error on new encrypter (%s): %v", alg, err)
+		}
+
+		obj, err := enc.Encrypt([]byte("Lorem ipsum dolor sit amet"))
+		if err != nil {
+			t.Fatalf("error on encrypt (%s): %v", alg, err)
+		}
+
+		msg, err := obj.CompactSerialize()
+		if err != nil {
+			t.Fatalf("error on serialize (%s): %v", alg, err)
+		}
+		parsed, err := ParseEncrypted(msg)
+		if err != nil {
+			t.Fatalf("error on parse (%s): %v", alg, err)
+		}
+
+		plaintext, err := parsed.Decrypt(ECDH1PUDecryptionKey{
+			RecipientKey: recipientKey,
+			SenderKey:    &senderKey.PublicKey,
+		})
+		if err != nil {
+			t.Fatalf("error on decrypt (%s): %v", alg, err)
+		}
+		if string(plaintext) != "Lorem ipsum dolor sit amet" {
+			t.Errorf("unexpected plaintext '%s' (%s)", plaintext, alg)
+		}
+
+		// The derived key mixes in the sender's static key, so decrypting
+		// against a different alleged sender must fail.
+		otherSender, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = parsed.Decrypt(ECDH1PUDecryptionKey{
+			RecipientKey: recipientKey,
+			SenderKey:    &otherSender.PublicKey,
+		})
+		if err == nil {
+			t.Errorf("expected decryption to fail with the wrong sender key (%s)", alg)
+		}
+	}
+}
+
+func TestRoundtripECDH1PUX25519(t *testing.T) {
+	senderKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipientKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := NewEncrypter(A256CBC_HS512, Recipient{
+		Algorithm: ECDH_1PU_A256KW,
+		Key: ECDH1PUEncryptionKey{
+			SenderKey:    senderKey,
+			RecipientKey: recipientKey.PublicKey(),
+		},
+	}, &EncrypterOptions{
+		PartyUInfo: []byte("Alice"),
+		PartyVInfo: []byte("Bob"),
+	})
+	if err != nil {
+		t.Fatal("error on new encrypter:", err)
+	}
+
+	obj, err := enc.Encrypt([]byte("Lorem ipsum dolor sit amet"))
+	if err != nil {
+		t.Fatal("error on encrypt:", err)
+	}
+
+	parsed, err := ParseEncrypted(obj.FullSerialize())
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+
+	plaintext, err := parsed.Decrypt(ECDH1PUDecryptionKey{
+		RecipientKey: recipientKey,
+		SenderKey:    senderKey.PublicKey(),
+	})
+	if err != nil {
+		t.Fatal("error on decrypt:", err)
+	}
+	if string(plaintext) != "Lorem ipsum dolor sit amet" {
+		t.Errorf("unexpected plaintext '%s'", plaintext)
+	}
+}
+
+func TestECDH1PURejectsBadKeys(t *testing.T) {
+	senderKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipientKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sender and recipient keys on different curves cannot agree.
+	_, err = NewEncrypter(A128GCM, Recipient{
+		Algorithm: ECDH_1PU,
+		Key: ECDH1PUEncryptionKey{
+			SenderKey:    senderKey,
+			RecipientKey: &recipientKey.PublicKey,
+		},
+	}, nil)
+	if err == nil {
+		t.Error("expected encrypter creation to fail with keys on different curves")
+	}
+
+	// A missing sender key is rejected rather than silently downgraded.
+	_, err = NewEncrypter(A128GCM, Recipient{
+		Algorithm: ECDH_1PU,
+		Key: ECDH1PUEncryptionKey{
+			RecipientKey: &recipientKey.PublicKey,
+		},
+	}, nil)
+	if err == nil {
+		t.Error("expected encrypter creation to fail without a sender key")
+	}
+
+	// A bare public key is not enough for an authenticated algorithm.
+	_, err = NewEncrypter(A128GCM, Recipient{Algorithm: ECDH_1PU, Key: &recipientKey.PublicKey}, nil)
+	if err == nil {
+		t.Error("expected encrypter creation to fail without a sender key pair")
+	}
+}
+
+// Test the draft-madden-jose-ecdh-1pu-04 §2.1 restriction: the key-wrapping
+// modes must only be used with AES_CBC_HMAC_SHA2 content encryption. With an
+// AEAD like GCM an inside recipient can forge messages that appear to come
+// from the sender.
+func TestECDH1PUKeyWrapRequiresCBCHMAC(t *testing.T) {
+	senderKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair := ECDH1PUEncryptionKey{
+		SenderKey:    senderKey,
+		RecipientKey: &recipientKey.PublicKey,
+	}
+
+	for _, alg := range []KeyAlgorithm{ECDH_1PU_A128KW, ECDH_1PU_A192KW, ECDH_1PU_A256KW} {
+		for _, enc := range []ContentEncryption{A128GCM, A192GCM, A256GCM, XC20P} {
+			if _, err := NewEncrypter(enc, Recipient{Algorithm: alg, Key: pair}, nil); err == nil {
+				t.Errorf("expected encrypter creation to fail for %s with %s", alg, enc)
+			}
+		}
+		if _, err := NewEncrypter(A128CBC_HS256, Recipient{Algorithm: alg, Key: pair}, nil); err != nil {
+			t.Errorf("error on new encrypter for %s with A128CBC-HS256: %v", alg, err)
+		}
+	}
+
+	// The restriction holds on decrypt too: a message whose headers claim a
+	// GCM mode for a key-wrapped 1PU recipient is rejected before unwrap.
+	enc, err := NewEncrypter(A128CBC_HS256, Recipient{Algorithm: ECDH_1PU_A128KW, Key: pair}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := enc.Encrypt([]byte("Lorem ipsum dolor sit amet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg map[string]interface{}
+	if err := json.Unmarshal([]byte(obj.FullSerialize()), &msg); err != nil {
+		t.Fatal(err)
+	}
+	protectedJSON, err := base64.RawURLEncoding.DecodeString(msg["protected"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hdr map[string]interface{}
+	if err := json.Unmarshal(protectedJSON, &hdr); err != nil {
+		t.Fatal(err)
+	}
+	hdr["enc"] = "A128GCM"
+	serializedHdr, err := json.Marshal(hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg["protected"] = base64.RawURLEncoding.EncodeToString(serializedHdr)
+	serializedMsg, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseEncrypted(string(serializedMsg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parsed.Decrypt(ECDH1PUDecryptionKey{
+		RecipientKey: recipientKey,
+		SenderKey:    &senderKey.PublicKey,
+	}); err != ErrCryptoFailure {
+		t.Errorf("GCM-labeled 1PU key-wrap message should be rejected, got %v", err)
+	}
+}
+
+// Test that a low-order X25519 "epk" point is rejected on the ECDH-1PU
+// decrypt path with a normal error rather than a panic, like the ECDH-ES
+// path.
+func TestECDH1PUX25519RejectsLowOrderEPK(t *testing.T) {
+	senderKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipientKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := NewEncrypter(A256GCM, Recipient{
+		Algorithm: ECDH_1PU,
+		Key: ECDH1PUEncryptionKey{
+			SenderKey:    senderKey,
+			RecipientKey: recipientKey.PublicKey(),
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := enc.Encrypt([]byte("Lorem ipsum dolor sit amet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg map[string]interface{}
+	if err := json.Unmarshal([]byte(obj.FullSerialize()), &msg); err != nil {
+		t.Fatal(err)
+	}
+	protectedJSON, err := base64.RawURLEncoding.DecodeString(msg["protected"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hdr map[string]interface{}
+	if err := json.Unmarshal(protectedJSON, &hdr); err != nil {
+		t.Fatal(err)
+	}
+	hdr["epk"].(map[string]interface{})["x"] = base64.RawURLEncoding.EncodeToString(make([]byte, 32))
+	serializedHdr, err := json.Marshal(hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg["protected"] = base64.RawURLEncoding.EncodeToString(serializedHdr)
+	serializedMsg, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseEncrypted(string(serializedMsg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parsed.Decrypt(ECDH1PUDecryptionKey{
+		RecipientKey: recipientKey,
+		SenderKey:    senderKey.PublicKey(),
+	}); err != ErrCryptoFailure {
+		t.Errorf("low-order epk should fail with a crypto error, got %v", err)
+	}
+}
+
+func TestDecryptWithKeyUnwrapper(t *testing.T) {
+	input := []byte("Lorem ipsum dolor sit amet")
+
+	enc, err := NewEncrypter(A128GCM, Recipient{Algorithm: RSA_OAEP, Key: &rsaTestKey.PublicKey}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := enc.Encrypt(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseEncrypted(obj.FullSerialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mock external unwrap service holding the RSA private key
+	var calls int
+	var seenAlg KeyAlgorithm
+	unwrap := func(alg KeyAlgorithm, encryptedKey []byte) ([]byte, error) {
+		calls++
+		seenAlg = alg
+		return rsa.DecryptOAEP(sha1.New(), rand.Reader, rsaTestKey, encryptedKey, []byte{})
+	}
+
+	output, err := parsed.DecryptWithKeyUnwrapper(unwrap)
+	if err != nil {
+		t.Fatal("error on decrypt with key unwrapper:", err)
+	}
+	if !bytes.Equal(input, output) {
+		t.Error("Decrypted output does not match input:", output, input)
+	}
+	if calls != 1 || seenAlg != RSA_OAEP {
+		t.Errorf("unexpected callback usage: %d calls, alg '%s'", calls, seenAlg)
+	}
+
+	// A nil callback is rejected
+	if _, err := parsed.DecryptWithKeyUnwrapper(nil); err == nil {
+		t.Error("should reject nil unwrap callback")
+	}
+
+	// Non-RSA messages are not dispatched to the callback
+	sharedKey := []byte{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+	}
+	direct, err := NewEncrypter(A128GCM, Recipient{Algorithm: DIRECT, Key: sharedKey}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirObj, err := direct.Encrypt(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls = 0
+	if _, err := dirObj.DecryptWithKeyUnwrapper(unwrap); err == nil {
+		t.Error("should not decrypt non-RSA message through unwrap callback")
+	}
+	if calls != 0 {
+		t.Error("callback should not be invoked for non-RSA algorithms")
+	}
+}
+
+// Test that crafted "epk" headers are rejected on decrypt before any shared
+// secret is computed: off-curve points, identity coordinates, and valid
+// points on a different curve must all fail.
+func TestECDHESRejectsInvalidEPK(t *testing.T) {
+	recipientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := NewEncrypter(A128GCM, Recipient{Algorithm: ECDH_ES, Key: &recipientKey.PublicKey}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := enc.Encrypt([]byte("Lorem ipsum dolor sit amet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg map[string]interface{}
+	if err := json.Unmarshal([]byte(obj.FullSerialize()), &msg); err != nil {
+		t.Fatal(err)
+	}
+
+	decryptWithEPK := func(t *testing.T, mutate func(epk map[string]interface{}) interface{}) error {
+		protectedJSON, err := base64.RawURLEncoding.DecodeString(msg["protected"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var hdr map[string]interface{}
+		if err := json.Unmarshal(protectedJSON, &hdr); err != nil {
+			t.Fatal(err)
+		}
+		hdr["epk"] = mutate(hdr["epk"].(map[string]interface{}))
+
+		tampered := make(map[string]interface{}, len(msg))
+		for k, v := range msg {
+			tampered[k] = v
+		}
+		serializedHdr, err := json.Marshal(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tampered["protected"] = base64.RawURLEncoding.EncodeToString(serializedHdr)
+		serializedMsg, err := json.Marshal(tampered)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parsed, err := ParseEncrypted(string(serializedMsg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = parsed.Decrypt(recipientKey)
+		return err
+	}
+
+	// The rejections happen inside the per-recipient decrypt loop, which
+	// deliberately collapses key-decryption failures into the generic
+	// crypto failure so that invalid input is indistinguishable from any
+	// other integrity failure.
+
+	// Point with valid-length coordinates that is not on P-256
+	if err := decryptWithEPK(t, func(epk map[string]interface{}) interface{} {
+		epk["y"] = epk["x"]
+		return epk
+	}); err != ErrCryptoFailure {
+		t.Errorf("off-curve epk should be rejected, got %v", err)
+	}
+
+	// Identity-style all-zero coordinates
+	zero := base64.RawURLEncoding.EncodeToString(make([]byte, 32))
+	if err := decryptWithEPK(t, func(epk map[string]interface{}) interface{} {
+		epk["x"] = zero
+		epk["y"] = zero
+		return epk
+	}); err != ErrCryptoFailure {
+		t.Errorf("zero-coordinate epk should be rejected, got %v", err)
+	}
+
+	// A valid point on a different curve than the recipient's key
+	otherKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherJWK, err := (&JSONWebKey{Key: &otherKey.PublicKey}).MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := decryptWithEPK(t, func(epk map[string]interface{}) interface{} {
+		var crossCurve map[string]interface{}
+		if err := json.Unmarshal(otherJWK, &crossCurve); err != nil {
+			t.Fatal(err)
+		}
+		return crossCurve
+	}); err != ErrCryptoFailure {
+		t.Errorf("cross-curve epk should be rejected, got %v", err)
+	}
+}
+
+// Test that a low-order X25519 "epk" point is rejected on decrypt with a
+// normal error rather than a panic: NewPublicKey accepts any 32 bytes, and
+// the shared-secret computation is what fails for such points.
+func TestECDHESX25519RejectsLowOrderEPK(t *testing.T) {
+	recipientKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := NewEncrypter(A128GCM, Recipient{Algorithm: ECDH_ES, Key: recipientKey.PublicKey()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := enc.Encrypt([]byte("Lorem ipsum dolor sit amet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg map[string]interface{}
+	if err := json.Unmarshal([]byte(obj.FullSerialize()), &msg); err != nil {
+		t.Fatal(err)
+	}
+
+	protectedJSON, err := base64.RawURLEncoding.DecodeString(msg["protected"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hdr map[string]interface{}
+	if err := json.Unmarshal(protectedJSON, &hdr); err != nil {
+		t.Fatal(err)
+	}
+
+	// The all-zero point is low order; so is the result of clearing the
+	// high bits of a random value multiplied into the small cofactor
+	// subgroup, but all-zero is the canonical DoS payload.
+	hdr["epk"].(map[string]interface{})["x"] = base64.RawURLEncoding.EncodeToString(make([]byte, 32))
+	serializedHdr, err := json.Marshal(hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg["protected"] = base64.RawURLEncoding.EncodeToString(serializedHdr)
+	serializedMsg, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseEncrypted(string(serializedMsg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parsed.Decrypt(recipientKey); err != ErrCryptoFailure {
+		t.Errorf("low-order epk should fail with a crypto error, got %v", err)
+	}
+}
+
+func TestJWENotCompactSerializable(t *testing.T) {
+	sharedKey := []byte{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+	}
+
+	// Multiple recipients cannot be represented in compact form
+	multi, err := NewMultiEncrypter(A128GCM, []Recipient{
+		{Algorithm: RSA_OAEP, Key: &rsaTestKey.PublicKey},
+		{Algorithm: A256GCMKW, Key: sharedKey},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := multi.Encrypt([]byte("Lorem ipsum dolor sit amet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := obj.CompactSerialize(); err != ErrNotCompactSerializable {
+		t.Errorf("expected ErrNotCompactSerializable for multi-recipient object, got '%v'", err)
+	}
+
+	// Explicit AAD is only representable in the JSON serializations
+	single, err := NewEncrypter(A128GCM, Recipient{Algorithm: A256GCMKW, Key: sharedKey}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err = single.EncryptWithAuthData([]byte("Lorem ipsum dolor sit amet"), []byte("aad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := obj.CompactSerialize(); err != ErrNotCompactSerializable {
+		t.Errorf("expected ErrNotCompactSerializable for object with aad, got '%v'", err)
+	}
+
+	// The historical name must remain interchangeable with the new one
+	if ErrNotSupported != ErrNotCompactSerializable {
+		t.Error("ErrNotSupported must alias ErrNotCompactSerializable")
+	}
+}
+
+func TestDecryptForDebug(t *testing.T) {
+	// The worked sample from RFC 7516, Appendix A.3: the CEK recovered by
+	// unwrapping the encrypted key must match the one listed in the RFC.
+	key, err := base64.RawURLEncoding.DecodeString("GawgguFyGrWKav7AX4VKUg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := stripWhitespace(`
+		eyJhbGciOiJBMTI4S1ciLCJlbmMiOiJBMTI4Q0JDLUhTMjU2In0.6KB707dM
+		9YTIgHtLvtgWQ8mKwboJW3of9locizkDTHzBC2IlrT1oOQ.AxY8DCtDaGlsb
+		Gljb3RoZQ.KDlTtXchhZTGufMYmOYGS4HffxPSUrfmqCHXaI9wOGY.U0m_Ym
+		jN04DJvceFICbCVQ`)
+
+	cekHex := "04d31fc5549dfcfe0b649dfa3faa6ace6b7cd42d6f6b09dbc8b100f08f9c2ccf"
+
+	obj, err := ParseEncrypted(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, cek, err := obj.DecryptForDebug(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "Live long and prosper." {
+		t.Errorf("unexpected plaintext '%s'", plaintext)
+	}
+	if hex.EncodeToString(cek) != cekHex {
+		t.Errorf("expected CEK %s, got %s", cekHex, hex.EncodeToString(cek))
+	}
+
+	// When content decryption fails but key unwrap succeeded, the CEK is
+	// still returned alongside the error so it can be compared against the
+	// worked sample.
+	corrupt, err := ParseEncrypted(token[:len(token)-4] + "AAAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, cek, err = corrupt.DecryptForDebug(key)
+	if err == nil {
+		t.Fatal("expected decryption failure for corrupted tag")
+	}
+	if hex.EncodeToString(cek) != cekHex {
+		t.Errorf("expected CEK %s despite content failure, got %s", cekHex, hex.EncodeToString(cek))
+	}
+}
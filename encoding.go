@@ -0,0 +1,257 @@
+/*-
+ * Copyright 2014 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jose
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"unicode"
+
+	"gopkg.in/square/go-jose.v2/json"
+)
+
+// Helper function to serialize known-good objects.
+// Precondition: value is not a nil pointer.
+func mustSerializeJSON(value interface{}) []byte {
+	out, err := json.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+	// We never want to serialize the top-level value "null," since it's not a
+	// valid JOSE message. But if a caller passes in a nil pointer to this method,
+	// MarshalJSON will happily serialize it as the top-level value "null". If
+	// that value is then embedded in another operation, for instance by being
+	// base64-encoded and fed as input to a signing algorithm
+	// (https://github.com/square/go-jose/issues/22), the result will be
+	// incorrect. Because this method is intended for known-good objects, and a nil
+	// pointer is not a known-good object, we are free to panic in this case.
+	// Note: It's not possible to directly check whether the data pointed at by an
+	// interface is a nil pointer, so we do this hacky workaround.
+	// https://groups.google.com/forum/#!topic/golang-nuts/wnH302gBa4I
+	if string(out) == "null" {
+		panic("Tried to serialize a nil pointer.")
+	}
+	return out
+}
+
+// Strip all newlines and whitespace
+func stripWhitespace(data string) string {
+	buf := strings.Builder{}
+	buf.Grow(len(data))
+	for _, r := range data {
+		if !unicode.IsSpace(r) {
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
+
+// validateCompression rejects unknown "zip" header values up front so the
+// caller sees which value was at fault rather than a generic failure later.
+func validateCompression(algorithm CompressionAlgorithm) error {
+	switch algorithm {
+	case NONE, DEFLATE:
+		return nil
+	default:
+		return fmt.Errorf("square/go-jose: unsupported compression algorithm '%s': %w", algorithm, ErrUnsupportedAlgorithm)
+	}
+}
+
+// Perform compression based on algorithm
+func compress(algorithm CompressionAlgorithm, input []byte) ([]byte, error) {
+	switch algorithm {
+	case DEFLATE:
+		return deflate(input)
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+}
+
+// defaultMaxDecompressedSize is the largest decompressed plaintext, in
+// bytes, accepted when no explicit limit has been configured. It guards
+// against decompression bombs in messages from untrusted senders.
+const defaultMaxDecompressedSize = 250 * 1024
+
+// Perform decompression based on algorithm, rejecting output larger than
+// maxSize bytes. A maxSize of zero applies the default limit.
+func decompress(algorithm CompressionAlgorithm, input []byte, maxSize int64) ([]byte, error) {
+	switch algorithm {
+	case DEFLATE:
+		return inflate(input, maxSize)
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+}
+
+// Compress with DEFLATE
+func deflate(input []byte) ([]byte, error) {
+	output := new(bytes.Buffer)
+
+	// Writing to byte buffer, err is always nil
+	writer, _ := flate.NewWriter(output, 1)
+	_, _ = io.Copy(writer, bytes.NewBuffer(input))
+
+	err := writer.Close()
+	return output.Bytes(), err
+}
+
+// Decompress with DEFLATE
+func inflate(input []byte, maxSize int64) ([]byte, error) {
+	if maxSize == 0 {
+		maxSize = defaultMaxDecompressedSize
+	}
+
+	output := new(bytes.Buffer)
+	reader := flate.NewReader(bytes.NewBuffer(input))
+
+	// Copy up to one byte past the limit to tell an output that is exactly
+	// at the limit apart from one that exceeds it.
+	n, err := io.CopyN(output, reader, maxSize+1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if n > maxSize {
+		return nil, fmt.Errorf("square/go-jose: decompressed data larger than maximum of %d bytes", maxSize)
+	}
+
+	err = reader.Close()
+	return output.Bytes(), err
+}
+
+// countingWriter tracks how many bytes have been written to the wrapped
+// writer.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += n
+	return n, err
+}
+
+// writeCompactSegments base64url-encodes each segment to the writer,
+// separated by periods, streaming the encoding rather than building the
+// serialization in memory first.
+func writeCompactSegments(w io.Writer, segments ...[]byte) error {
+	for i, segment := range segments {
+		if i > 0 {
+			if _, err := w.Write([]byte{'.'}); err != nil {
+				return err
+			}
+		}
+		encoder := base64.NewEncoder(base64.RawURLEncoding, w)
+		if _, err := encoder.Write(segment); err != nil {
+			return err
+		}
+		if err := encoder.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// base64URLDecode decodes a segment of a compact serialization. Segments must
+// be unpadded base64url; padding and standard-alphabet characters are rejected
+// with a descriptive error rather than the offset-only error from the raw
+// decoder.
+func base64URLDecode(value string) ([]byte, error) {
+	if i := strings.IndexAny(value, "+/="); i != -1 {
+		return nil, fmt.Errorf("square/go-jose: invalid character '%c' in segment; compact segments must be unpadded base64url", value[i])
+	}
+	return base64.RawURLEncoding.DecodeString(value)
+}
+
+// byteBuffer represents a slice of bytes that can be serialized to url-safe base64.
+type byteBuffer struct {
+	data []byte
+}
+
+func newBuffer(data []byte) *byteBuffer {
+	if data == nil {
+		return nil
+	}
+	return &byteBuffer{
+		data: data,
+	}
+}
+
+func newFixedSizeBuffer(data []byte, length int) *byteBuffer {
+	if len(data) > length {
+		panic("square/go-jose: invalid call to newFixedSizeBuffer (len(data) > length)")
+	}
+	pad := make([]byte, length-len(data))
+	return newBuffer(append(pad, data...))
+}
+
+func newBufferFromInt(num uint64) *byteBuffer {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, num)
+	return newBuffer(bytes.TrimLeft(data, "\x00"))
+}
+
+func (b *byteBuffer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.base64())
+}
+
+func (b *byteBuffer) UnmarshalJSON(data []byte) error {
+	var encoded string
+	err := json.Unmarshal(data, &encoded)
+	if err != nil {
+		return err
+	}
+
+	if encoded == "" {
+		return nil
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+
+	*b = *newBuffer(decoded)
+
+	return nil
+}
+
+func (b *byteBuffer) base64() string {
+	return base64.RawURLEncoding.EncodeToString(b.data)
+}
+
+func (b *byteBuffer) bytes() []byte {
+	// Handling nil here allows us to transparently handle nil slices when serializing.
+	if b == nil {
+		return nil
+	}
+	return b.data
+}
+
+func (b byteBuffer) bigInt() *big.Int {
+	return new(big.Int).SetBytes(b.data)
+}
+
+func (b byteBuffer) toInt() int {
+	return int(b.bigInt().Int64())
+}
@@ -0,0 +1,77 @@
+/*-
+ * Copyright 2016 Square, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jose
+
+import (
+	"strings"
+	"testing"
+)
+
+// Seed inputs shared by both fuzz targets: valid messages, truncated base64,
+// missing segments and oversized header JSON. The fuzzer mutates these into
+// the malformed shapes that have caused parser panics in the past.
+var fuzzSeeds = []string{
+	"",
+	".",
+	"..",
+	"....",
+	".....",
+	"eyJhbGciOiJFUzI1NiJ9.dGVzdA.dGVzdA",
+	"eyJhbGciOiJSU0EtT0FFUCIsImVuYyI6IkExMjhHQ00ifQ.dGVzdA.dGVzdA.dGVzdA.dGVzdA",
+	"eyJhbGciOiJFUzI1NiJ9.dGVzdA.dGVzd",
+	"e30.e30.e30",
+	"eyJhbGciOiJub25lIn0..",
+	"{}",
+	`{"signatures":[]}`,
+	`{"payload":"dGVzdA","signatures":[{"protected":"e30","signature":"dGVzdA"}]}`,
+	`{"ciphertext":"dGVzdA"}`,
+	`{"protected":"e30","iv":"dGVzdA","ciphertext":"dGVzdA","tag":"dGVzdA"}`,
+	"eyJhbGciOiJFUzI1NiIsInBhZCI6Ij" + strings.Repeat("QUFBQUFB", 512) + "In0.dGVzdA.dGVzdA",
+}
+
+func FuzzParseSigned(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data string) {
+		obj, err := ParseSigned(data)
+		if err != nil {
+			return
+		}
+
+		// Objects that parse must survive re-serialization.
+		obj.FullSerialize()
+		_, _ = obj.CompactSerialize()
+	})
+}
+
+func FuzzParseEncrypted(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data string) {
+		obj, err := ParseEncrypted(data)
+		if err != nil {
+			return
+		}
+
+		obj.FullSerialize()
+		_, _ = obj.CompactSerialize()
+	})
+}
@@ -0,0 +1,511 @@
+/*-
+ * Copyright 2014 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jose
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/square/go-jose.v2/json"
+)
+
+// rawJSONWebEncryption represents a raw JWE JSON object. Used for parsing/serializing.
+type rawJSONWebEncryption struct {
+	Protected    *byteBuffer        `json:"protected,omitempty"`
+	Unprotected  *rawHeader         `json:"unprotected,omitempty"`
+	Header       *rawHeader         `json:"header,omitempty"`
+	Recipients   []rawRecipientInfo `json:"recipients,omitempty"`
+	Aad          *byteBuffer        `json:"aad,omitempty"`
+	EncryptedKey *byteBuffer        `json:"encrypted_key,omitempty"`
+	Iv           *byteBuffer        `json:"iv,omitempty"`
+	Ciphertext   *byteBuffer        `json:"ciphertext,omitempty"`
+	Tag          *byteBuffer        `json:"tag,omitempty"`
+}
+
+// rawRecipientInfo represents a raw JWE Per-Recipient header JSON object. Used for parsing/serializing.
+type rawRecipientInfo struct {
+	Header       *rawHeader `json:"header,omitempty"`
+	EncryptedKey string     `json:"encrypted_key,omitempty"`
+}
+
+// JSONWebEncryption represents an encrypted JWE object after parsing.
+type JSONWebEncryption struct {
+	Header                   Header
+	protected, unprotected   *rawHeader
+	recipients               []recipientInfo
+	aad, iv, ciphertext, tag []byte
+	original                 *rawJSONWebEncryption
+	customDeriveECDHES       CustomDeriveECDHES
+	minimumRSAKeySize        int
+	maxDecompressedSize      int64
+	understoodCritical       []string
+	minPBES2Count            int
+	maxPBES2Count            int
+	allowedKeyAlgorithms     []KeyAlgorithm
+	allowedContentEncryption []ContentEncryption
+	expectedType             string
+	maxCiphertextBytes       int64
+	allowLegacyRSA15         bool
+}
+
+// keyAlgorithmAllowed reports whether alg is on the decrypt allowlist; an
+// empty allowlist allows every algorithm.
+func (obj JSONWebEncryption) keyAlgorithmAllowed(alg KeyAlgorithm) bool {
+	if len(obj.allowedKeyAlgorithms) == 0 {
+		return true
+	}
+	for _, allowed := range obj.allowedKeyAlgorithms {
+		if allowed == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// legacyRSA15Allowed reports whether the deprecated RSA1_5 key algorithm may
+// be used to decrypt this object: either through the explicit option or by
+// listing RSA1_5 on the key algorithm allowlist.
+func (obj JSONWebEncryption) legacyRSA15Allowed() bool {
+	if obj.allowLegacyRSA15 {
+		return true
+	}
+	for _, allowed := range obj.allowedKeyAlgorithms {
+		if allowed == RSA1_5 {
+			return true
+		}
+	}
+	return false
+}
+
+// contentEncryptionAllowed reports whether enc is on the decrypt allowlist;
+// an empty allowlist allows every algorithm.
+func (obj JSONWebEncryption) contentEncryptionAllowed(enc ContentEncryption) bool {
+	if len(obj.allowedContentEncryption) == 0 {
+		return true
+	}
+	for _, allowed := range obj.allowedContentEncryption {
+		if allowed == enc {
+			return true
+		}
+	}
+	return false
+}
+
+// recipientInfo represents a raw JWE Per-Recipient header JSON object after parsing.
+type recipientInfo struct {
+	header       *rawHeader
+	encryptedKey []byte
+}
+
+// GetAuthData retrieves the (optional) authenticated data attached to the object.
+func (obj JSONWebEncryption) GetAuthData() []byte {
+	if obj.aad != nil {
+		out := make([]byte, len(obj.aad))
+		copy(out, obj.aad)
+		return out
+	}
+
+	return nil
+}
+
+// Get the merged header values
+// ProtectedHeader returns the parsed protected header, with typed fields and
+// any extra values in ExtraHeaders. Unlike the Header field, which merges the
+// unprotected headers in, only values covered by the authentication tag are
+// included, so after a successful Decrypt it can be trusted — for example to
+// route on the key ID. An object without a protected header yields the zero
+// Header.
+func (obj JSONWebEncryption) ProtectedHeader() (Header, error) {
+	if obj.protected == nil {
+		return Header{}, nil
+	}
+	return obj.protected.sanitized()
+}
+
+// EphemeralPublicKey returns the ephemeral public key from the "epk" header,
+// as generated during ECDH-ES encryption, so the key used for an exchange can
+// be logged or audited without re-parsing the serialized output. Objects whose
+// key management algorithm carries no ephemeral key return nil. For
+// multi-recipient objects the per-recipient headers are consulted as well and
+// the first epk found is returned.
+func (obj JSONWebEncryption) EphemeralPublicKey() (*JSONWebKey, error) {
+	if epk, err := obj.mergedHeaders(nil).getEPK(); err != nil || epk != nil {
+		return epk, err
+	}
+
+	for _, recipient := range obj.recipients {
+		if epk, err := obj.mergedHeaders(&recipient).getEPK(); err != nil || epk != nil {
+			return epk, err
+		}
+	}
+
+	return nil, nil
+}
+
+func (obj JSONWebEncryption) mergedHeaders(recipient *recipientInfo) rawHeader {
+	out := rawHeader{}
+	out.merge(obj.protected)
+	out.merge(obj.unprotected)
+
+	if recipient != nil {
+		out.merge(recipient.header)
+	}
+
+	return out
+}
+
+// Get the additional authenticated data from a JWE object.
+func (obj JSONWebEncryption) computeAuthData() []byte {
+	var protected string
+
+	if obj.original != nil && obj.original.Protected != nil {
+		protected = obj.original.Protected.base64()
+	} else if obj.protected != nil {
+		protected = base64.RawURLEncoding.EncodeToString(mustSerializeJSON((obj.protected)))
+	} else {
+		protected = ""
+	}
+
+	output := []byte(protected)
+	if obj.aad != nil {
+		output = append(output, '.')
+		output = append(output, []byte(base64.RawURLEncoding.EncodeToString(obj.aad))...)
+	}
+
+	return output
+}
+
+// ParseEncrypted parses an encrypted message in compact or full serialization format.
+func ParseEncrypted(input string) (*JSONWebEncryption, error) {
+	input = stripWhitespace(input)
+	if strings.HasPrefix(input, "{") {
+		return parseEncryptedFull(input)
+	}
+
+	return parseEncryptedCompact(input)
+}
+
+// ParseEncryptedJSON parses an encrypted message in the full JSON
+// serialization format only, rejecting compact input with a descriptive
+// error.
+func ParseEncryptedJSON(input string) (*JSONWebEncryption, error) {
+	input = stripWhitespace(input)
+	if !strings.HasPrefix(input, "{") {
+		return nil, fmt.Errorf("square/go-jose: expected JWE in JSON serialization, got compact form")
+	}
+
+	return parseEncryptedFull(input)
+}
+
+// ParseEncryptedCompact parses an encrypted message in the compact
+// serialization format only, rejecting JSON input with a descriptive error.
+func ParseEncryptedCompact(input string) (*JSONWebEncryption, error) {
+	input = stripWhitespace(input)
+	if strings.HasPrefix(input, "{") {
+		return nil, fmt.Errorf("square/go-jose: expected JWE in compact serialization, got JSON form")
+	}
+
+	return parseEncryptedCompact(input)
+}
+
+// ParseEncryptedWithOptions parses an encrypted message in compact or full
+// serialization format. Options set here, such as a custom ECDH-ES key
+// derivation, apply to every Decrypt call on the returned object, which
+// avoids passing the derivation to each call site separately.
+func ParseEncryptedWithOptions(input string, opts *DecrypterOptions) (*JSONWebEncryption, error) {
+	if opts != nil && opts.MaxCiphertextBytes > 0 {
+		if err := checkCompactCiphertextSize(input, opts.MaxCiphertextBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	obj, err := ParseEncrypted(input)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts != nil {
+		obj.customDeriveECDHES = opts.CustomDeriveECDHES
+		obj.minimumRSAKeySize = opts.MinimumRSAKeySize
+		obj.maxDecompressedSize = opts.MaxDecompressedSize
+		obj.understoodCritical = opts.UnderstoodCritical
+		obj.minPBES2Count = opts.MinPBES2Count
+		obj.maxPBES2Count = opts.MaxPBES2Count
+		obj.allowedKeyAlgorithms = opts.AllowedKeyAlgorithms
+		obj.allowedContentEncryption = opts.AllowedContentEncryption
+		obj.expectedType = opts.ExpectedType
+		obj.maxCiphertextBytes = opts.MaxCiphertextBytes
+		obj.allowLegacyRSA15 = opts.AllowLegacyRSA15
+	}
+
+	return obj, nil
+}
+
+// checkCompactCiphertextSize rejects compact input whose ciphertext segment
+// would decode to more than limit bytes, before any base64 decoding allocates
+// room for it. JSON input passes through and is bounded after parsing
+// instead, since its ciphertext member cannot be located without decoding the
+// envelope. Input that does not have enough segments is left for the parser
+// to reject with its usual error.
+func checkCompactCiphertextSize(input string, limit int64) error {
+	input = stripWhitespace(input)
+	if strings.HasPrefix(input, "{") {
+		return nil
+	}
+
+	parts := strings.Split(input, ".")
+	if len(parts) < 4 {
+		return nil
+	}
+
+	if int64(base64.RawURLEncoding.DecodedLen(len(parts[3]))) > limit {
+		return fmt.Errorf("square/go-jose: ciphertext is larger than the configured limit of %d bytes", limit)
+	}
+	return nil
+}
+
+// checkCiphertextLimit enforces the configured ciphertext cap on a parsed
+// object, covering messages in the JSON serializations.
+func (obj JSONWebEncryption) checkCiphertextLimit() error {
+	if obj.maxCiphertextBytes > 0 && int64(len(obj.ciphertext)) > obj.maxCiphertextBytes {
+		return fmt.Errorf("square/go-jose: ciphertext is larger than the configured limit of %d bytes", obj.maxCiphertextBytes)
+	}
+	return nil
+}
+
+// parseEncryptedFull parses a message in compact format.
+func parseEncryptedFull(input string) (*JSONWebEncryption, error) {
+	var parsed rawJSONWebEncryption
+	err := json.Unmarshal([]byte(input), &parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsed.sanitized()
+}
+
+// sanitized produces a cleaned-up JWE object from the raw JSON.
+func (parsed *rawJSONWebEncryption) sanitized() (*JSONWebEncryption, error) {
+	obj := &JSONWebEncryption{
+		original:    parsed,
+		unprotected: parsed.Unprotected,
+	}
+
+	// Check that there is not a nonce in the unprotected headers
+	if parsed.Unprotected != nil {
+		if nonce := parsed.Unprotected.getNonce(); nonce != "" {
+			return nil, ErrUnprotectedNonce
+		}
+	}
+	if parsed.Header != nil {
+		if nonce := parsed.Header.getNonce(); nonce != "" {
+			return nil, ErrUnprotectedNonce
+		}
+	}
+
+	if parsed.Protected != nil && len(parsed.Protected.bytes()) > 0 {
+		err := json.Unmarshal(parsed.Protected.bytes(), &obj.protected)
+		if err != nil {
+			return nil, fmt.Errorf("square/go-jose: invalid protected header: %s, %s", err, parsed.Protected.base64())
+		}
+	}
+
+	// Note: this must be called _after_ we parse the protected header,
+	// otherwise fields from the protected header will not get picked up.
+	var err error
+	mergedHeaders := obj.mergedHeaders(nil)
+	obj.Header, err = mergedHeaders.sanitized()
+	if err != nil {
+		return nil, fmt.Errorf("square/go-jose: cannot sanitize merged headers: %v (%v)", err, mergedHeaders)
+	}
+
+	if len(parsed.Recipients) == 0 {
+		obj.recipients = []recipientInfo{
+			{
+				header:       parsed.Header,
+				encryptedKey: parsed.EncryptedKey.bytes(),
+			},
+		}
+	} else {
+		obj.recipients = make([]recipientInfo, len(parsed.Recipients))
+		for r := range parsed.Recipients {
+			encryptedKey, err := base64.RawURLEncoding.DecodeString(parsed.Recipients[r].EncryptedKey)
+			if err != nil {
+				return nil, err
+			}
+
+			// Check that there is not a nonce in the unprotected header
+			if parsed.Recipients[r].Header != nil && parsed.Recipients[r].Header.getNonce() != "" {
+				return nil, ErrUnprotectedNonce
+			}
+
+			obj.recipients[r].header = parsed.Recipients[r].Header
+			obj.recipients[r].encryptedKey = encryptedKey
+		}
+	}
+
+	for _, recipient := range obj.recipients {
+		headers := obj.mergedHeaders(&recipient)
+		if headers.getAlgorithm() == "" || headers.getEncryption() == "" {
+			return nil, fmt.Errorf("square/go-jose: message is missing alg/enc headers")
+		}
+	}
+
+	obj.iv = parsed.Iv.bytes()
+	obj.ciphertext = parsed.Ciphertext.bytes()
+	obj.tag = parsed.Tag.bytes()
+	obj.aad = parsed.Aad.bytes()
+
+	return obj, nil
+}
+
+// parseEncryptedCompact parses a message in compact format.
+func parseEncryptedCompact(input string) (*JSONWebEncryption, error) {
+	parts := strings.Split(input, ".")
+
+	// Tolerate a stray trailing separator, which some senders append; an
+	// empty sixth segment is dropped rather than rejected. The encrypted key
+	// segment, by contrast, is legitimately empty for direct modes such as
+	// ECDH-ES and must be kept in place.
+	if len(parts) == 6 && parts[5] == "" {
+		parts = parts[:5]
+	}
+
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("square/go-jose: compact JWE format must have five parts, got %d", len(parts))
+	}
+
+	rawProtected, err := base64URLDecode(parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedKey, err := base64URLDecode(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	iv, err := base64URLDecode(parts[2])
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := base64URLDecode(parts[3])
+	if err != nil {
+		return nil, err
+	}
+
+	tag, err := base64URLDecode(parts[4])
+	if err != nil {
+		return nil, err
+	}
+
+	raw := &rawJSONWebEncryption{
+		Protected:    newBuffer(rawProtected),
+		EncryptedKey: newBuffer(encryptedKey),
+		Iv:           newBuffer(iv),
+		Ciphertext:   newBuffer(ciphertext),
+		Tag:          newBuffer(tag),
+	}
+
+	return raw.sanitized()
+}
+
+// CompactSerialize serializes an object using the compact serialization format.
+func (obj JSONWebEncryption) CompactSerialize() (string, error) {
+	// Explicit AAD is only representable in the JSON serializations; it is
+	// part of the authenticated data, so dropping it here would produce a
+	// token that can never be decrypted.
+	if len(obj.recipients) != 1 || obj.unprotected != nil ||
+		obj.protected == nil || obj.recipients[0].header != nil ||
+		obj.aad != nil {
+		return "", ErrNotCompactSerializable
+	}
+
+	serializedProtected := mustSerializeJSON(obj.protected)
+
+	return fmt.Sprintf(
+		"%s.%s.%s.%s.%s",
+		base64.RawURLEncoding.EncodeToString(serializedProtected),
+		base64.RawURLEncoding.EncodeToString(obj.recipients[0].encryptedKey),
+		base64.RawURLEncoding.EncodeToString(obj.iv),
+		base64.RawURLEncoding.EncodeToString(obj.ciphertext),
+		base64.RawURLEncoding.EncodeToString(obj.tag)), nil
+}
+
+// CompactSerializeTo writes the compact serialization of the object to the
+// given writer and returns the number of bytes written. It produces the same
+// bytes as CompactSerialize without building the whole token in memory first,
+// which saves a copy when writing tokens straight into an HTTP response.
+func (obj JSONWebEncryption) CompactSerializeTo(w io.Writer) (int, error) {
+	if len(obj.recipients) != 1 || obj.unprotected != nil ||
+		obj.protected == nil || obj.recipients[0].header != nil ||
+		obj.aad != nil {
+		return 0, ErrNotCompactSerializable
+	}
+
+	cw := &countingWriter{w: w}
+	err := writeCompactSegments(cw,
+		mustSerializeJSON(obj.protected),
+		obj.recipients[0].encryptedKey,
+		obj.iv,
+		obj.ciphertext,
+		obj.tag)
+
+	return cw.n, err
+}
+
+// FullSerialize serializes an object using the full JSON serialization format.
+func (obj JSONWebEncryption) FullSerialize() string {
+	raw := rawJSONWebEncryption{
+		Unprotected: obj.unprotected,
+		Iv:          newBuffer(obj.iv),
+		Ciphertext:  newBuffer(obj.ciphertext),
+		Tag:         newBuffer(obj.tag),
+	}
+
+	// The "aad" member is optional; don't emit an empty placeholder.
+	if len(obj.aad) > 0 {
+		raw.Aad = newBuffer(obj.aad)
+	}
+
+	if len(obj.recipients) > 1 {
+		// General syntax: encrypted keys live inside the per-recipient
+		// objects, never at the top level.
+		for _, recipient := range obj.recipients {
+			info := rawRecipientInfo{
+				Header:       recipient.header,
+				EncryptedKey: base64.RawURLEncoding.EncodeToString(recipient.encryptedKey),
+			}
+			raw.Recipients = append(raw.Recipients, info)
+		}
+	} else {
+		// Use flattened serialization
+		raw.Header = obj.recipients[0].header
+		if len(obj.recipients[0].encryptedKey) > 0 {
+			raw.EncryptedKey = newBuffer(obj.recipients[0].encryptedKey)
+		}
+	}
+
+	if obj.protected != nil {
+		raw.Protected = newBuffer(mustSerializeJSON(obj.protected))
+	}
+
+	return string(mustSerializeJSON(raw))
+}
@@ -0,0 +1,1292 @@
+/*-
+ * Copyright 2014 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jose
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"golang.org/x/crypto/ed25519"
+
+	"gopkg.in/square/go-jose.v2/json"
+)
+
+// rawJSONWebKey represents a public or private key in JWK format, used for parsing/serializing.
+type rawJSONWebKey struct {
+	Use    string      `json:"use,omitempty"`
+	KeyOps []string    `json:"key_ops,omitempty"`
+	Kty    string      `json:"kty,omitempty"`
+	Kid    string      `json:"kid,omitempty"`
+	Crv    string      `json:"crv,omitempty"`
+	Alg    string      `json:"alg,omitempty"`
+	K      *byteBuffer `json:"k,omitempty"`
+	X      *byteBuffer `json:"x,omitempty"`
+	Y      *byteBuffer `json:"y,omitempty"`
+	N      *byteBuffer `json:"n,omitempty"`
+	E      *byteBuffer `json:"e,omitempty"`
+	// -- Following fields are only used for private keys --
+	// RSA uses D, P and Q, while ECDSA uses only D. Fields Dp, Dq, and Qi are
+	// completely optional. Therefore for RSA/ECDSA, D != nil is a contract that
+	// we have a private key whereas D == nil means we have only a public key.
+	D  *byteBuffer `json:"d,omitempty"`
+	P  *byteBuffer `json:"p,omitempty"`
+	Q  *byteBuffer `json:"q,omitempty"`
+	Dp *byteBuffer `json:"dp,omitempty"`
+	Dq *byteBuffer `json:"dq,omitempty"`
+	Qi *byteBuffer `json:"qi,omitempty"`
+	// Certificates
+	X5c       []string `json:"x5c,omitempty"`
+	X5u       *url.URL `json:"x5u,omitempty"`
+	X5tSHA1   string   `json:"x5t,omitempty"`
+	X5tSHA256 string   `json:"x5t#S256,omitempty"`
+}
+
+// jwkKnownMembers lists the top-level JWK member names handled by
+// rawJSONWebKey. Any other member is preserved in ExtraMembers so that
+// round-tripping a key does not drop vendor extensions.
+var jwkKnownMembers = map[string]bool{
+	"use": true, "key_ops": true, "kty": true, "kid": true, "crv": true,
+	"alg": true, "k": true, "x": true, "y": true, "n": true, "e": true,
+	"d": true, "p": true, "q": true, "dp": true, "dq": true, "qi": true,
+	"x5c": true, "x5u": true, "x5t": true, "x5t#S256": true,
+}
+
+// JSONWebKey represents a public or private key in JWK format.
+type JSONWebKey struct {
+	// Cryptographic key, can be a symmetric or asymmetric key.
+	Key interface{}
+	// Key identifier, parsed from `kid` header.
+	KeyID string
+	// Key algorithm, parsed from `alg` header.
+	Algorithm string
+	// Key use, parsed from `use` header.
+	Use string
+	// Key operations, parsed from `key_ops` header. Mutually exclusive with
+	// Use per RFC 7517, Section 4.3; when both are present they must convey
+	// consistent information.
+	KeyOps []string
+
+	// X.509 certificate chain, parsed from `x5c` header.
+	Certificates []*x509.Certificate
+	// X.509 certificate URL, parsed from `x5u` header.
+	CertificatesURL *url.URL
+	// X.509 certificate thumbprint (SHA-1), parsed from `x5t` header.
+	CertificateThumbprintSHA1 []byte
+	// X.509 certificate thumbprint (SHA-256), parsed from `x5t#S256` header.
+	CertificateThumbprintSHA256 []byte
+
+	// Any top-level members not recognised by this library, such as vendor
+	// extensions, get unmarshalled in a generic manner and placed in this
+	// map. They are re-emitted on marshal so round-trips are lossless.
+	ExtraMembers map[string]interface{}
+}
+
+// MarshalJSON serializes the given key to its JSON representation.
+func (k JSONWebKey) MarshalJSON() ([]byte, error) {
+	var raw *rawJSONWebKey
+	var err error
+
+	switch key := k.Key.(type) {
+	case ed25519.PublicKey:
+		raw = fromEdPublicKey(key)
+	case *ecdh.PublicKey:
+		raw, err = fromX25519PublicKey(key)
+	case *ecdsa.PublicKey:
+		raw, err = fromEcPublicKey(key)
+	case *rsa.PublicKey:
+		raw = fromRsaPublicKey(key)
+	case ed25519.PrivateKey:
+		raw, err = fromEdPrivateKey(key)
+	case *ecdh.PrivateKey:
+		raw, err = fromX25519PrivateKey(key)
+	case *ecdsa.PrivateKey:
+		raw, err = fromEcPrivateKey(key)
+	case *rsa.PrivateKey:
+		raw, err = fromRsaPrivateKey(key)
+	case []byte:
+		raw, err = fromSymmetricKey(key)
+	default:
+		return nil, fmt.Errorf("square/go-jose: unknown key type '%s': %w", reflect.TypeOf(key), ErrUnsupportedKeyType)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateUseKeyOps(k.Use, k.KeyOps); err != nil {
+		return nil, err
+	}
+
+	raw.Kid = k.KeyID
+	raw.Alg = k.Algorithm
+	raw.Use = k.Use
+	raw.KeyOps = k.KeyOps
+
+	for _, cert := range k.Certificates {
+		raw.X5c = append(raw.X5c, base64.StdEncoding.EncodeToString(cert.Raw))
+	}
+
+	x5tSHA1Len := len(k.CertificateThumbprintSHA1)
+	x5tSHA256Len := len(k.CertificateThumbprintSHA256)
+	if x5tSHA1Len > 0 {
+		if x5tSHA1Len != sha1.Size {
+			return nil, fmt.Errorf("square/go-jose: invalid SHA-1 thumbprint (must be %d bytes, not %d)", sha1.Size, x5tSHA1Len)
+		}
+		raw.X5tSHA1 = base64.RawURLEncoding.EncodeToString(k.CertificateThumbprintSHA1)
+	}
+	if x5tSHA256Len > 0 {
+		if x5tSHA256Len != sha256.Size {
+			return nil, fmt.Errorf("square/go-jose: invalid SHA-256 thumbprint (must be %d bytes, not %d)", sha256.Size, x5tSHA256Len)
+		}
+		raw.X5tSHA256 = base64.RawURLEncoding.EncodeToString(k.CertificateThumbprintSHA256)
+	}
+
+	// If cert chain is attached (as opposed to being behind a URL), check the
+	// keys thumbprints to make sure they match what is expected. This is to
+	// ensure we don't accidentally produce a JWK with semantically inconsistent
+	// data in the headers.
+	if len(k.Certificates) > 0 {
+		expectedSHA1 := sha1.Sum(k.Certificates[0].Raw)
+		expectedSHA256 := sha256.Sum256(k.Certificates[0].Raw)
+
+		if len(k.CertificateThumbprintSHA1) > 0 && !bytes.Equal(k.CertificateThumbprintSHA1, expectedSHA1[:]) {
+			return nil, errors.New("square/go-jose: invalid SHA-1 thumbprint, does not match cert chain")
+		}
+		if len(k.CertificateThumbprintSHA256) > 0 && !bytes.Equal(k.CertificateThumbprintSHA256, expectedSHA256[:]) {
+			return nil, errors.New("square/go-jose: invalid or SHA-256 thumbprint, does not match cert chain")
+		}
+	}
+
+	raw.X5u = k.CertificatesURL
+
+	data, err := json.Marshal(raw)
+	if err != nil || len(k.ExtraMembers) == 0 {
+		return data, err
+	}
+
+	// Merge extra members into the serialized form. Known member names are
+	// rejected rather than overridden, so extras cannot silently change the
+	// meaning of the key.
+	var merged map[string]interface{}
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return nil, err
+	}
+	for name, value := range k.ExtraMembers {
+		if jwkKnownMembers[name] {
+			return nil, fmt.Errorf("square/go-jose: extra member '%s' would shadow a standard JWK member", name)
+		}
+		merged[name] = value
+	}
+
+	return json.Marshal(merged)
+}
+
+// UnmarshalJSON reads a key from its JSON representation.
+func (k *JSONWebKey) UnmarshalJSON(data []byte) (err error) {
+	var raw rawJSONWebKey
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	certs, err := parseCertificateChain(raw.X5c)
+	if err != nil {
+		return fmt.Errorf("square/go-jose: failed to unmarshal x5c field: %s", err)
+	}
+
+	if err := validateUseKeyOps(raw.Use, raw.KeyOps); err != nil {
+		return err
+	}
+
+	var key interface{}
+	var certPub interface{}
+	var keyPub interface{}
+
+	if len(certs) > 0 {
+		// We need to check that leaf public key matches the key embedded in this
+		// JWK, as required by the standard (see RFC 7517, Section 4.7). Otherwise
+		// the JWK parsed could be semantically invalid. Technically, should also
+		// check key usage fields and other extensions on the cert here, but the
+		// standard doesn't exactly explain how they're supposed to map from the
+		// JWK representation to the X.509 extensions.
+		certPub = certs[0].PublicKey
+	}
+
+	switch raw.Kty {
+	case "EC":
+		if raw.D != nil {
+			key, err = raw.ecPrivateKey()
+			if err == nil {
+				keyPub = key.(*ecdsa.PrivateKey).Public()
+			}
+		} else {
+			key, err = raw.ecPublicKey()
+			keyPub = key
+		}
+	case "RSA":
+		if raw.D != nil {
+			key, err = raw.rsaPrivateKey()
+			if err == nil {
+				keyPub = key.(*rsa.PrivateKey).Public()
+			}
+		} else {
+			key, err = raw.rsaPublicKey()
+			keyPub = key
+		}
+	case "oct":
+		if certPub != nil {
+			return errors.New("square/go-jose: invalid JWK, found 'oct' (symmetric) key with cert chain")
+		}
+		key, err = raw.symmetricKey()
+	case "OKP":
+		switch {
+		case raw.Crv == "Ed25519" && raw.X != nil:
+			if raw.D != nil {
+				key, err = raw.edPrivateKey()
+				if err == nil {
+					keyPub = key.(ed25519.PrivateKey).Public()
+				}
+			} else {
+				key, err = raw.edPublicKey()
+				keyPub = key
+			}
+		case raw.Crv == "X25519" && raw.X != nil:
+			if raw.D != nil {
+				key, err = raw.x25519PrivateKey()
+				if err == nil {
+					keyPub = key.(*ecdh.PrivateKey).PublicKey()
+				}
+			} else {
+				key, err = raw.x25519PublicKey()
+				keyPub = key
+			}
+		case raw.Crv == "Ed448" || raw.Crv == "X448":
+			// Recognized RFC 8037 curves, but Go has no Ed448/X448
+			// implementation in the standard library or x/crypto, so
+			// reject them explicitly rather than as an unknown curve.
+			err = fmt.Errorf("square/go-jose: unsupported curve '%s', Ed448/X448 are not implemented", raw.Crv)
+		default:
+			err = fmt.Errorf("square/go-jose: unknown curve %s'", raw.Crv)
+		}
+	default:
+		err = fmt.Errorf("square/go-jose: unknown json web key type '%s'", raw.Kty)
+	}
+
+	if err != nil {
+		return
+	}
+
+	if certPub != nil && keyPub != nil {
+		if !reflect.DeepEqual(certPub, keyPub) {
+			return errors.New("square/go-jose: invalid JWK, public keys in key and x5c fields do not match")
+		}
+	}
+
+	*k = JSONWebKey{Key: key, KeyID: raw.Kid, Algorithm: raw.Alg, Use: raw.Use, KeyOps: raw.KeyOps, Certificates: certs}
+
+	k.CertificatesURL = raw.X5u
+
+	// x5t parameters are base64url-encoded SHA thumbprints
+	// See RFC 7517, Section 4.8, https://tools.ietf.org/html/rfc7517#section-4.8
+	x5tSHA1bytes, err := base64.RawURLEncoding.DecodeString(raw.X5tSHA1)
+	if err != nil {
+		return errors.New("square/go-jose: invalid JWK, x5t header has invalid encoding")
+	}
+
+	// RFC 7517, Section 4.8 is ambiguous as to whether the digest output should be byte or hex,
+	// for this reason, after base64 decoding, if the size is sha1.Size it's likely that the value is a byte encoded
+	// checksum so we skip this. Otherwise if the checksum was hex encoded we expect a 40 byte sized array so we'll
+	// try to hex decode it. When Marshalling this value we'll always use a base64 encoded version of byte format checksum.
+	if len(x5tSHA1bytes) == 2*sha1.Size {
+		hx, err := hex.DecodeString(string(x5tSHA1bytes))
+		if err != nil {
+			return fmt.Errorf("square/go-jose: invalid JWK, unable to hex decode x5t: %v", err)
+
+		}
+		x5tSHA1bytes = hx
+	}
+
+	k.CertificateThumbprintSHA1 = x5tSHA1bytes
+
+	x5tSHA256bytes, err := base64.RawURLEncoding.DecodeString(raw.X5tSHA256)
+	if err != nil {
+		return errors.New("square/go-jose: invalid JWK, x5t#S256 header has invalid encoding")
+	}
+
+	if len(x5tSHA256bytes) == 2*sha256.Size {
+		hx256, err := hex.DecodeString(string(x5tSHA256bytes))
+		if err != nil {
+			return fmt.Errorf("square/go-jose: invalid JWK, unable to hex decode x5t#S256: %v", err)
+		}
+		x5tSHA256bytes = hx256
+	}
+
+	k.CertificateThumbprintSHA256 = x5tSHA256bytes
+
+	x5tSHA1Len := len(k.CertificateThumbprintSHA1)
+	x5tSHA256Len := len(k.CertificateThumbprintSHA256)
+	if x5tSHA1Len > 0 && x5tSHA1Len != sha1.Size {
+		return errors.New("square/go-jose: invalid JWK, x5t header is of incorrect size")
+	}
+	if x5tSHA256Len > 0 && x5tSHA256Len != sha256.Size {
+		return errors.New("square/go-jose: invalid JWK, x5t#S256 header is of incorrect size")
+	}
+
+	// If certificate chain *and* thumbprints are set, verify correctness.
+	if len(k.Certificates) > 0 {
+		leaf := k.Certificates[0]
+		sha1sum := sha1.Sum(leaf.Raw)
+		sha256sum := sha256.Sum256(leaf.Raw)
+
+		if len(k.CertificateThumbprintSHA1) > 0 && !bytes.Equal(sha1sum[:], k.CertificateThumbprintSHA1) {
+			return errors.New("square/go-jose: invalid JWK, x5c thumbprint does not match x5t value")
+		}
+
+		if len(k.CertificateThumbprintSHA256) > 0 && !bytes.Equal(sha256sum[:], k.CertificateThumbprintSHA256) {
+			return errors.New("square/go-jose: invalid JWK, x5c thumbprint does not match x5t#S256 value")
+		}
+	}
+
+	// Collect any members not handled above so they survive a round-trip.
+	var members map[string]interface{}
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+	for name := range members {
+		if jwkKnownMembers[name] {
+			delete(members, name)
+		}
+	}
+	if len(members) > 0 {
+		k.ExtraMembers = members
+	}
+
+	return
+}
+
+// SetCertificateChain attaches an X.509 certificate chain to the key. It
+// populates the Certificates field and computes both leaf thumbprints ("x5t"
+// and "x5t#S256"), saving callers from encoding the chain by hand. The key
+// must already be set and must match the leaf certificate's public key.
+func (k *JSONWebKey) SetCertificateChain(certs []*x509.Certificate) error {
+	if len(certs) == 0 {
+		return errors.New("square/go-jose: empty certificate chain")
+	}
+
+	keyPub, err := publicKeyOf(k.Key)
+	if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(certs[0].PublicKey, keyPub) {
+		return errors.New("square/go-jose: key does not match leaf certificate public key")
+	}
+
+	sha1sum := sha1.Sum(certs[0].Raw)
+	sha256sum := sha256.Sum256(certs[0].Raw)
+
+	k.Certificates = certs
+	k.CertificateThumbprintSHA1 = sha1sum[:]
+	k.CertificateThumbprintSHA256 = sha256sum[:]
+
+	return nil
+}
+
+// publicKeyOf returns the public half of the given key, or the key itself
+// when it is already public.
+func publicKeyOf(key interface{}) (interface{}, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key.Public(), nil
+	case *ecdsa.PrivateKey:
+		return key.Public(), nil
+	case ed25519.PrivateKey:
+		return key.Public(), nil
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	}
+	return nil, errors.New("square/go-jose: unsupported key type for certificate chain")
+}
+
+// ValidateCertificateChain checks that the key matches the public key of the
+// leaf certificate in the attached chain, as required by RFC 7517, Section
+// 4.7. A JWK whose leaf certificate holds a different key is a spoofing risk
+// and must not be trusted. Keys without a certificate chain validate
+// trivially.
+func (k *JSONWebKey) ValidateCertificateChain() error {
+	if len(k.Certificates) == 0 {
+		return nil
+	}
+
+	keyPub, err := publicKeyOf(k.Key)
+	if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(k.Certificates[0].PublicKey, keyPub) {
+		return errors.New("square/go-jose: key does not match leaf certificate public key")
+	}
+
+	return nil
+}
+
+// keyOpsUse maps each RFC 7517 key operation to the "use" value it implies,
+// for checking that the two fields convey consistent information.
+var keyOpsUse = map[string]string{
+	"sign":       "sig",
+	"verify":     "sig",
+	"encrypt":    "enc",
+	"decrypt":    "enc",
+	"wrapKey":    "enc",
+	"unwrapKey":  "enc",
+	"deriveKey":  "enc",
+	"deriveBits": "enc",
+}
+
+// validateUseKeyOps checks that "use" and "key_ops", when both present, do
+// not contradict each other. Unknown operation names are tolerated since the
+// RFC allows extension values.
+func validateUseKeyOps(use string, ops []string) error {
+	if use == "" || len(ops) == 0 {
+		return nil
+	}
+	for _, op := range ops {
+		if implied, ok := keyOpsUse[op]; ok && implied != use {
+			return fmt.Errorf("square/go-jose: invalid JWK, key operation '%s' is inconsistent with use '%s'", op, use)
+		}
+	}
+	return nil
+}
+
+// AllowsKeyOp reports whether the key's "key_ops" list permits the given
+// RFC 7517 operation, such as "sign" or "decrypt". A key without a key_ops
+// list permits every operation.
+func (k *JSONWebKey) AllowsKeyOp(op string) bool {
+	if len(k.KeyOps) == 0 {
+		return true
+	}
+	for _, v := range k.KeyOps {
+		if v == op {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsAnyKeyOp reports whether the key permits at least one of the given
+// operations.
+func (k *JSONWebKey) allowsAnyKeyOp(ops ...string) bool {
+	if len(k.KeyOps) == 0 {
+		return true
+	}
+	for _, op := range ops {
+		if k.AllowsKeyOp(op) {
+			return true
+		}
+	}
+	return false
+}
+
+// JSONWebKeySet represents a JWK Set object.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// Key convenience method returns keys by key ID. Specification states
+// that a JWK Set "SHOULD" use distinct key IDs, but allows for some
+// cases where they are not distinct. Hence method returns a slice
+// of JSONWebKeys.
+func (s *JSONWebKeySet) Key(kid string) []JSONWebKey {
+	var keys []JSONWebKey
+	for _, key := range s.Keys {
+		if key.KeyID == kid {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+const rsaThumbprintTemplate = `{"e":"%s","kty":"RSA","n":"%s"}`
+const ecThumbprintTemplate = `{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`
+const edThumbprintTemplate = `{"crv":"%s","kty":"OKP","x":"%s"}`
+const octThumbprintTemplate = `{"k":"%s","kty":"oct"}`
+
+func ecThumbprintInput(curve elliptic.Curve, x, y *big.Int) (string, error) {
+	coordLength := curveSize(curve)
+	crv, err := curveName(curve)
+	if err != nil {
+		return "", err
+	}
+
+	if len(x.Bytes()) > coordLength || len(y.Bytes()) > coordLength {
+		return "", errors.New("square/go-jose: invalid elliptic key (too large)")
+	}
+
+	return fmt.Sprintf(ecThumbprintTemplate, crv,
+		newFixedSizeBuffer(x.Bytes(), coordLength).base64(),
+		newFixedSizeBuffer(y.Bytes(), coordLength).base64()), nil
+}
+
+func rsaThumbprintInput(n *big.Int, e int) (string, error) {
+	return fmt.Sprintf(rsaThumbprintTemplate,
+		newBufferFromInt(uint64(e)).base64(),
+		newBuffer(n.Bytes()).base64()), nil
+}
+
+func x25519ThumbprintInput(pub *ecdh.PublicKey) (string, error) {
+	if pub.Curve() != ecdh.X25519() {
+		return "", errors.New("square/go-jose: unsupported curve for OKP key")
+	}
+	return okpThumbprintInput("X25519", pub.Bytes())
+}
+
+func octThumbprintInput(key []byte) (string, error) {
+	if len(key) == 0 {
+		return "", errors.New("square/go-jose: invalid symmetric key (empty)")
+	}
+	return fmt.Sprintf(octThumbprintTemplate, newBuffer(key).base64()), nil
+}
+
+func okpThumbprintInput(crv string, x []byte) (string, error) {
+	if len(x) > 32 {
+		return "", errors.New("square/go-jose: invalid elliptic key (too large)")
+	}
+	return fmt.Sprintf(edThumbprintTemplate, crv,
+		newFixedSizeBuffer(x, 32).base64()), nil
+}
+
+// Thumbprint computes the JWK Thumbprint of a key using the
+// indicated hash algorithm.
+func (k *JSONWebKey) Thumbprint(hash crypto.Hash) ([]byte, error) {
+	var input string
+	var err error
+	switch key := k.Key.(type) {
+	case ed25519.PublicKey:
+		input, err = okpThumbprintInput("Ed25519", key)
+	case *ecdh.PublicKey:
+		input, err = x25519ThumbprintInput(key)
+	case *ecdsa.PublicKey:
+		input, err = ecThumbprintInput(key.Curve, key.X, key.Y)
+	case *ecdsa.PrivateKey:
+		input, err = ecThumbprintInput(key.Curve, key.X, key.Y)
+	case *rsa.PublicKey:
+		input, err = rsaThumbprintInput(key.N, key.E)
+	case *rsa.PrivateKey:
+		input, err = rsaThumbprintInput(key.N, key.E)
+	case ed25519.PrivateKey:
+		input, err = okpThumbprintInput("Ed25519", ed25519.PublicKey(key[32:]))
+	case *ecdh.PrivateKey:
+		input, err = x25519ThumbprintInput(key.PublicKey())
+	case []byte:
+		input, err = octThumbprintInput(key)
+	default:
+		return nil, fmt.Errorf("square/go-jose: unknown key type '%s': %w", reflect.TypeOf(key), ErrUnsupportedKeyType)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	h := hash.New()
+	h.Write([]byte(input))
+	return h.Sum(nil), nil
+}
+
+// ThumbprintString computes the JWK Thumbprint of a key using the indicated
+// hash algorithm and returns it base64url-encoded without padding, the form
+// used for "kid" values as described in RFC 7638.
+func (k *JSONWebKey) ThumbprintString(hash crypto.Hash) (string, error) {
+	thumbprint, err := k.Thumbprint(hash)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(thumbprint), nil
+}
+
+// GenerateKey generates a fresh private key and wraps it in a JSONWebKey
+// with the key ID set to the RFC 7638 SHA-256 thumbprint. Supported
+// combinations are kty "RSA" with a key size of at least 2048 bits (2048 if
+// bits is zero), kty "EC" with crv "P-256", "P-384" or "P-521", and kty
+// "OKP" with crv "Ed25519" or "X25519". For EC and OKP keys the size is
+// determined by the curve and bits must be zero.
+func GenerateKey(kty, crv string, bits int) (JSONWebKey, error) {
+	var key interface{}
+
+	switch kty {
+	case "RSA":
+		if crv != "" {
+			return JSONWebKey{}, fmt.Errorf("square/go-jose: unsupported curve '%s' for key type RSA", crv)
+		}
+		if bits == 0 {
+			bits = 2048
+		}
+		if bits < 2048 {
+			return JSONWebKey{}, errors.New("square/go-jose: RSA key size must be at least 2048 bits")
+		}
+		rsaKey, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return JSONWebKey{}, err
+		}
+		key = rsaKey
+	case "EC":
+		if bits != 0 {
+			return JSONWebKey{}, errors.New("square/go-jose: EC key size is determined by the curve, bits must be zero")
+		}
+		var curve elliptic.Curve
+		switch crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return JSONWebKey{}, fmt.Errorf("square/go-jose: unsupported curve '%s' for key type EC", crv)
+		}
+		ecKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return JSONWebKey{}, err
+		}
+		key = ecKey
+	case "OKP":
+		if bits != 0 {
+			return JSONWebKey{}, errors.New("square/go-jose: OKP key size is determined by the curve, bits must be zero")
+		}
+		switch crv {
+		case "Ed25519":
+			_, edKey, err := ed25519.GenerateKey(rand.Reader)
+			if err != nil {
+				return JSONWebKey{}, err
+			}
+			key = edKey
+		case "X25519":
+			xKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+			if err != nil {
+				return JSONWebKey{}, err
+			}
+			key = xKey
+		default:
+			return JSONWebKey{}, fmt.Errorf("square/go-jose: unsupported curve '%s' for key type OKP", crv)
+		}
+	default:
+		return JSONWebKey{}, fmt.Errorf("square/go-jose: unknown json web key type '%s'", kty)
+	}
+
+	jwk := JSONWebKey{Key: key}
+	kid, err := jwk.ThumbprintString(crypto.SHA256)
+	if err != nil {
+		return JSONWebKey{}, err
+	}
+	jwk.KeyID = kid
+
+	return jwk, nil
+}
+
+// IsPublic returns true if the JWK represents a public key (not symmetric, not private).
+func (k *JSONWebKey) IsPublic() bool {
+	switch k.Key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey, *ecdh.PublicKey:
+		return true
+	default:
+		return false
+	}
+}
+
+// Public creates JSONWebKey with corresponding public key if JWK represents asymmetric private key.
+func (k *JSONWebKey) Public() JSONWebKey {
+	if k.IsPublic() {
+		return *k
+	}
+	ret := *k
+	switch key := k.Key.(type) {
+	case *ecdsa.PrivateKey:
+		ret.Key = key.Public()
+	case *rsa.PrivateKey:
+		ret.Key = key.Public()
+	case ed25519.PrivateKey:
+		ret.Key = key.Public()
+	case *ecdh.PrivateKey:
+		ret.Key = key.PublicKey()
+	default:
+		return JSONWebKey{} // returning invalid key
+	}
+	return ret
+}
+
+// Valid checks that the key contains the expected parameters.
+func (k *JSONWebKey) Valid() bool {
+	if k.Key == nil {
+		return false
+	}
+	switch key := k.Key.(type) {
+	case *ecdsa.PublicKey:
+		if key.Curve == nil || key.X == nil || key.Y == nil {
+			return false
+		}
+	case *ecdsa.PrivateKey:
+		if key.Curve == nil || key.X == nil || key.Y == nil || key.D == nil {
+			return false
+		}
+	case *rsa.PublicKey:
+		if key.N == nil || key.E == 0 {
+			return false
+		}
+	case *rsa.PrivateKey:
+		if key.N == nil || key.E == 0 || key.D == nil || len(key.Primes) < 2 {
+			return false
+		}
+	case ed25519.PublicKey:
+		if len(key) != 32 {
+			return false
+		}
+	case ed25519.PrivateKey:
+		if len(key) != 64 {
+			return false
+		}
+	case *ecdh.PublicKey:
+		if key.Curve() != ecdh.X25519() {
+			return false
+		}
+	case *ecdh.PrivateKey:
+		if key.Curve() != ecdh.X25519() {
+			return false
+		}
+	case []byte:
+		if len(key) == 0 {
+			return false
+		}
+	default:
+		return false
+	}
+	if err := k.ValidateCertificateChain(); err != nil {
+		return false
+	}
+	if err := k.ValidateAlgorithm(); err != nil {
+		return false
+	}
+	return true
+}
+
+// ValidateAlgorithm checks that the key's declared "alg" parameter, if any,
+// is consistent with the embedded key material: RSA algorithms require an RSA
+// key, ECDSA algorithms require an EC key on the matching curve, and so on.
+// An empty or unrecognized algorithm passes, since the key may be intended
+// for a consumer with a wider algorithm set. Valid calls this internally;
+// use it directly to get the descriptive error for a mismatch.
+func (k *JSONWebKey) ValidateAlgorithm() error {
+	alg := k.Algorithm
+	switch SignatureAlgorithm(alg) {
+	case RS256, RS384, RS512, PS256, PS384, PS512:
+		return k.requireRSAKey(alg)
+	case ES256:
+		return k.requireECKey(alg, elliptic.P256())
+	case ES384:
+		return k.requireECKey(alg, elliptic.P384())
+	case ES512:
+		return k.requireECKey(alg, elliptic.P521())
+	case ES256K:
+		return k.requireECKey(alg, ellipticSecp256k1())
+	case EdDSA:
+		switch k.Key.(type) {
+		case ed25519.PublicKey, ed25519.PrivateKey:
+			return nil
+		}
+		return fmt.Errorf("square/go-jose: alg '%s' requires an Ed25519 key, got %T", alg, k.Key)
+	case HS256, HS384, HS512:
+		return k.requireSymmetricKey(alg)
+	}
+
+	switch KeyAlgorithm(alg) {
+	case RSA1_5, RSA_OAEP, RSA_OAEP_256, RSA_OAEP_384, RSA_OAEP_512:
+		return k.requireRSAKey(alg)
+	case DIRECT, A128KW, A192KW, A256KW, A128GCMKW, A192GCMKW, A256GCMKW,
+		PBES2_HS256_A128KW, PBES2_HS384_A192KW, PBES2_HS512_A256KW:
+		return k.requireSymmetricKey(alg)
+	case ECDH_ES, ECDH_ES_A128KW, ECDH_ES_A192KW, ECDH_ES_A256KW,
+		ECDH_1PU, ECDH_1PU_A128KW, ECDH_1PU_A192KW, ECDH_1PU_A256KW:
+		switch k.Key.(type) {
+		case *ecdsa.PublicKey, *ecdsa.PrivateKey, *ecdh.PublicKey, *ecdh.PrivateKey:
+			return nil
+		}
+		return fmt.Errorf("square/go-jose: alg '%s' requires an EC or X25519 key, got %T", alg, k.Key)
+	}
+
+	return nil
+}
+
+func (k *JSONWebKey) requireRSAKey(alg string) error {
+	switch k.Key.(type) {
+	case *rsa.PublicKey, *rsa.PrivateKey:
+		return nil
+	}
+	return fmt.Errorf("square/go-jose: alg '%s' requires an RSA key, got %T", alg, k.Key)
+}
+
+func (k *JSONWebKey) requireECKey(alg string, expected elliptic.Curve) error {
+	var curve elliptic.Curve
+	switch key := k.Key.(type) {
+	case *ecdsa.PublicKey:
+		curve = key.Curve
+	case *ecdsa.PrivateKey:
+		curve = key.Curve
+	default:
+		return fmt.Errorf("square/go-jose: alg '%s' requires an EC key, got %T", alg, k.Key)
+	}
+	if curve != expected {
+		return fmt.Errorf("square/go-jose: alg '%s' requires curve %s, got %s",
+			alg, expected.Params().Name, curve.Params().Name)
+	}
+	return nil
+}
+
+func (k *JSONWebKey) requireSymmetricKey(alg string) error {
+	if _, ok := k.Key.([]byte); !ok {
+		return fmt.Errorf("square/go-jose: alg '%s' requires a symmetric key, got %T", alg, k.Key)
+	}
+	return nil
+}
+
+func (key rawJSONWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	if key.N == nil || key.E == nil {
+		return nil, fmt.Errorf("square/go-jose: invalid RSA key, missing n/e values")
+	}
+
+	return &rsa.PublicKey{
+		N: key.N.bigInt(),
+		E: key.E.toInt(),
+	}, nil
+}
+
+func fromEdPublicKey(pub ed25519.PublicKey) *rawJSONWebKey {
+	return &rawJSONWebKey{
+		Kty: "OKP",
+		Crv: "Ed25519",
+		X:   newBuffer(pub),
+	}
+}
+
+func fromX25519PublicKey(pub *ecdh.PublicKey) (*rawJSONWebKey, error) {
+	if pub.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("square/go-jose: unsupported curve for OKP key")
+	}
+	return &rawJSONWebKey{
+		Kty: "OKP",
+		Crv: "X25519",
+		X:   newBuffer(pub.Bytes()),
+	}, nil
+}
+
+func fromRsaPublicKey(pub *rsa.PublicKey) *rawJSONWebKey {
+	return &rawJSONWebKey{
+		Kty: "RSA",
+		N:   newBuffer(pub.N.Bytes()),
+		E:   newBufferFromInt(uint64(pub.E)),
+	}
+}
+
+func (key rawJSONWebKey) ecPublicKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch key.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	case "secp256k1":
+		curve = ellipticSecp256k1()
+	default:
+		return nil, fmt.Errorf("square/go-jose: unsupported elliptic curve '%s'", key.Crv)
+	}
+
+	if key.X == nil || key.Y == nil {
+		return nil, errors.New("square/go-jose: invalid EC key, missing x/y values")
+	}
+
+	// The length of this octet string MUST be the full size of a coordinate for
+	// the curve specified in the "crv" parameter.
+	// https://tools.ietf.org/html/rfc7518#section-6.2.1.2
+	if curveSize(curve) != len(key.X.data) {
+		return nil, fmt.Errorf("square/go-jose: invalid EC public key, wrong length for x")
+	}
+
+	if curveSize(curve) != len(key.Y.data) {
+		return nil, fmt.Errorf("square/go-jose: invalid EC public key, wrong length for y")
+	}
+
+	x := key.X.bigInt()
+	y := key.Y.bigInt()
+
+	if !curve.IsOnCurve(x, y) {
+		return nil, errors.New("square/go-jose: invalid EC key, X/Y are not on declared curve")
+	}
+
+	return &ecdsa.PublicKey{
+		Curve: curve,
+		X:     x,
+		Y:     y,
+	}, nil
+}
+
+func fromEcPublicKey(pub *ecdsa.PublicKey) (*rawJSONWebKey, error) {
+	if pub == nil || pub.X == nil || pub.Y == nil {
+		return nil, fmt.Errorf("square/go-jose: invalid EC key (nil, or X/Y missing)")
+	}
+
+	name, err := curveName(pub.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	size := curveSize(pub.Curve)
+
+	xBytes := pub.X.Bytes()
+	yBytes := pub.Y.Bytes()
+
+	if len(xBytes) > size || len(yBytes) > size {
+		return nil, fmt.Errorf("square/go-jose: invalid EC key (X/Y too large)")
+	}
+
+	key := &rawJSONWebKey{
+		Kty: "EC",
+		Crv: name,
+		X:   newFixedSizeBuffer(xBytes, size),
+		Y:   newFixedSizeBuffer(yBytes, size),
+	}
+
+	return key, nil
+}
+
+func (key rawJSONWebKey) edPrivateKey() (ed25519.PrivateKey, error) {
+	var missing []string
+	switch {
+	case key.D == nil:
+		missing = append(missing, "D")
+	case key.X == nil:
+		missing = append(missing, "X")
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("square/go-jose: invalid Ed25519 private key, missing %s value(s)", strings.Join(missing, ", "))
+	}
+
+	privateKey := make([]byte, ed25519.PrivateKeySize)
+	copy(privateKey[0:32], key.D.bytes())
+	copy(privateKey[32:], key.X.bytes())
+	rv := ed25519.PrivateKey(privateKey)
+	return rv, nil
+}
+
+func (key rawJSONWebKey) x25519PrivateKey() (*ecdh.PrivateKey, error) {
+	var missing []string
+	switch {
+	case key.D == nil:
+		missing = append(missing, "D")
+	case key.X == nil:
+		missing = append(missing, "X")
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("square/go-jose: invalid X25519 private key, missing %s value(s)", strings.Join(missing, ", "))
+	}
+
+	privateKey, err := ecdh.X25519().NewPrivateKey(key.D.bytes())
+	if err != nil {
+		return nil, fmt.Errorf("square/go-jose: invalid X25519 private key: %v", err)
+	}
+	return privateKey, nil
+}
+
+func (key rawJSONWebKey) x25519PublicKey() (*ecdh.PublicKey, error) {
+	if key.X == nil {
+		return nil, fmt.Errorf("square/go-jose: invalid X25519 key, missing x value")
+	}
+	publicKey, err := ecdh.X25519().NewPublicKey(key.X.bytes())
+	if err != nil {
+		return nil, fmt.Errorf("square/go-jose: invalid X25519 public key: %v", err)
+	}
+	return publicKey, nil
+}
+
+func (key rawJSONWebKey) edPublicKey() (ed25519.PublicKey, error) {
+	if key.X == nil {
+		return nil, fmt.Errorf("square/go-jose: invalid Ed key, missing x value")
+	}
+	publicKey := make([]byte, ed25519.PublicKeySize)
+	copy(publicKey[0:32], key.X.bytes())
+	rv := ed25519.PublicKey(publicKey)
+	return rv, nil
+}
+
+func (key rawJSONWebKey) rsaPrivateKey() (*rsa.PrivateKey, error) {
+	var missing []string
+	switch {
+	case key.N == nil:
+		missing = append(missing, "N")
+	case key.E == nil:
+		missing = append(missing, "E")
+	case key.D == nil:
+		missing = append(missing, "D")
+	case key.P == nil:
+		missing = append(missing, "P")
+	case key.Q == nil:
+		missing = append(missing, "Q")
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("square/go-jose: invalid RSA private key, missing %s value(s)", strings.Join(missing, ", "))
+	}
+
+	rv := &rsa.PrivateKey{
+		PublicKey: rsa.PublicKey{
+			N: key.N.bigInt(),
+			E: key.E.toInt(),
+		},
+		D: key.D.bigInt(),
+		Primes: []*big.Int{
+			key.P.bigInt(),
+			key.Q.bigInt(),
+		},
+	}
+
+	if key.Dp != nil {
+		rv.Precomputed.Dp = key.Dp.bigInt()
+	}
+	if key.Dq != nil {
+		rv.Precomputed.Dq = key.Dq.bigInt()
+	}
+	if key.Qi != nil {
+		rv.Precomputed.Qinv = key.Qi.bigInt()
+	}
+
+	err := rv.Validate()
+	return rv, err
+}
+
+func fromEdPrivateKey(ed ed25519.PrivateKey) (*rawJSONWebKey, error) {
+	raw := fromEdPublicKey(ed25519.PublicKey(ed[32:]))
+
+	raw.D = newBuffer(ed[0:32])
+	return raw, nil
+}
+
+func fromX25519PrivateKey(key *ecdh.PrivateKey) (*rawJSONWebKey, error) {
+	raw, err := fromX25519PublicKey(key.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+
+	raw.D = newBuffer(key.Bytes())
+	return raw, nil
+}
+
+func fromRsaPrivateKey(rsa *rsa.PrivateKey) (*rawJSONWebKey, error) {
+	if len(rsa.Primes) != 2 {
+		return nil, ErrUnsupportedKeyType
+	}
+
+	raw := fromRsaPublicKey(&rsa.PublicKey)
+
+	raw.D = newBuffer(rsa.D.Bytes())
+	raw.P = newBuffer(rsa.Primes[0].Bytes())
+	raw.Q = newBuffer(rsa.Primes[1].Bytes())
+
+	if rsa.Precomputed.Dp != nil {
+		raw.Dp = newBuffer(rsa.Precomputed.Dp.Bytes())
+	}
+	if rsa.Precomputed.Dq != nil {
+		raw.Dq = newBuffer(rsa.Precomputed.Dq.Bytes())
+	}
+	if rsa.Precomputed.Qinv != nil {
+		raw.Qi = newBuffer(rsa.Precomputed.Qinv.Bytes())
+	}
+
+	return raw, nil
+}
+
+func (key rawJSONWebKey) ecPrivateKey() (*ecdsa.PrivateKey, error) {
+	var curve elliptic.Curve
+	switch key.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	case "secp256k1":
+		curve = ellipticSecp256k1()
+	default:
+		return nil, fmt.Errorf("square/go-jose: unsupported elliptic curve '%s'", key.Crv)
+	}
+
+	if key.X == nil || key.Y == nil || key.D == nil {
+		return nil, fmt.Errorf("square/go-jose: invalid EC private key, missing x/y/d values")
+	}
+
+	// The length of this octet string MUST be the full size of a coordinate for
+	// the curve specified in the "crv" parameter.
+	// https://tools.ietf.org/html/rfc7518#section-6.2.1.2
+	if curveSize(curve) != len(key.X.data) {
+		return nil, fmt.Errorf("square/go-jose: invalid EC private key, wrong length for x")
+	}
+
+	if curveSize(curve) != len(key.Y.data) {
+		return nil, fmt.Errorf("square/go-jose: invalid EC private key, wrong length for y")
+	}
+
+	// https://tools.ietf.org/html/rfc7518#section-6.2.2.1
+	if dSize(curve) != len(key.D.data) {
+		return nil, fmt.Errorf("square/go-jose: invalid EC private key, wrong length for d")
+	}
+
+	x := key.X.bigInt()
+	y := key.Y.bigInt()
+
+	if !curve.IsOnCurve(x, y) {
+		return nil, errors.New("square/go-jose: invalid EC key, X/Y are not on declared curve")
+	}
+
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: curve,
+			X:     x,
+			Y:     y,
+		},
+		D: key.D.bigInt(),
+	}, nil
+}
+
+func fromEcPrivateKey(ec *ecdsa.PrivateKey) (*rawJSONWebKey, error) {
+	raw, err := fromEcPublicKey(&ec.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if ec.D == nil {
+		return nil, fmt.Errorf("square/go-jose: invalid EC private key")
+	}
+
+	raw.D = newFixedSizeBuffer(ec.D.Bytes(), dSize(ec.PublicKey.Curve))
+
+	return raw, nil
+}
+
+// FromPEM parses a PEM-encoded key and stores it in the JSONWebKey. PKCS#1
+// RSA private keys, SEC 1 EC private keys, PKCS#8 private keys and PKIX or
+// PKCS#1 public keys are supported. Only the key material is taken from the
+// PEM data; fields like KeyID, Use and Algorithm are left untouched so they
+// can be set by the caller.
+func (k *JSONWebKey) FromPEM(data []byte) error {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return errors.New("square/go-jose: no PEM block found in key data")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return fmt.Errorf("square/go-jose: unsupported PEM block type '%s'", block.Type)
+	}
+	if err != nil {
+		return err
+	}
+
+	k.Key = key
+	return nil
+}
+
+// ToPEM encodes the key as PEM. Private RSA and EC keys are written as
+// PKCS#8 blocks, public keys as PKIX blocks. The conversion is lossy: KeyID,
+// Use, Algorithm and the certificate fields have no PEM representation and
+// are dropped, so callers that round-trip through PEM must carry those
+// fields separately.
+func (k JSONWebKey) ToPEM() ([]byte, error) {
+	switch key := k.Key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+	default:
+		return nil, ErrUnsupportedKeyType
+	}
+}
+
+// dSize returns the size in octets for the "d" member of an elliptic curve
+// private key.
+// The length of this octet string MUST be ceiling(log-base-2(n)/8)
+// octets (where n is the order of the curve).
+// https://tools.ietf.org/html/rfc7518#section-6.2.2.1
+func dSize(curve elliptic.Curve) int {
+	order := curve.Params().P
+	bitLen := order.BitLen()
+	size := bitLen / 8
+	if bitLen%8 != 0 {
+		size = size + 1
+	}
+	return size
+}
+
+func fromSymmetricKey(key []byte) (*rawJSONWebKey, error) {
+	return &rawJSONWebKey{
+		Kty: "oct",
+		K:   newBuffer(key),
+	}, nil
+}
+
+func (key rawJSONWebKey) symmetricKey() ([]byte, error) {
+	if key.K == nil {
+		return nil, fmt.Errorf("square/go-jose: invalid OCT (symmetric) key, missing k value")
+	}
+	return key.K.bytes(), nil
+}
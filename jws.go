@@ -0,0 +1,524 @@
+/*-
+ * Copyright 2014 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jose
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/square/go-jose.v2/json"
+)
+
+// rawJSONWebSignature represents a raw JWS JSON object. Used for parsing/serializing.
+type rawJSONWebSignature struct {
+	Payload    *byteBuffer        `json:"payload,omitempty"`
+	Signatures []rawSignatureInfo `json:"signatures,omitempty"`
+	Protected  *byteBuffer        `json:"protected,omitempty"`
+	Header     *rawHeader         `json:"header,omitempty"`
+	Signature  *byteBuffer        `json:"signature,omitempty"`
+}
+
+// rawSignatureInfo represents a single JWS signature over the JWS payload and protected header.
+type rawSignatureInfo struct {
+	Protected *byteBuffer `json:"protected,omitempty"`
+	Header    *rawHeader  `json:"header,omitempty"`
+	Signature *byteBuffer `json:"signature,omitempty"`
+}
+
+// JSONWebSignature represents a signed JWS object after parsing.
+type JSONWebSignature struct {
+	payload []byte
+	// Signatures attached to this object (may be more than one for multi-sig).
+	// Be careful about accessing these directly, prefer to use Verify() or
+	// VerifyMulti() to ensure that the data you're getting is verified.
+	Signatures []Signature
+
+	minimumRSAKeySize          int
+	understoodCritical         []string
+	allowedSignatureAlgorithms []SignatureAlgorithm
+	allowUnsignedTokens        bool
+	pssSaltLength              int
+	expectedType               string
+}
+
+// signatureAlgorithmAllowed reports whether alg is on the verify allowlist;
+// an empty allowlist allows every algorithm.
+func (obj JSONWebSignature) signatureAlgorithmAllowed(alg SignatureAlgorithm) bool {
+	if len(obj.allowedSignatureAlgorithms) == 0 {
+		return true
+	}
+	for _, allowed := range obj.allowedSignatureAlgorithms {
+		if allowed == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// Signature represents a single signature over the JWS payload and protected header.
+type Signature struct {
+	// Merged header fields. Contains both protected and unprotected header
+	// values. Prefer using Protected and Unprotected fields instead of this.
+	// Values in this header may or may not have been signed and in general
+	// should not be trusted.
+	Header Header
+
+	// Protected header. Values in this header were signed and
+	// will be verified as part of the signature verification process.
+	Protected Header
+
+	// Unprotected header. Values in this header were not signed
+	// and in general should not be trusted.
+	Unprotected Header
+
+	// The actual signature value
+	Signature []byte
+
+	protected *rawHeader
+	header    *rawHeader
+	original  *rawSignatureInfo
+}
+
+// ParseSigned parses a signed message in compact or full serialization format.
+func ParseSigned(signature string) (*JSONWebSignature, error) {
+	signature = stripWhitespace(signature)
+	if strings.HasPrefix(signature, "{") {
+		return parseSignedFull(signature)
+	}
+
+	return parseSignedCompact(signature, nil)
+}
+
+// ParseSignedJSON parses a signed message in the full JSON serialization
+// format only, rejecting compact input with a descriptive error.
+func ParseSignedJSON(signature string) (*JSONWebSignature, error) {
+	signature = stripWhitespace(signature)
+	if !strings.HasPrefix(signature, "{") {
+		return nil, fmt.Errorf("square/go-jose: expected JWS in JSON serialization, got compact form")
+	}
+
+	return parseSignedFull(signature)
+}
+
+// ParseSignedCompact parses a signed message in the compact serialization
+// format only, rejecting JSON input with a descriptive error.
+func ParseSignedCompact(signature string) (*JSONWebSignature, error) {
+	signature = stripWhitespace(signature)
+	if strings.HasPrefix(signature, "{") {
+		return nil, fmt.Errorf("square/go-jose: expected JWS in compact serialization, got JSON form")
+	}
+
+	return parseSignedCompact(signature, nil)
+}
+
+// FirstSignature returns the first signature on the object, with its typed
+// headers, or an error when the object carries none. It is a convenience for
+// inspecting headers such as the key ID before picking a verification key;
+// the returned signature has not been verified, use Verify or VerifyMulti
+// before trusting the payload.
+func (obj JSONWebSignature) FirstSignature() (Signature, error) {
+	if len(obj.Signatures) == 0 {
+		return Signature{}, errors.New("square/go-jose: no signatures attached to object")
+	}
+	return obj.Signatures[0], nil
+}
+
+// ParseSignedWithOptions parses a signed message in compact or full
+// serialization format. Options set here, such as a minimum RSA key size,
+// apply to every Verify call on the returned object.
+func ParseSignedWithOptions(signature string, opts *VerifierOptions) (*JSONWebSignature, error) {
+	obj, err := ParseSigned(signature)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts != nil {
+		obj.minimumRSAKeySize = opts.MinimumRSAKeySize
+		obj.understoodCritical = opts.UnderstoodCritical
+		obj.allowedSignatureAlgorithms = opts.AllowedSignatureAlgorithms
+		obj.allowUnsignedTokens = opts.AllowUnsignedTokens
+		obj.pssSaltLength = opts.PSSSaltLength
+		obj.expectedType = opts.ExpectedType
+	}
+
+	return obj, nil
+}
+
+// ParseDetached parses a signed message in compact serialization format with
+// detached payload. The payload must be supplied exactly as it was signed: if
+// the protected header contains "b64":false the raw payload bytes are used as
+// signing input, otherwise the base64url-encoded form is.
+func ParseDetached(signature string, payload []byte) (*JSONWebSignature, error) {
+	if payload == nil {
+		return nil, errors.New("square/go-jose: nil payload")
+	}
+	return parseSignedCompact(stripWhitespace(signature), payload)
+}
+
+// Get a header value
+func (sig Signature) mergedHeaders() rawHeader {
+	out := rawHeader{}
+	out.merge(sig.protected)
+	out.merge(sig.header)
+	return out
+}
+
+// Compute data to be signed
+func (obj JSONWebSignature) computeAuthData(payload []byte, signature *Signature) ([]byte, error) {
+	var authData bytes.Buffer
+
+	protectedHeader := new(rawHeader)
+
+	if signature.original != nil && signature.original.Protected != nil {
+		if err := json.Unmarshal(signature.original.Protected.bytes(), protectedHeader); err != nil {
+			return nil, err
+		}
+		authData.WriteString(signature.original.Protected.base64())
+	} else if signature.protected != nil {
+		protectedHeader = signature.protected
+		authData.WriteString(base64.RawURLEncoding.EncodeToString(mustSerializeJSON(protectedHeader)))
+	}
+
+	needsBase64 := true
+
+	if protectedHeader != nil {
+		var err error
+		if needsBase64, err = protectedHeader.getB64(); err != nil {
+			needsBase64 = true
+		}
+	}
+
+	authData.WriteByte('.')
+
+	if needsBase64 {
+		authData.WriteString(base64.RawURLEncoding.EncodeToString(payload))
+	} else {
+		authData.Write(payload)
+	}
+
+	return authData.Bytes(), nil
+}
+
+// parseSignedFull parses a message in full format.
+func parseSignedFull(input string) (*JSONWebSignature, error) {
+	var parsed rawJSONWebSignature
+	err := json.Unmarshal([]byte(input), &parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsed.sanitized()
+}
+
+// sanitized produces a cleaned-up JWS object from the raw JSON.
+func (parsed *rawJSONWebSignature) sanitized() (*JSONWebSignature, error) {
+	if parsed.Payload == nil {
+		return nil, fmt.Errorf("square/go-jose: missing payload in JWS message")
+	}
+
+	obj := &JSONWebSignature{
+		payload:    parsed.Payload.bytes(),
+		Signatures: make([]Signature, len(parsed.Signatures)),
+	}
+
+	if len(parsed.Signatures) == 0 {
+		// No signatures array, must be flattened serialization
+		signature := Signature{}
+		if parsed.Protected != nil && len(parsed.Protected.bytes()) > 0 {
+			signature.protected = &rawHeader{}
+			err := json.Unmarshal(parsed.Protected.bytes(), signature.protected)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Check that there is not a nonce in the unprotected header
+		if parsed.Header != nil && parsed.Header.getNonce() != "" {
+			return nil, ErrUnprotectedNonce
+		}
+
+		signature.header = parsed.Header
+		signature.Signature = parsed.Signature.bytes()
+		// Make a fake "original" rawSignatureInfo to store the unprocessed
+		// Protected header. This is necessary because the Protected header can
+		// contain arbitrary fields not registered as part of the spec. See
+		// https://tools.ietf.org/html/draft-ietf-jose-json-web-signature-41#section-4
+		// If we unmarshal Protected into a rawHeader with its explicit list of fields,
+		// we cannot marshal losslessly. So we have to keep around the original bytes.
+		// This is used in computeAuthData, which will first attempt to use
+		// the original bytes of a protected header, and fall back on marshaling the
+		// header struct only if those bytes are not available.
+		signature.original = &rawSignatureInfo{
+			Protected: parsed.Protected,
+			Header:    parsed.Header,
+			Signature: parsed.Signature,
+		}
+
+		var err error
+		signature.Header, err = signature.mergedHeaders().sanitized()
+		if err != nil {
+			return nil, err
+		}
+
+		if signature.header != nil {
+			signature.Unprotected, err = signature.header.sanitized()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if signature.protected != nil {
+			signature.Protected, err = signature.protected.sanitized()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// As per RFC 7515 Section 4.1.3, only public keys are allowed to be embedded.
+		jwk := signature.Header.JSONWebKey
+		if jwk != nil && (!jwk.Valid() || !jwk.IsPublic()) {
+			return nil, errors.New("square/go-jose: invalid embedded jwk, must be public key")
+		}
+
+		obj.Signatures = append(obj.Signatures, signature)
+	}
+
+	for i, sig := range parsed.Signatures {
+		if sig.Protected != nil && len(sig.Protected.bytes()) > 0 {
+			obj.Signatures[i].protected = &rawHeader{}
+			err := json.Unmarshal(sig.Protected.bytes(), obj.Signatures[i].protected)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Check that there is not a nonce in the unprotected header
+		if sig.Header != nil && sig.Header.getNonce() != "" {
+			return nil, ErrUnprotectedNonce
+		}
+
+		var err error
+		obj.Signatures[i].Header, err = obj.Signatures[i].mergedHeaders().sanitized()
+		if err != nil {
+			return nil, err
+		}
+
+		if obj.Signatures[i].header != nil {
+			obj.Signatures[i].Unprotected, err = obj.Signatures[i].header.sanitized()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if obj.Signatures[i].protected != nil {
+			obj.Signatures[i].Protected, err = obj.Signatures[i].protected.sanitized()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		obj.Signatures[i].Signature = sig.Signature.bytes()
+
+		// As per RFC 7515 Section 4.1.3, only public keys are allowed to be embedded.
+		jwk := obj.Signatures[i].Header.JSONWebKey
+		if jwk != nil && (!jwk.Valid() || !jwk.IsPublic()) {
+			return nil, errors.New("square/go-jose: invalid embedded jwk, must be public key")
+		}
+
+		// Copy value of sig
+		original := sig
+
+		obj.Signatures[i].header = sig.Header
+		obj.Signatures[i].original = &original
+	}
+
+	return obj, nil
+}
+
+// parseSignedCompact parses a message in compact format.
+func parseSignedCompact(input string, payload []byte) (*JSONWebSignature, error) {
+	parts := strings.Split(input, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("square/go-jose: compact JWS format must have three parts, got %d", len(parts))
+	}
+
+	if parts[1] != "" && payload != nil {
+		return nil, fmt.Errorf("square/go-jose: payload is not detached")
+	}
+
+	rawProtected, err := base64URLDecode(parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if payload == nil {
+		// The payload segment is only base64url-encoded when the protected
+		// header does not carry "b64":false (RFC 7797).
+		var protected rawHeader
+		if err := json.Unmarshal(rawProtected, &protected); err != nil {
+			return nil, err
+		}
+		b64, err := protected.getB64()
+		if err != nil {
+			return nil, err
+		}
+
+		if b64 {
+			payload, err = base64URLDecode(parts[1])
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			payload = []byte(parts[1])
+		}
+	}
+
+	signature, err := base64URLDecode(parts[2])
+	if err != nil {
+		return nil, err
+	}
+
+	raw := &rawJSONWebSignature{
+		Payload:   newBuffer(payload),
+		Protected: newBuffer(rawProtected),
+		Signature: newBuffer(signature),
+	}
+	return raw.sanitized()
+}
+
+func (obj JSONWebSignature) compactSerialize(detached bool) (string, error) {
+	if len(obj.Signatures) != 1 || obj.Signatures[0].header != nil || obj.Signatures[0].protected == nil {
+		return "", ErrNotCompactSerializable
+	}
+
+	serializedProtected := base64.RawURLEncoding.EncodeToString(mustSerializeJSON(obj.Signatures[0].protected))
+	payload := ""
+	signature := base64.RawURLEncoding.EncodeToString(obj.Signatures[0].Signature)
+
+	if !detached {
+		b64, err := obj.Signatures[0].protected.getB64()
+		if err != nil {
+			return "", err
+		}
+
+		if b64 {
+			payload = base64.RawURLEncoding.EncodeToString(obj.payload)
+		} else {
+			// An unencoded payload travels raw in the payload segment, so it
+			// must not contain the period separator (RFC 7797, section 5.2).
+			if bytes.ContainsRune(obj.payload, '.') {
+				return "", errors.New("square/go-jose: unencoded payload must not contain the period character")
+			}
+			payload = string(obj.payload)
+		}
+	}
+
+	return fmt.Sprintf("%s.%s.%s", serializedProtected, payload, signature), nil
+}
+
+// CompactSerialize serializes an object using the compact serialization format.
+func (obj JSONWebSignature) CompactSerialize() (string, error) {
+	return obj.compactSerialize(false)
+}
+
+// DetachedCompactSerialize serializes an object using the compact
+// serialization format with detached payload, leaving the payload segment
+// empty as described in RFC 7515, Appendix F. This also composes with the
+// unencoded payload option from RFC 7797: when signing with a "b64":false
+// header the raw payload bytes are signed, and the detached form is the
+// safe way to transport payloads that contain a period character.
+func (obj JSONWebSignature) DetachedCompactSerialize() (string, error) {
+	return obj.compactSerialize(true)
+}
+
+// CompactSerializeTo writes the compact serialization of the object to the
+// given writer and returns the number of bytes written. It produces the same
+// bytes as CompactSerialize without building the whole token in memory first,
+// which saves a copy when writing tokens straight into an HTTP response.
+func (obj JSONWebSignature) CompactSerializeTo(w io.Writer) (int, error) {
+	if len(obj.Signatures) != 1 || obj.Signatures[0].header != nil || obj.Signatures[0].protected == nil {
+		return 0, ErrNotCompactSerializable
+	}
+
+	b64, err := obj.Signatures[0].protected.getB64()
+	if err != nil {
+		return 0, err
+	}
+	if !b64 && bytes.ContainsRune(obj.payload, '.') {
+		return 0, errors.New("square/go-jose: unencoded payload must not contain the period character")
+	}
+
+	cw := &countingWriter{w: w}
+	if err := writeCompactSegments(cw, mustSerializeJSON(obj.Signatures[0].protected)); err != nil {
+		return cw.n, err
+	}
+	if _, err := cw.Write([]byte{'.'}); err != nil {
+		return cw.n, err
+	}
+	if b64 {
+		if err := writeCompactSegments(cw, obj.payload); err != nil {
+			return cw.n, err
+		}
+	} else {
+		// An unencoded payload travels raw in the payload segment (RFC 7797).
+		if _, err := cw.Write(obj.payload); err != nil {
+			return cw.n, err
+		}
+	}
+	if _, err := cw.Write([]byte{'.'}); err != nil {
+		return cw.n, err
+	}
+	if err := writeCompactSegments(cw, obj.Signatures[0].Signature); err != nil {
+		return cw.n, err
+	}
+
+	return cw.n, nil
+}
+
+// FullSerialize serializes an object using the full JSON serialization format.
+func (obj JSONWebSignature) FullSerialize() string {
+	raw := rawJSONWebSignature{
+		Payload: newBuffer(obj.payload),
+	}
+
+	if len(obj.Signatures) == 1 {
+		if obj.Signatures[0].protected != nil {
+			serializedProtected := mustSerializeJSON(obj.Signatures[0].protected)
+			raw.Protected = newBuffer(serializedProtected)
+		}
+		raw.Header = obj.Signatures[0].header
+		raw.Signature = newBuffer(obj.Signatures[0].Signature)
+	} else {
+		raw.Signatures = make([]rawSignatureInfo, len(obj.Signatures))
+		for i, signature := range obj.Signatures {
+			raw.Signatures[i] = rawSignatureInfo{
+				Header:    signature.header,
+				Signature: newBuffer(signature.Signature),
+			}
+
+			if signature.protected != nil {
+				raw.Signatures[i].Protected = newBuffer(mustSerializeJSON(signature.protected))
+			}
+		}
+	}
+
+	return string(mustSerializeJSON(raw))
+}
@@ -0,0 +1,84 @@
+/*-
+ * Copyright 2019 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jose
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SignedAndEncrypted signs the payload with the given signing key, then
+// encrypts the compact JWS to the recipient with the "cty" header set to
+// "JWT", producing a nested object in compact serialization. For tokens with
+// claims, prefer the builder in the jwt package; this helper works on raw
+// payloads.
+func SignedAndEncrypted(payload []byte, sig SigningKey, enc ContentEncryption, rcpt Recipient) (string, error) {
+	signer, err := NewSigner(sig, nil)
+	if err != nil {
+		return "", err
+	}
+
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+
+	msg, err := signed.CompactSerialize()
+	if err != nil {
+		return "", err
+	}
+
+	encrypter, err := NewEncrypter(enc, rcpt, (&EncrypterOptions{}).WithContentType("JWT"))
+	if err != nil {
+		return "", err
+	}
+
+	encrypted, err := encrypter.Encrypt([]byte(msg))
+	if err != nil {
+		return "", err
+	}
+
+	return encrypted.CompactSerialize()
+}
+
+// DecryptAndVerify decrypts a nested object produced by SignedAndEncrypted
+// and verifies the signature on the inner JWS, returning the payload. The
+// "cty" header must be "JWT"; without it the plaintext is not treated as a
+// nested JWS.
+func DecryptAndVerify(input string, decryptionKey interface{}, verificationKey interface{}) ([]byte, error) {
+	obj, err := ParseEncrypted(input)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := obj.Header.ContentType
+	if strings.ToUpper(contentType) != "JWT" {
+		return nil, fmt.Errorf("square/go-jose: expected content type JWT (cty header), found '%s'", contentType)
+	}
+
+	plaintext, err := obj.Decrypt(decryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	signed, err := ParseSigned(string(plaintext))
+	if err != nil {
+		return nil, err
+	}
+
+	return signed.Verify(verificationKey)
+}
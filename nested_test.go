@@ -0,0 +1,85 @@
+/*-
+ * Copyright 2019 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jose
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSignedAndEncryptedRoundtrip(t *testing.T) {
+	input := []byte("Lorem ipsum dolor sit amet")
+
+	msg, err := SignedAndEncrypted(input,
+		SigningKey{Algorithm: ES256, Key: ecTestKey256},
+		A128GCM,
+		Recipient{Algorithm: RSA_OAEP_256, Key: &rsaTestKey.PublicKey})
+	if err != nil {
+		t.Fatal("error on sign and encrypt:", err)
+	}
+
+	output, err := DecryptAndVerify(msg, rsaTestKey, ecTestKey256.Public())
+	if err != nil {
+		t.Fatal("error on decrypt and verify:", err)
+	}
+	if string(output) != string(input) {
+		t.Error("output does not match input, got", string(output))
+	}
+
+	// Verification with the wrong key must fail.
+	if _, err = DecryptAndVerify(msg, rsaTestKey, ecTestKey384.Public()); err == nil {
+		t.Error("verification succeeded with the wrong key")
+	}
+}
+
+func TestDecryptAndVerifyMissingContentType(t *testing.T) {
+	// A JWE wrapping a JWS but lacking the cty header must be rejected
+	// before the plaintext is treated as a nested JWS.
+	signer, err := NewSigner(SigningKey{Algorithm: ES256, Key: ecTestKey256}, nil)
+	if err != nil {
+		t.Fatal("error on new signer:", err)
+	}
+	signed, err := signer.Sign([]byte("Lorem ipsum dolor sit amet"))
+	if err != nil {
+		t.Fatal("error on sign:", err)
+	}
+	inner, err := signed.CompactSerialize()
+	if err != nil {
+		t.Fatal("error on serialize:", err)
+	}
+
+	enc, err := NewEncrypter(A128GCM, Recipient{Algorithm: RSA_OAEP_256, Key: &rsaTestKey.PublicKey}, nil)
+	if err != nil {
+		t.Fatal("error on new encrypter:", err)
+	}
+	encrypted, err := enc.Encrypt([]byte(inner))
+	if err != nil {
+		t.Fatal("error on encrypt:", err)
+	}
+	msg, err := encrypted.CompactSerialize()
+	if err != nil {
+		t.Fatal("error on serialize:", err)
+	}
+
+	_, err = DecryptAndVerify(msg, rsaTestKey, ecTestKey256.Public())
+	if err == nil {
+		t.Fatal("should reject nested object without cty header")
+	}
+	if !strings.Contains(err.Error(), "content type") {
+		t.Error("unexpected error for missing cty header:", err)
+	}
+}
@@ -0,0 +1,168 @@
+/*-
+ * Copyright 2019 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jose
+
+import (
+	"crypto/elliptic"
+	"math/big"
+	"sync"
+)
+
+// secp256k1Curve implements the SEC 2 curve secp256k1 used by the ES256K
+// signature algorithm. The curve is implemented here rather than through a
+// dependency: the standard library cannot host it because the generic
+// elliptic.CurveParams group law hard-codes the a = -3 coefficient shared by
+// the NIST curves, while secp256k1 has a = 0, and pulling in an external
+// bitcoin-oriented module for a single curve is not worth the supply-chain
+// surface. The affine math/big arithmetic below is not constant-time, which
+// matches the standard library's own behaviour for non-NIST curves passed to
+// crypto/ecdsa.
+type secp256k1Curve struct {
+	params *elliptic.CurveParams
+}
+
+var (
+	secp256k1Init sync.Once
+	secp256k1     *secp256k1Curve
+)
+
+func initSecp256k1() {
+	fromHex := func(s string) *big.Int {
+		value, ok := new(big.Int).SetString(s, 16)
+		if !ok {
+			panic("square/go-jose: invalid secp256k1 parameter")
+		}
+		return value
+	}
+
+	// Domain parameters from SEC 2, version 2.0, section 2.4.1.
+	secp256k1 = &secp256k1Curve{
+		params: &elliptic.CurveParams{
+			Name:    "secp256k1",
+			P:       fromHex("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC2F"),
+			N:       fromHex("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEBAAEDCE6AF48A03BBFD25E8CD0364141"),
+			B:       fromHex("07"),
+			Gx:      fromHex("79BE667EF9DCBBAC55A06295CE870B07029BFCDB2DCE28D959F2815B16F81798"),
+			Gy:      fromHex("483ADA7726A3C4655DA4FBFC0E1108A8FD17B448A68554199C47D08FFB10D4B8"),
+			BitSize: 256,
+		},
+	}
+}
+
+// ellipticSecp256k1 returns the secp256k1 curve.
+func ellipticSecp256k1() elliptic.Curve {
+	secp256k1Init.Do(initSecp256k1)
+	return secp256k1
+}
+
+func (c *secp256k1Curve) Params() *elliptic.CurveParams {
+	return c.params
+}
+
+func (c *secp256k1Curve) IsOnCurve(x, y *big.Int) bool {
+	if x.Sign() < 0 || x.Cmp(c.params.P) >= 0 || y.Sign() < 0 || y.Cmp(c.params.P) >= 0 {
+		return false
+	}
+
+	// y² = x³ + b
+	y2 := new(big.Int).Mul(y, y)
+	y2.Mod(y2, c.params.P)
+
+	x3 := new(big.Int).Mul(x, x)
+	x3.Mul(x3, x)
+	x3.Add(x3, c.params.B)
+	x3.Mod(x3, c.params.P)
+
+	return y2.Cmp(x3) == 0
+}
+
+// The point at infinity is represented as (0, 0), following the convention
+// of the standard library's generic curve implementation.
+func (c *secp256k1Curve) Add(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	if x1.Sign() == 0 && y1.Sign() == 0 {
+		return new(big.Int).Set(x2), new(big.Int).Set(y2)
+	}
+	if x2.Sign() == 0 && y2.Sign() == 0 {
+		return new(big.Int).Set(x1), new(big.Int).Set(y1)
+	}
+	if x1.Cmp(x2) == 0 {
+		if y1.Cmp(y2) == 0 {
+			return c.Double(x1, y1)
+		}
+		// The points are inverses, the sum is the point at infinity.
+		return new(big.Int), new(big.Int)
+	}
+
+	// λ = (y2 - y1) / (x2 - x1)
+	lambda := new(big.Int).Sub(y2, y1)
+	den := new(big.Int).Sub(x2, x1)
+	den.ModInverse(den, c.params.P)
+	lambda.Mul(lambda, den)
+	lambda.Mod(lambda, c.params.P)
+
+	return c.completeSlope(lambda, x1, y1, x2)
+}
+
+func (c *secp256k1Curve) Double(x1, y1 *big.Int) (*big.Int, *big.Int) {
+	if y1.Sign() == 0 {
+		return new(big.Int), new(big.Int)
+	}
+
+	// λ = 3x1² / 2y1, with the a coefficient dropping out since it is zero.
+	lambda := new(big.Int).Mul(x1, x1)
+	lambda.Mul(lambda, big.NewInt(3))
+	den := new(big.Int).Lsh(y1, 1)
+	den.ModInverse(den, c.params.P)
+	lambda.Mul(lambda, den)
+	lambda.Mod(lambda, c.params.P)
+
+	return c.completeSlope(lambda, x1, y1, x1)
+}
+
+// completeSlope computes x3 = λ² - x1 - x2 and y3 = λ(x1 - x3) - y1, the
+// second half of both the addition and doubling formulas.
+func (c *secp256k1Curve) completeSlope(lambda, x1, y1, x2 *big.Int) (*big.Int, *big.Int) {
+	x3 := new(big.Int).Mul(lambda, lambda)
+	x3.Sub(x3, x1)
+	x3.Sub(x3, x2)
+	x3.Mod(x3, c.params.P)
+
+	y3 := new(big.Int).Sub(x1, x3)
+	y3.Mul(y3, lambda)
+	y3.Sub(y3, y1)
+	y3.Mod(y3, c.params.P)
+
+	return x3, y3
+}
+
+func (c *secp256k1Curve) ScalarMult(x, y *big.Int, k []byte) (*big.Int, *big.Int) {
+	rx, ry := new(big.Int), new(big.Int)
+	for _, b := range k {
+		for bit := 0; bit < 8; bit++ {
+			rx, ry = c.Double(rx, ry)
+			if b&0x80 != 0 {
+				rx, ry = c.Add(rx, ry, x, y)
+			}
+			b <<= 1
+		}
+	}
+	return rx, ry
+}
+
+func (c *secp256k1Curve) ScalarBaseMult(k []byte) (*big.Int, *big.Int) {
+	return c.ScalarMult(c.params.Gx, c.params.Gy, k)
+}
@@ -0,0 +1,192 @@
+/*-
+ * Copyright 2019 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jose
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func secp256k1TestKey() *ecdsa.PrivateKey {
+	curve := ellipticSecp256k1()
+	d, _ := new(big.Int).SetString("EBB2C082FD7727890A28AC82F6BDF97BAD8DE9F5D7C9028692DE1A255CAD3E0F", 16)
+	x, y := curve.ScalarBaseMult(d.Bytes())
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y},
+		D:         d,
+	}
+}
+
+func TestSecp256k1KnownAnswer(t *testing.T) {
+	// Scalar multiplication of the base point by a known private key must
+	// yield the matching well-known public key.
+	key := secp256k1TestKey()
+
+	expectedX, _ := new(big.Int).SetString("779DD197A5DF977ED2CF6CB31D82D43328B790DC6B3B7D4437A427BD5847DFCD", 16)
+	expectedY, _ := new(big.Int).SetString("E94B724A555B6D017BB7607C3E3281DAF5B1699D6EF4124975C9237B917D426F", 16)
+
+	if key.X.Cmp(expectedX) != 0 || key.Y.Cmp(expectedY) != 0 {
+		t.Errorf("scalar base mult mismatch, got (%X, %X)", key.X, key.Y)
+	}
+	if !key.Curve.IsOnCurve(key.X, key.Y) {
+		t.Error("public key is not on the curve")
+	}
+}
+
+func TestES256KVerifyKnownAnswer(t *testing.T) {
+	// Signature produced with the secp256k1TestKey private key.
+	const msg = "eyJhbGciOiJFUzI1NksifQ.TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQ." +
+		"IE60aKpScKMFllLBO8F3YVIr4xmMRQO18pqTbb6-ihjyP4QDzxAMy0CGlcP5ZNAT6fVJcNkD433hbKE3YShNlA"
+
+	obj, err := ParseSigned(msg)
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+
+	payload, err := obj.Verify(secp256k1TestKey().Public())
+	if err != nil {
+		t.Fatal("error on verify:", err)
+	}
+	if string(payload) != "Lorem ipsum dolor sit amet" {
+		t.Error("payload is not what we expected, got", string(payload))
+	}
+
+	// A corrupted signature must be rejected.
+	corrupt, err := ParseSigned(msg[:len(msg)-4] + "AAAA")
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+	if _, err = corrupt.Verify(secp256k1TestKey().Public()); err == nil {
+		t.Error("corrupt signature was accepted")
+	}
+}
+
+func TestES256KRoundtrip(t *testing.T) {
+	key := secp256k1TestKey()
+
+	signer, err := NewSigner(SigningKey{Algorithm: ES256K, Key: key}, nil)
+	if err != nil {
+		t.Fatal("error on new signer:", err)
+	}
+
+	input := []byte("Lorem ipsum dolor sit amet")
+	signed, err := signer.Sign(input)
+	if err != nil {
+		t.Fatal("error on sign:", err)
+	}
+	msg, err := signed.CompactSerialize()
+	if err != nil {
+		t.Fatal("error on serialize:", err)
+	}
+
+	obj, err := ParseSigned(msg)
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+	if alg := obj.Signatures[0].Header.Algorithm; alg != string(ES256K) {
+		t.Errorf("expected alg header ES256K, got '%s'", alg)
+	}
+	if len(obj.Signatures[0].Signature) != 64 {
+		t.Errorf("expected 64 byte signature, got %d bytes", len(obj.Signatures[0].Signature))
+	}
+
+	output, err := obj.Verify(key.Public())
+	if err != nil {
+		t.Fatal("error on verify:", err)
+	}
+	if string(output) != string(input) {
+		t.Error("input/output do not match")
+	}
+
+	// Verification with a key on a different curve must fail.
+	if _, err = obj.Verify(ecTestKey256.Public()); err == nil {
+		t.Error("verification succeeded with the wrong key")
+	}
+}
+
+func TestJWKSecp256k1RoundTrip(t *testing.T) {
+	jwk := JSONWebKey{Key: secp256k1TestKey(), KeyID: "secp256k1-key", Algorithm: string(ES256K)}
+
+	serialized, err := json.Marshal(jwk)
+	if err != nil {
+		t.Fatal("error on marshal:", err)
+	}
+	if !strings.Contains(string(serialized), `"crv":"secp256k1"`) {
+		t.Error("serialized key does not contain crv secp256k1:", string(serialized))
+	}
+
+	var parsed JSONWebKey
+	if err := json.Unmarshal(serialized, &parsed); err != nil {
+		t.Fatal("error on unmarshal:", err)
+	}
+	if !reflect.DeepEqual(jwk.Key, parsed.Key) {
+		t.Error("parsed key does not match original")
+	}
+	if !parsed.Valid() {
+		t.Error("parsed key is not valid")
+	}
+}
+
+func TestES256KCurveConfusionRejected(t *testing.T) {
+	// P-256 and secp256k1 are both 256-bit curves, so the algorithm/key
+	// pairing must be checked by curve identity, not key size.
+	k1Key := secp256k1TestKey()
+
+	// ES256K refuses a P-256 key on sign...
+	if wrongSigner, err := NewSigner(SigningKey{Algorithm: ES256K, Key: ecTestKey256}, nil); err == nil {
+		if _, err := wrongSigner.Sign([]byte("payload")); err == nil {
+			t.Error("expected error signing ES256K with a P-256 key")
+		}
+	}
+
+	// ...and ES256 refuses a secp256k1 key.
+	if signer, err := NewSigner(SigningKey{Algorithm: ES256, Key: k1Key}, nil); err == nil {
+		if _, err := signer.Sign([]byte("payload")); err == nil {
+			t.Error("expected error signing ES256 with a secp256k1 key")
+		}
+	}
+
+	// On verify, a valid ES256K message must not verify with a P-256 key of
+	// any kind, and an ES256 message must not verify with a secp256k1 key.
+	signer, err := NewSigner(SigningKey{Algorithm: ES256K, Key: k1Key}, nil)
+	if err != nil {
+		t.Fatal("error on new signer:", err)
+	}
+	obj, err := signer.Sign([]byte("payload"))
+	if err != nil {
+		t.Fatal("error on sign:", err)
+	}
+	if _, err := obj.Verify(&ecTestKey256.PublicKey); err == nil {
+		t.Error("expected ES256K verification with a P-256 key to fail")
+	}
+
+	signer256, err := NewSigner(SigningKey{Algorithm: ES256, Key: ecTestKey256}, nil)
+	if err != nil {
+		t.Fatal("error on new signer:", err)
+	}
+	obj256, err := signer256.Sign([]byte("payload"))
+	if err != nil {
+		t.Fatal("error on sign:", err)
+	}
+	if _, err := obj256.Verify(k1Key.Public()); err == nil {
+		t.Error("expected ES256 verification with a secp256k1 key to fail")
+	}
+}
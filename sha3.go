@@ -0,0 +1,41 @@
+//go:build jose_sha3
+// +build jose_sha3
+
+/*-
+ * Copyright 2019 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jose
+
+import (
+	"golang.org/x/crypto/sha3"
+)
+
+// Experimental content encryption algorithms using SHA-3 in place of SHA-2
+// for the CBC-HMAC authentication tag. These are NON-STANDARD: the names are
+// not registered with IANA and no other JOSE implementation is required to
+// understand them, so use them only between parties that have agreed on them
+// out of band. The AES-CBC encryption and the key/tag sizes are identical to
+// the corresponding SHA-2 algorithms. Compiled in with the jose_sha3 build
+// tag.
+const (
+	A128CBC_HS3_256 = ContentEncryption("A128CBC-HS3-256") // AES-CBC + HMAC-SHA3-256 (128)
+	A256CBC_HS3_512 = ContentEncryption("A256CBC-HS3-512") // AES-CBC + HMAC-SHA3-512 (256)
+)
+
+func init() {
+	registerContentCipher(A128CBC_HS3_256, newAESCBCWithHash(16, sha3.New256))
+	registerContentCipher(A256CBC_HS3_512, newAESCBCWithHash(32, sha3.New512))
+}
@@ -0,0 +1,101 @@
+//go:build jose_sha3
+// +build jose_sha3
+
+/*-
+ * Copyright 2019 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jose
+
+import (
+	"testing"
+)
+
+// No published test vectors exist for the non-standard SHA-3 CBC-HMAC
+// algorithms, so these only check that messages round-trip and that the two
+// variants do not verify each other's tags.
+func TestRoundtripSHA3CBCHMAC(t *testing.T) {
+	cases := []struct {
+		enc     ContentEncryption
+		keySize int
+	}{
+		{A128CBC_HS3_256, 32},
+		{A256CBC_HS3_512, 64},
+	}
+
+	for _, tc := range cases {
+		key := make([]byte, tc.keySize)
+		for i := range key {
+			key[i] = byte(i)
+		}
+
+		enc, err := NewEncrypter(tc.enc, Recipient{Algorithm: DIRECT, Key: key}, nil)
+		if err != nil {
+			t.Fatalf("error on new encrypter for %s: %v", tc.enc, err)
+		}
+
+		obj, err := enc.Encrypt([]byte("Lorem ipsum dolor sit amet"))
+		if err != nil {
+			t.Fatalf("error on encrypt with %s: %v", tc.enc, err)
+		}
+
+		msg, err := obj.CompactSerialize()
+		if err != nil {
+			t.Fatal("error on serialize:", err)
+		}
+
+		parsed, err := ParseEncrypted(msg)
+		if err != nil {
+			t.Fatal("error on parse:", err)
+		}
+
+		output, err := parsed.Decrypt(key)
+		if err != nil {
+			t.Fatalf("error on decrypt with %s: %v", tc.enc, err)
+		}
+
+		if string(output) != "Lorem ipsum dolor sit amet" {
+			t.Errorf("decrypted message does not match for %s, got '%s'", tc.enc, output)
+		}
+	}
+}
+
+func TestSHA3CBCHMACKeyWrap(t *testing.T) {
+	kek := []byte("0123456789abcdef")
+
+	enc, err := NewEncrypter(A128CBC_HS3_256, Recipient{Algorithm: A128KW, Key: kek}, nil)
+	if err != nil {
+		t.Fatal("error on new encrypter:", err)
+	}
+
+	obj, err := enc.Encrypt([]byte("Lorem ipsum dolor sit amet"))
+	if err != nil {
+		t.Fatal("error on encrypt:", err)
+	}
+
+	parsed, err := ParseEncrypted(obj.FullSerialize())
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+
+	output, err := parsed.Decrypt(kek)
+	if err != nil {
+		t.Fatal("error on decrypt:", err)
+	}
+
+	if string(output) != "Lorem ipsum dolor sit amet" {
+		t.Errorf("decrypted message does not match, got '%s'", output)
+	}
+}
@@ -0,0 +1,138 @@
+/*-
+ * Copyright 2019 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jose
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseHeaderJWS(t *testing.T) {
+	signer, err := NewSigner(SigningKey{
+		Algorithm: ES256,
+		Key:       &JSONWebKey{Key: ecTestKey256, KeyID: "route-key"},
+	}, nil)
+	if err != nil {
+		t.Fatal("error on new signer:", err)
+	}
+
+	obj, err := signer.Sign([]byte("Lorem ipsum dolor sit amet"))
+	if err != nil {
+		t.Fatal("error on sign:", err)
+	}
+
+	msg, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal("error on serialize:", err)
+	}
+
+	header, err := ParseHeader(msg)
+	if err != nil {
+		t.Fatal("error on parse header:", err)
+	}
+
+	if header.Algorithm != string(ES256) {
+		t.Errorf("expected alg 'ES256', got '%s'", header.Algorithm)
+	}
+	if header.KeyID != "route-key" {
+		t.Errorf("expected kid 'route-key', got '%s'", header.KeyID)
+	}
+}
+
+func TestParseHeaderJWE(t *testing.T) {
+	key := []byte("0123456789abcdef")
+
+	enc, err := NewEncrypter(A128GCM, Recipient{Algorithm: DIRECT, Key: key, KeyID: "jwe-key"}, nil)
+	if err != nil {
+		t.Fatal("error on new encrypter:", err)
+	}
+
+	obj, err := enc.Encrypt([]byte("Lorem ipsum dolor sit amet"))
+	if err != nil {
+		t.Fatal("error on encrypt:", err)
+	}
+
+	msg, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal("error on serialize:", err)
+	}
+
+	header, err := ParseHeader(msg)
+	if err != nil {
+		t.Fatal("error on parse header:", err)
+	}
+
+	if header.Algorithm != string(DIRECT) {
+		t.Errorf("expected alg 'dir', got '%s'", header.Algorithm)
+	}
+	if header.KeyID != "jwe-key" {
+		t.Errorf("expected kid 'jwe-key', got '%s'", header.KeyID)
+	}
+	if enc, _ := header.Get(HeaderKey("enc")); enc != string(A128GCM) {
+		t.Errorf("expected enc 'A128GCM', got '%v'", enc)
+	}
+}
+
+func TestParseHeaderMalformed(t *testing.T) {
+	invalid := []string{
+		"",
+		"no-dots-at-all",
+		"####.####.####",
+		"eyJhbGciOiJFUzI1NiJ9=.e30.e30", // padded protected segment
+		"e30=",                          // no second part
+		"bm90LWpzb24.e30.e30",           // protected segment is not JSON
+	}
+
+	for _, token := range invalid {
+		if _, err := ParseHeader(token); err == nil {
+			t.Errorf("expected error parsing header of '%s'", token)
+		}
+	}
+}
+
+func TestSegmentCount(t *testing.T) {
+	testCases := []struct {
+		token string
+		count int
+	}{
+		{"", 0},
+		{"header", 1},
+		{"header.payload", 2},
+		{"header.payload.signature", 3},
+		{"header.key.iv.ciphertext.tag", 5},
+		{"header.key.iv.ciphertext.tag.extra", 6},
+	}
+
+	for _, testCase := range testCases {
+		count := SegmentCount(testCase.token)
+		if count != testCase.count {
+			t.Errorf("expected %d segments for '%s', got %d", testCase.count, testCase.token, count)
+		}
+	}
+}
+
+func TestParseSegmentCountErrors(t *testing.T) {
+	_, err := ParseSigned("part1.part2")
+	if err == nil || !strings.Contains(err.Error(), "three parts, got 2") {
+		t.Errorf("expected segment count in JWS parse error, got '%v'", err)
+	}
+
+	_, err = ParseEncrypted("p1.p2.p3.p4.p5.p6")
+	if err == nil || !strings.Contains(err.Error(), "five parts, got 6") {
+		t.Errorf("expected segment count in JWE parse error, got '%v'", err)
+	}
+}
@@ -0,0 +1,1332 @@
+/*-
+ * Copyright 2014 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jose
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/ed25519"
+
+	"gopkg.in/square/go-jose.v2/json"
+)
+
+// NonceSource represents a source of random nonces to go into JWS objects
+type NonceSource interface {
+	Nonce() (string, error)
+}
+
+// NonceValidator checks the nonce carried in the protected header of a
+// verified JWS object, typically against a store of previously seen values
+// for replay protection. Returning a non-nil error rejects the message.
+type NonceValidator func(nonce string) error
+
+// Signer represents a signer which takes a payload and produces a signed JWS object.
+//
+// Signers returned by NewSigner and NewMultiSigner are safe for concurrent
+// use by multiple goroutines: no per-message state is written to the signer.
+type Signer interface {
+	Sign(payload []byte) (*JSONWebSignature, error)
+	// SignContext is like Sign, but threads the context down to any
+	// context-aware opaque signer and respects cancellation before signing
+	// with in-memory keys.
+	SignContext(ctx context.Context, payload []byte) (*JSONWebSignature, error)
+	// SignWithHeaders is like Sign, but merges the given values into the
+	// protected header for this call only. The signer's cached header
+	// serialization is bypassed for the call and left untouched, so
+	// subsequent plain Sign calls keep their precomputed fast path.
+	SignWithHeaders(payload []byte, headers map[HeaderKey]interface{}) (*JSONWebSignature, error)
+	// Clone returns an independent copy of this signer which shares no
+	// mutable state with the original; both may be used freely afterwards.
+	Clone() Signer
+	Options() SignerOptions
+}
+
+// SigningKey represents an algorithm/key used to sign a message.
+type SigningKey struct {
+	Algorithm SignatureAlgorithm
+	Key       interface{}
+}
+
+// SignerOptions represents options that can be set when creating signers.
+type SignerOptions struct {
+	NonceSource NonceSource
+	EmbedJWK    bool
+
+	// Optional map of additional keys to be inserted into the protected header
+	// of a JWS object. Some specifications which make use of JWS like to insert
+	// additional values here. All values must be JSON-serializable.
+	ExtraHeaders map[HeaderKey]interface{}
+
+	// Optional map of additional keys placed in the unprotected "header"
+	// member of each signature, per RFC 7515 §7.2.1. These values are not
+	// covered by the signature, so only put advisory data such as a "kid"
+	// here. They appear in the JSON serializations only; the compact form
+	// has no place for them. A key set both here and in ExtraHeaders is
+	// rejected at signing time.
+	ExtraUnprotectedHeaders map[HeaderKey]interface{}
+
+	// ContentType sets the "cty" (content type) header on signed messages,
+	// describing the payload so receivers can tell a nested JWT from a raw
+	// payload. The zero value omits the header. A "cty" key set directly in
+	// ExtraHeaders takes precedence over this field.
+	ContentType ContentType
+
+	// MinimumRSAKeySize is the smallest RSA modulus, in bits, accepted for
+	// signing keys. The zero value applies the default of 2048; set it
+	// lower explicitly for legacy interop.
+	MinimumRSAKeySize int
+
+	// Rand is the source of randomness for this signer, used by the
+	// randomized signature schemes (RSA-PSS, ECDSA). It defaults to
+	// crypto/rand.Reader via the package-level RandReader, which this field
+	// supersedes; setting it per instance avoids mutating global state in
+	// tests.
+	Rand io.Reader
+
+	// MinimumHMACKeySize is the smallest HMAC key, in bytes, accepted for
+	// the HS256, HS384 and HS512 algorithms. The zero value applies the
+	// RFC 7518 §3.2 default of a key at least as long as the hash output;
+	// set it lower explicitly for legacy interop.
+	MinimumHMACKeySize int
+
+	// PSSSaltLength sets the salt length used when signing with the PS256,
+	// PS384 and PS512 algorithms. The zero value keeps the default of a
+	// salt as long as the hash; positive values give a fixed length in
+	// bytes, and PSSSaltLengthAuto selects the largest salt that fits in
+	// the signature, for interop with partners that expect it.
+	PSSSaltLength int
+}
+
+// PSSSaltLengthAuto selects the maximum PSS salt length when signing and
+// accepts signatures with any salt length when verifying. The zero value of
+// the PSSSaltLength options keeps the library defaults instead.
+const PSSSaltLengthAuto = -1
+
+// VerifierOptions represents options that can be set when parsing a signed
+// message with ParseSignedWithOptions. It is distinct from VerifyOptions,
+// which configures certificate chain verification.
+type VerifierOptions struct {
+	// MinimumRSAKeySize is the smallest RSA modulus, in bits, accepted for
+	// verification keys. The zero value applies the default of 2048; set it
+	// lower explicitly for legacy interop.
+	MinimumRSAKeySize int
+
+	// UnderstoodCritical lists critical ("crit") header parameters the caller
+	// understands and processes, in addition to the ones handled by the
+	// library itself. Signatures carrying a crit parameter outside this set
+	// fail to verify, as required by RFC 7515 §4.1.11.
+	UnderstoodCritical []string
+
+	// AllowedSignatureAlgorithms, when non-empty, restricts the "alg" header
+	// values accepted on verify. Tokens signed with anything outside the
+	// allowlist fail before signature verification, guarding against
+	// algorithm-confusion attacks such as RS256 keys verifying HS256 tokens.
+	AllowedSignatureAlgorithms []SignatureAlgorithm
+
+	// AllowUnsignedTokens permits tokens using the "none" algorithm, which
+	// carry no signature at all, to pass verification. By default such
+	// tokens always fail to verify; enable this only when an unsigned token
+	// is genuinely acceptable, as it removes all integrity protection.
+	AllowUnsignedTokens bool
+
+	// PSSSaltLength restricts the salt length accepted when verifying
+	// PS256, PS384 and PS512 signatures to a fixed number of bytes. The
+	// zero value and PSSSaltLengthAuto accept any salt length, which is
+	// the safe default for interop.
+	PSSSaltLength int
+
+	// ExpectedType, when non-empty, requires the protected "typ" header to
+	// equal this value for verification to succeed, per the explicit-typing
+	// guidance of RFC 8725 §3.11. Tokens with an absent or different type
+	// fail to verify, preventing a token minted for one purpose from being
+	// replayed as another.
+	ExpectedType string
+}
+
+// WithUnderstoodCritical adds the given names to the set of understood
+// critical header parameters and returns the updated VerifierOptions.
+func (vo *VerifierOptions) WithUnderstoodCritical(names ...string) *VerifierOptions {
+	vo.UnderstoodCritical = append(vo.UnderstoodCritical, names...)
+	return vo
+}
+
+// WithAllowedSignatureAlgorithms adds the given algorithms to the verify
+// allowlist and returns the updated VerifierOptions.
+func (vo *VerifierOptions) WithAllowedSignatureAlgorithms(algs ...SignatureAlgorithm) *VerifierOptions {
+	vo.AllowedSignatureAlgorithms = append(vo.AllowedSignatureAlgorithms, algs...)
+	return vo
+}
+
+// WithHeader adds an arbitrary value to the ExtraHeaders map, initializing it
+// if necessary. It returns itself and so can be used in a fluent style.
+func (so *SignerOptions) WithHeader(k HeaderKey, v interface{}) *SignerOptions {
+	if so.ExtraHeaders == nil {
+		so.ExtraHeaders = map[HeaderKey]interface{}{}
+	}
+	so.ExtraHeaders[k] = v
+	return so
+}
+
+// WithUnprotectedHeader adds an arbitrary value to the ExtraUnprotectedHeaders
+// map, initializing it if necessary. It returns itself and so can be used in a
+// fluent style.
+func (so *SignerOptions) WithUnprotectedHeader(k HeaderKey, v interface{}) *SignerOptions {
+	if so.ExtraUnprotectedHeaders == nil {
+		so.ExtraUnprotectedHeaders = map[HeaderKey]interface{}{}
+	}
+	so.ExtraUnprotectedHeaders[k] = v
+	return so
+}
+
+// WithContentType sets the content type ("cty") header and returns the
+// updated SignerOptions.
+func (so *SignerOptions) WithContentType(contentType ContentType) *SignerOptions {
+	so.ContentType = contentType
+	return so
+}
+
+// WithType adds a type ("typ") header and returns the updated SignerOptions.
+func (so *SignerOptions) WithType(typ ContentType) *SignerOptions {
+	return so.WithHeader(HeaderType, typ)
+}
+
+// WithCritical adds the given names to the critical ("crit") header and returns
+// the updated SignerOptions.
+func (so *SignerOptions) WithCritical(names ...string) *SignerOptions {
+	if so.ExtraHeaders[headerCritical] == nil {
+		so.WithHeader(headerCritical, make([]string, 0, len(names)))
+	}
+	crit := so.ExtraHeaders[headerCritical].([]string)
+	so.ExtraHeaders[headerCritical] = append(crit, names...)
+	return so
+}
+
+// WithBase64 adds a base64url-encode payload ("b64") header and returns the updated
+// SignerOptions. When the "b64" value is "false", the payload is not base64 encoded.
+func (so *SignerOptions) WithBase64(b64 bool) *SignerOptions {
+	if !b64 {
+		so.WithHeader(headerB64, b64)
+		so.WithCritical(headerB64)
+	}
+	return so
+}
+
+type payloadSigner interface {
+	signPayload(payload []byte, alg SignatureAlgorithm) (Signature, error)
+}
+
+type payloadVerifier interface {
+	verifyPayload(payload []byte, signature []byte, alg SignatureAlgorithm) error
+}
+
+// Context-aware variants of payloadSigner and payloadVerifier, implemented by
+// signers that can propagate cancellation, such as opaque signers backed by
+// network calls.
+type payloadSignerContext interface {
+	signPayloadContext(ctx context.Context, payload []byte, alg SignatureAlgorithm) (Signature, error)
+}
+
+type payloadVerifierContext interface {
+	verifyPayloadContext(ctx context.Context, payload []byte, signature []byte, alg SignatureAlgorithm) error
+}
+
+// signPayloadWithContext signs through a context-aware signer when available,
+// otherwise checks the context for cancellation before signing.
+func signPayloadWithContext(ctx context.Context, signer payloadSigner, payload []byte, alg SignatureAlgorithm) (Signature, error) {
+	if ctxSigner, ok := signer.(payloadSignerContext); ok {
+		return ctxSigner.signPayloadContext(ctx, payload, alg)
+	}
+	if err := ctx.Err(); err != nil {
+		return Signature{}, err
+	}
+	return signer.signPayload(payload, alg)
+}
+
+// verifyPayloadWithContext verifies through a context-aware verifier when
+// available, otherwise checks the context for cancellation before verifying.
+func verifyPayloadWithContext(ctx context.Context, verifier payloadVerifier, payload []byte, signature []byte, alg SignatureAlgorithm) error {
+	if ctxVerifier, ok := verifier.(payloadVerifierContext); ok {
+		return ctxVerifier.verifyPayloadContext(ctx, payload, signature, alg)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return verifier.verifyPayload(payload, signature, alg)
+}
+
+type genericSigner struct {
+	recipients              []recipientSigInfo
+	nonceSource             NonceSource
+	embedJWK                bool
+	extraHeaders            map[HeaderKey]interface{}
+	extraUnprotectedHeaders map[HeaderKey]interface{}
+	contentType             ContentType
+	minimumRSAKeySize       int
+	minimumHMACKeySize      int
+	rand                    io.Reader
+	pssSaltLength           int
+
+	// precomputed caches the serialized headers per recipient when they are
+	// static across Sign calls (no nonce source). Aligned with recipients;
+	// nil when headers must be rebuilt per call. Treated as immutable once
+	// built, so clones may share it.
+	precomputed []precomputedHeaders
+}
+
+// precomputedHeaders holds the header values for one recipient in the forms
+// needed during signing: the raw protected and unprotected headers for the
+// produced object and the base64url-encoded protected header for the signing
+// input.
+type precomputedHeaders struct {
+	protected   *rawHeader
+	header      *rawHeader
+	encoded     string
+	needsBase64 bool
+}
+
+// applySignerRand points a payload signer at the signer's random source.
+// Signers with deterministic schemes are left untouched.
+func applySignerRand(signer payloadSigner, rand io.Reader) {
+	if rand == nil {
+		return
+	}
+	switch sig := signer.(type) {
+	case *rsaDecrypterSigner:
+		sig.rand = rand
+	case *ecDecrypterSigner:
+		sig.rand = rand
+	case *edDecrypterSigner:
+		sig.rand = rand
+	}
+}
+
+// applySignerPSSSaltLength points an RSA payload signer at the signer's
+// configured PSS salt length. Other signers are left untouched.
+func applySignerPSSSaltLength(signer payloadSigner, saltLength int) {
+	if saltLength == 0 {
+		return
+	}
+	if sig, ok := signer.(*rsaDecrypterSigner); ok {
+		sig.pssSaltLength = saltLength
+	}
+}
+
+// applyVerifierPSSSaltLength points an RSA payload verifier at the
+// configured PSS salt length. Other verifiers are left untouched.
+func applyVerifierPSSSaltLength(verifier payloadVerifier, saltLength int) {
+	if saltLength == 0 {
+		return
+	}
+	if ver, ok := verifier.(*rsaEncrypterVerifier); ok {
+		ver.pssSaltLength = saltLength
+	}
+}
+
+type recipientSigInfo struct {
+	sigAlg    SignatureAlgorithm
+	publicKey func() *JSONWebKey
+	signer    payloadSigner
+}
+
+func staticPublicKey(jwk *JSONWebKey) func() *JSONWebKey {
+	return func() *JSONWebKey {
+		return jwk
+	}
+}
+
+// NewSigner creates an appropriate signer based on the key type
+func NewSigner(sig SigningKey, opts *SignerOptions) (Signer, error) {
+	return NewMultiSigner([]SigningKey{sig}, opts)
+}
+
+// NewMultiSigner creates a signer for multiple recipients
+func NewMultiSigner(sigs []SigningKey, opts *SignerOptions) (Signer, error) {
+	signer := &genericSigner{recipients: []recipientSigInfo{}}
+
+	if opts != nil {
+		signer.nonceSource = opts.NonceSource
+		signer.embedJWK = opts.EmbedJWK
+		signer.extraHeaders = opts.ExtraHeaders
+		signer.extraUnprotectedHeaders = opts.ExtraUnprotectedHeaders
+		signer.contentType = opts.ContentType
+		signer.minimumRSAKeySize = opts.MinimumRSAKeySize
+		signer.minimumHMACKeySize = opts.MinimumHMACKeySize
+		signer.rand = opts.Rand
+		signer.pssSaltLength = opts.PSSSaltLength
+	}
+
+	for _, sig := range sigs {
+		err := signer.addRecipient(sig.Algorithm, sig.Key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Without a nonce source the headers are the same for every Sign call,
+	// so serialize them once up front instead of on each call. Header errors
+	// are deliberately left to surface from Sign, as they always have.
+	if signer.nonceSource == nil {
+		precomputed := make([]precomputedHeaders, len(signer.recipients))
+		for i, recipient := range signer.recipients {
+			ph, err := signer.makeHeaders(recipient)
+			if err != nil {
+				precomputed = nil
+				break
+			}
+			precomputed[i] = ph
+		}
+		signer.precomputed = precomputed
+	}
+
+	return signer, nil
+}
+
+// newVerifier creates a verifier based on the key type
+func newVerifier(verificationKey interface{}) (payloadVerifier, error) {
+	switch verificationKey := verificationKey.(type) {
+	case ed25519.PublicKey:
+		return &edEncrypterVerifier{
+			publicKey: verificationKey,
+		}, nil
+	case *rsa.PublicKey:
+		return &rsaEncrypterVerifier{
+			publicKey: verificationKey,
+		}, nil
+	case *ecdsa.PublicKey:
+		return &ecEncrypterVerifier{
+			publicKey: verificationKey,
+		}, nil
+	case []byte:
+		return &symmetricMac{
+			key: verificationKey,
+		}, nil
+	case JSONWebKey:
+		if !verificationKey.AllowsKeyOp("verify") {
+			return nil, errors.New("square/go-jose: JWK key_ops does not permit verification")
+		}
+		return newVerifier(verificationKey.Key)
+	case *JSONWebKey:
+		if !verificationKey.AllowsKeyOp("verify") {
+			return nil, errors.New("square/go-jose: JWK key_ops does not permit verification")
+		}
+		return newVerifier(verificationKey.Key)
+	}
+	if ov, ok := verificationKey.(OpaqueVerifier); ok {
+		return &opaqueVerifier{verifier: ov}, nil
+	}
+	return nil, ErrUnsupportedKeyType
+}
+
+// isSignatureAlgorithm reports whether alg is a known signature algorithm.
+func isSignatureAlgorithm(alg SignatureAlgorithm) bool {
+	switch alg {
+	case EdDSA, HS256, HS384, HS512, RS256, RS384, RS512,
+		ES256, ES384, ES512, ES256K, PS256, PS384, PS512:
+		return true
+	}
+	return false
+}
+
+// resolveSignatureAlgorithm returns the effective signature algorithm for a
+// signing key, inferring it from the key's "alg" value when left empty. An
+// explicit algorithm that contradicts the key's own is an error. JWK "alg"
+// values that are not signature algorithms (some issuers put the key type
+// there) are ignored.
+func resolveSignatureAlgorithm(alg SignatureAlgorithm, key interface{}) (SignatureAlgorithm, error) {
+	var fromKey SignatureAlgorithm
+	switch k := key.(type) {
+	case JSONWebKey:
+		fromKey = SignatureAlgorithm(k.Algorithm)
+	case *JSONWebKey:
+		if k != nil {
+			fromKey = SignatureAlgorithm(k.Algorithm)
+		}
+	default:
+		// Nothing to infer from for raw keys.
+		return alg, nil
+	}
+
+	if !isSignatureAlgorithm(fromKey) {
+		fromKey = ""
+	}
+
+	switch {
+	case alg == "" && fromKey == "":
+		return "", fmt.Errorf("square/go-jose: no signature algorithm set on signing key")
+	case alg == "":
+		return fromKey, nil
+	case fromKey != "" && fromKey != alg:
+		return "", fmt.Errorf("square/go-jose: signature algorithm '%s' conflicts with key algorithm '%s'", alg, fromKey)
+	}
+
+	return alg, nil
+}
+
+func (ctx *genericSigner) addRecipient(alg SignatureAlgorithm, signingKey interface{}) error {
+	if err := validateRSAKeySize(signingKey, ctx.minimumRSAKeySize); err != nil {
+		return err
+	}
+
+	alg, err := resolveSignatureAlgorithm(alg, signingKey)
+	if err != nil {
+		return err
+	}
+
+	if err := validateHMACKeySize(alg, signingKey, ctx.minimumHMACKeySize); err != nil {
+		return err
+	}
+
+	recipient, err := makeJWSRecipient(alg, signingKey)
+	if err != nil {
+		return err
+	}
+
+	applySignerRand(recipient.signer, ctx.rand)
+	applySignerPSSSaltLength(recipient.signer, ctx.pssSaltLength)
+	ctx.recipients = append(ctx.recipients, recipient)
+	return nil
+}
+
+func makeJWSRecipient(alg SignatureAlgorithm, signingKey interface{}) (recipientSigInfo, error) {
+	switch signingKey := signingKey.(type) {
+	case ed25519.PrivateKey:
+		return newEd25519Signer(alg, signingKey)
+	case *rsa.PrivateKey:
+		return newRSASigner(alg, signingKey)
+	case *ecdsa.PrivateKey:
+		return newECDSASigner(alg, signingKey)
+	case []byte:
+		return newSymmetricSigner(alg, signingKey)
+	case JSONWebKey:
+		return newJWKSigner(alg, signingKey)
+	case *JSONWebKey:
+		return newJWKSigner(alg, *signingKey)
+	}
+	if signer, ok := signingKey.(OpaqueSigner); ok {
+		return newOpaqueSigner(alg, signer)
+	}
+	return recipientSigInfo{}, ErrUnsupportedKeyType
+}
+
+func newJWKSigner(alg SignatureAlgorithm, signingKey JSONWebKey) (recipientSigInfo, error) {
+	if !signingKey.AllowsKeyOp("sign") {
+		return recipientSigInfo{}, errors.New("square/go-jose: JWK key_ops does not permit signing")
+	}
+	recipient, err := makeJWSRecipient(alg, signingKey.Key)
+	if err != nil {
+		return recipientSigInfo{}, err
+	}
+	if recipient.publicKey != nil && recipient.publicKey() != nil {
+		// recipient.publicKey is a JWK synthesized for embedding when recipientSigInfo
+		// was created for the inner key (such as a RSA or ECDSA public key). It contains
+		// the pub key for embedding, but doesn't have extra params like key id.
+		publicKey := signingKey
+		publicKey.Key = recipient.publicKey().Key
+		recipient.publicKey = staticPublicKey(&publicKey)
+
+		// This should be impossible, but let's check anyway.
+		if !recipient.publicKey().IsPublic() {
+			return recipientSigInfo{}, errors.New("square/go-jose: public key was unexpectedly not public")
+		}
+	}
+	return recipient, nil
+}
+
+func (ctx *genericSigner) Sign(payload []byte) (*JSONWebSignature, error) {
+	return ctx.signContext(context.Background(), payload, nil)
+}
+
+// SignContext is like Sign but threads the context down to any context-aware
+// opaque signer and respects cancellation before signing with in-memory keys.
+func (gs *genericSigner) SignContext(ctx context.Context, payload []byte) (*JSONWebSignature, error) {
+	return gs.signContext(ctx, payload, nil)
+}
+
+// SignWithHeaders is like Sign but merges the given values into the protected
+// header for this call only, overriding any equally-named static header.
+func (gs *genericSigner) SignWithHeaders(payload []byte, headers map[HeaderKey]interface{}) (*JSONWebSignature, error) {
+	return gs.signContext(context.Background(), payload, headers)
+}
+
+func (gs *genericSigner) signContext(ctx context.Context, payload []byte, overrides map[HeaderKey]interface{}) (*JSONWebSignature, error) {
+	obj := &JSONWebSignature{}
+	obj.payload = payload
+	obj.Signatures = make([]Signature, len(gs.recipients))
+
+	for i, recipient := range gs.recipients {
+		var ph precomputedHeaders
+		if gs.precomputed != nil && overrides == nil {
+			ph = gs.precomputed[i]
+		} else {
+			var err error
+			ph, err = gs.makeHeadersWithOverrides(recipient, overrides)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		var input bytes.Buffer
+		input.Grow(len(ph.encoded) + 1 + base64.RawURLEncoding.EncodedLen(len(payload)))
+		input.WriteString(ph.encoded)
+		input.WriteByte('.')
+
+		if ph.needsBase64 {
+			input.WriteString(base64.RawURLEncoding.EncodeToString(payload))
+		} else {
+			input.Write(payload)
+		}
+
+		signatureInfo, err := signPayloadWithContext(ctx, recipient.signer, input.Bytes(), recipient.sigAlg)
+		if err != nil {
+			return nil, err
+		}
+
+		signatureInfo.protected = ph.protected
+		signatureInfo.header = ph.header
+		obj.Signatures[i] = signatureInfo
+	}
+
+	return obj, nil
+}
+
+// makeHeaders assembles the protected and unprotected headers for one
+// recipient and serializes the protected header for use as signing input.
+func (gs *genericSigner) makeHeaders(recipient recipientSigInfo) (precomputedHeaders, error) {
+	return gs.makeHeadersWithOverrides(recipient, nil)
+}
+
+// makeHeadersWithOverrides is makeHeaders with additional per-call protected
+// header values merged in last, so they win over the static ones.
+func (gs *genericSigner) makeHeadersWithOverrides(recipient recipientSigInfo, overrides map[HeaderKey]interface{}) (precomputedHeaders, error) {
+	protected := map[HeaderKey]interface{}{
+		headerAlgorithm: string(recipient.sigAlg),
+	}
+
+	if recipient.publicKey != nil && recipient.publicKey() != nil {
+		// We want to embed the JWK or set the kid header, but not both. Having a protected
+		// header that contains an embedded JWK while also simultaneously containing the kid
+		// header is confusing, and at least in ACME the two are considered to be mutually
+		// exclusive. The fact that both can exist at the same time is a somewhat unfortunate
+		// result of the JOSE spec. We've decided that this library will only include one or
+		// the other to avoid this confusion.
+		//
+		// See https://github.com/square/go-jose/issues/157 for more context.
+		if gs.embedJWK {
+			protected[headerJWK] = recipient.publicKey()
+		} else {
+			keyID := recipient.publicKey().KeyID
+			if keyID != "" {
+				protected[headerKeyID] = keyID
+			}
+		}
+	}
+
+	if gs.nonceSource != nil {
+		nonce, err := gs.nonceSource.Nonce()
+		if err != nil {
+			return precomputedHeaders{}, fmt.Errorf("square/go-jose: Error generating nonce: %v", err)
+		}
+		protected[headerNonce] = nonce
+	}
+
+	if gs.contentType != "" {
+		protected[HeaderContentType] = gs.contentType
+	}
+
+	for k, v := range gs.extraHeaders {
+		protected[k] = v
+	}
+
+	for k, v := range overrides {
+		protected[k] = v
+	}
+
+	serializedProtected := mustSerializeJSON(protected)
+	needsBase64 := true
+
+	if b64, ok := protected[headerB64]; ok {
+		if needsBase64, ok = b64.(bool); !ok {
+			return precomputedHeaders{}, errors.New("square/go-jose: Invalid b64 header parameter")
+		}
+	}
+
+	rawProtected := &rawHeader{}
+	for k, v := range protected {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return precomputedHeaders{}, fmt.Errorf("square/go-jose: Error marshalling item %#v: %v", k, err)
+		}
+		(*rawProtected)[k] = makeRawMessage(b)
+	}
+
+	var rawUnprotected *rawHeader
+	if len(gs.extraUnprotectedHeaders) > 0 {
+		rawUnprotected = &rawHeader{}
+		for k, v := range gs.extraUnprotectedHeaders {
+			// RFC 7515 §7.2.1 requires the protected and unprotected
+			// header names to be disjoint.
+			if _, present := protected[k]; present {
+				return precomputedHeaders{}, fmt.Errorf("square/go-jose: header parameter '%s' is present in both the protected and unprotected header", k)
+			}
+			b, err := json.Marshal(v)
+			if err != nil {
+				return precomputedHeaders{}, fmt.Errorf("square/go-jose: Error marshalling item %#v: %v", k, err)
+			}
+			(*rawUnprotected)[k] = makeRawMessage(b)
+		}
+	}
+
+	return precomputedHeaders{
+		protected:   rawProtected,
+		header:      rawUnprotected,
+		encoded:     base64.RawURLEncoding.EncodeToString(serializedProtected),
+		needsBase64: needsBase64,
+	}, nil
+}
+
+// Clone returns an independent copy of this signer. The clone gets its own
+// recipient list and extra header map, so neither instance can observe state
+// changes in the other.
+func (ctx *genericSigner) Clone() Signer {
+	clone := *ctx
+
+	clone.recipients = make([]recipientSigInfo, len(ctx.recipients))
+	copy(clone.recipients, ctx.recipients)
+
+	if ctx.extraHeaders != nil {
+		clone.extraHeaders = make(map[HeaderKey]interface{}, len(ctx.extraHeaders))
+		for k, v := range ctx.extraHeaders {
+			clone.extraHeaders[k] = v
+		}
+	}
+
+	if ctx.extraUnprotectedHeaders != nil {
+		clone.extraUnprotectedHeaders = make(map[HeaderKey]interface{}, len(ctx.extraUnprotectedHeaders))
+		for k, v := range ctx.extraUnprotectedHeaders {
+			clone.extraUnprotectedHeaders[k] = v
+		}
+	}
+
+	return &clone
+}
+
+func (ctx *genericSigner) Options() SignerOptions {
+	return SignerOptions{
+		NonceSource:             ctx.nonceSource,
+		EmbedJWK:                ctx.embedJWK,
+		ExtraHeaders:            ctx.extraHeaders,
+		ExtraUnprotectedHeaders: ctx.extraUnprotectedHeaders,
+		ContentType:             ctx.contentType,
+	}
+}
+
+// PrepareSign computes the exact bytes a signer for the given algorithm and
+// options would sign over: base64url(protected) || '.' || base64url(payload).
+// This supports external or remote signing services that receive the bytes to
+// sign; pair it with AssembleJWS to build the final object once the signature
+// comes back. No key is available here, so key-derived headers such as an
+// embedded JWK are not produced; set "kid" or similar through the options if
+// the receiver needs them.
+func PrepareSign(payload []byte, alg SignatureAlgorithm, opts *SignerOptions) ([]byte, error) {
+	protected := map[HeaderKey]interface{}{
+		headerAlgorithm: string(alg),
+	}
+
+	if opts != nil {
+		if opts.NonceSource != nil {
+			nonce, err := opts.NonceSource.Nonce()
+			if err != nil {
+				return nil, fmt.Errorf("square/go-jose: Error generating nonce: %v", err)
+			}
+			protected[headerNonce] = nonce
+		}
+		if opts.ContentType != "" {
+			protected[HeaderContentType] = opts.ContentType
+		}
+		for k, v := range opts.ExtraHeaders {
+			protected[k] = v
+		}
+	}
+
+	serializedProtected := mustSerializeJSON(protected)
+
+	var input bytes.Buffer
+	input.WriteString(base64.RawURLEncoding.EncodeToString(serializedProtected))
+	input.WriteByte('.')
+	input.WriteString(base64.RawURLEncoding.EncodeToString(payload))
+	return input.Bytes(), nil
+}
+
+// AssembleJWS builds a signed object from a signing input produced by
+// PrepareSign and the raw signature bytes returned by the external signer.
+// The result is parsed like a received message, so the usual Verify methods
+// apply to it.
+func AssembleJWS(signingInput, signature []byte) (*JSONWebSignature, error) {
+	if bytes.Count(signingInput, []byte(".")) != 1 {
+		return nil, errors.New("square/go-jose: invalid signing input, expected two dot-separated parts")
+	}
+
+	token := string(signingInput) + "." + base64.RawURLEncoding.EncodeToString(signature)
+	return ParseSigned(token)
+}
+
+// AddSignature signs the object's payload with an additional key and appends
+// the resulting signature, enabling counter-signing flows where several
+// parties attest to the same payload. The exact original payload is signed
+// and existing signatures are left untouched. An object holding more than
+// one signature can only be serialized with FullSerialize; CompactSerialize
+// returns ErrNotSupported for it.
+func (obj *JSONWebSignature) AddSignature(signingKey SigningKey, opts *SignerOptions) error {
+	signer, err := NewSigner(signingKey, opts)
+	if err != nil {
+		return err
+	}
+
+	signed, err := signer.Sign(obj.payload)
+	if err != nil {
+		return err
+	}
+
+	obj.Signatures = append(obj.Signatures, signed.Signatures...)
+	return nil
+}
+
+// Verify validates the signature on the object and returns the payload.
+// This function does not support multi-signature, if you desire multi-sig
+// verification use VerifyMulti instead.
+//
+// Be careful when verifying signatures based on embedded JWKs inside the
+// payload header. You cannot assume that the key received in a payload is
+// trusted.
+func (obj JSONWebSignature) Verify(verificationKey interface{}) ([]byte, error) {
+	err := obj.DetachedVerify(obj.payload, verificationKey)
+	if err != nil {
+		return nil, err
+	}
+	return obj.payload, nil
+}
+
+// VerifyWithNonce validates the signature on the object exactly like Verify
+// and then passes the nonce from the protected header to the given validator.
+// The validator is only invoked after the signature has been verified, so it
+// never sees values from forged messages. A message without a nonce is passed
+// to the validator as the empty string, leaving it to the validator to decide
+// whether a nonce is required.
+func (obj JSONWebSignature) VerifyWithNonce(verificationKey interface{}, validate NonceValidator) ([]byte, error) {
+	if validate == nil {
+		return nil, errors.New("square/go-jose: nonce validator must not be nil")
+	}
+
+	payload, err := obj.Verify(verificationKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce string
+	if protected := obj.Signatures[0].protected; protected != nil {
+		nonce = protected.getNonce()
+	}
+
+	err = validate(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// VerifyContext is like Verify but threads the context down to any
+// context-aware opaque verifier and respects cancellation before verifying
+// with in-memory keys.
+func (obj JSONWebSignature) VerifyContext(ctx context.Context, verificationKey interface{}) ([]byte, error) {
+	err := obj.DetachedVerifyContext(ctx, obj.payload, verificationKey)
+	if err != nil {
+		return nil, err
+	}
+	return obj.payload, nil
+}
+
+// VerifyWithHeader validates the signature on the object and returns the
+// payload together with the header of the signature that verified. Reading
+// fields such as KeyID or custom claims from the returned header is safe,
+// unlike inspecting a parsed object's headers before verification.
+// Multi-signature objects are supported; the returned header belongs to
+// whichever signature matched the key.
+func (obj JSONWebSignature) VerifyWithHeader(verificationKey interface{}) ([]byte, Header, error) {
+	_, signature, payload, err := obj.VerifyMulti(verificationKey)
+	if err != nil {
+		return nil, Header{}, err
+	}
+
+	headers, err := signature.mergedHeaders().sanitized()
+	if err != nil {
+		return nil, Header{}, fmt.Errorf("square/go-jose: failed to sanitize header: %v", err)
+	}
+
+	return payload, headers, nil
+}
+
+// VerifyWithEmbeddedKey validates the signature using the public key carried
+// in the message's own "jwk" header, as produced by signers with EmbedJWK
+// set, and returns the payload. Calling this is an explicit opt-in to
+// trusting the embedded key: a valid signature proves only that the token is
+// internally consistent, not who produced it, so callers must establish
+// trust in the key by other means, such as comparing its thumbprint against
+// an allowlist.
+func (obj JSONWebSignature) VerifyWithEmbeddedKey() ([]byte, error) {
+	lastErr := error(ErrCryptoFailure)
+	for _, signature := range obj.Signatures {
+		key, err := signature.mergedHeaders().getJWK()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if key == nil {
+			lastErr = errors.New("square/go-jose: no JWK embedded in header")
+			continue
+		}
+
+		if _, _, payload, err := obj.VerifyMulti(key); err == nil {
+			return payload, nil
+		} else {
+			lastErr = err
+		}
+	}
+
+	return nil, lastErr
+}
+
+// VerifyWithKeyFunc validates the signature on the object using a key
+// resolved by the given callback and returns the payload. The resolver
+// receives the (unverified) header of each signature, including fields such
+// as KeyID and Algorithm, and returns the verification key to use — letting
+// callers look keys up in a keystore by "kid" rather than passing one in.
+// An error from the resolver fails verification of that signature and is
+// returned to the caller if no signature verifies.
+func (obj JSONWebSignature) VerifyWithKeyFunc(keyFunc func(Header) (interface{}, error)) ([]byte, error) {
+	if keyFunc == nil {
+		return nil, errors.New("square/go-jose: nil key resolver")
+	}
+
+	lastErr := error(ErrCryptoFailure)
+	for _, signature := range obj.Signatures {
+		headers, err := signature.mergedHeaders().sanitized()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		key, err := keyFunc(headers)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if _, _, payload, err := obj.VerifyMulti(key); err == nil {
+			return payload, nil
+		} else {
+			lastErr = err
+		}
+	}
+
+	return nil, lastErr
+}
+
+// VerifyWithSet validates the signature using keys from the given JSON Web
+// Key Set and returns the payload. Candidate keys are selected by the
+// signature's "kid" header; a kid that matches no key in the set fails
+// verification of that signature. Signatures without a "kid" are tried
+// against every key in the set. Keys marked with "use" of "enc" are never
+// used for verification.
+func (obj JSONWebSignature) VerifyWithSet(set JSONWebKeySet) ([]byte, error) {
+	lastErr := error(ErrCryptoFailure)
+	for _, signature := range obj.Signatures {
+		headers := signature.mergedHeaders()
+
+		candidates := set.Keys
+		if kid := headers.getString(headerKeyID); kid != "" {
+			candidates = set.Key(kid)
+			if len(candidates) == 0 {
+				lastErr = fmt.Errorf("square/go-jose: no key with ID '%s' in the key set", kid)
+				continue
+			}
+		}
+
+		for _, key := range candidates {
+			if key.Use == "enc" {
+				continue
+			}
+			if _, _, payload, err := obj.VerifyMulti(key); err == nil {
+				return payload, nil
+			} else {
+				lastErr = err
+			}
+		}
+	}
+
+	return nil, lastErr
+}
+
+// JWKSFetcher retrieves the JSON Web Key Set referenced by a "jku" header.
+// The library performs no network I/O of its own: callers supply the fetcher
+// and with it decide on transport concerns such as TLS validation, timeouts,
+// redirects and caching.
+type JWKSFetcher func(ctx context.Context, url string) (JSONWebKeySet, error)
+
+// VerifyWithJKU validates the signature using a key fetched from the URL in
+// the message's "jku" header and returns the payload. The URL must be listed
+// in allowedURLs before the fetcher is invoked; the jku header is attacker
+// controlled, so an empty allowlist is an error rather than permission to
+// fetch from anywhere. Within the fetched key set, candidate keys are
+// selected by the signature's "kid" header, or all keys are tried if no
+// "kid" is present.
+func (obj JSONWebSignature) VerifyWithJKU(ctx context.Context, fetcher JWKSFetcher, allowedURLs []string) ([]byte, error) {
+	if fetcher == nil {
+		return nil, errors.New("square/go-jose: nil JWKS fetcher")
+	}
+	if len(allowedURLs) == 0 {
+		return nil, errors.New("square/go-jose: no allowed jku URLs given")
+	}
+
+	lastErr := error(ErrCryptoFailure)
+	for _, signature := range obj.Signatures {
+		headers := signature.mergedHeaders()
+
+		url := headers.getString(headerJKU)
+		if url == "" {
+			lastErr = errors.New("square/go-jose: no jku header present in message")
+			continue
+		}
+		if !jkuAllowed(url, allowedURLs) {
+			lastErr = fmt.Errorf("square/go-jose: jku URL '%s' is not in the allowlist", url)
+			continue
+		}
+
+		keySet, err := fetcher(ctx, url)
+		if err != nil {
+			lastErr = fmt.Errorf("square/go-jose: fetching key set from jku URL failed: %v", err)
+			continue
+		}
+
+		candidates := keySet.Keys
+		if kid := headers.getString(headerKeyID); kid != "" {
+			candidates = keySet.Key(kid)
+			if len(candidates) == 0 {
+				lastErr = fmt.Errorf("square/go-jose: no key with ID '%s' in the key set referenced by jku", kid)
+				continue
+			}
+		}
+
+		for _, key := range candidates {
+			if _, _, payload, err := obj.VerifyMulti(key); err == nil {
+				return payload, nil
+			} else {
+				lastErr = err
+			}
+		}
+	}
+
+	return nil, lastErr
+}
+
+// jkuAllowed reports whether the given jku URL is permitted by the allowlist.
+func jkuAllowed(url string, allowedURLs []string) bool {
+	for _, allowed := range allowedURLs {
+		if url == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyOptions controls certificate-based verification. The embedded
+// x509.VerifyOptions validate the x5c chain against the caller's roots; the
+// remaining fields enable optional JOSE-level checks.
+type VerifyOptions struct {
+	x509.VerifyOptions
+
+	// ValidateThumbprints recomputes the x5t (SHA-1) and x5t#S256 (SHA-256)
+	// thumbprints of the leaf certificate and compares them to the header
+	// values when those headers are present, catching tampered headers.
+	// Messages without thumbprint headers are not rejected.
+	ValidateThumbprints bool
+}
+
+// VerifyWithCertificate validates the x5c certificate chain in the protected
+// header against the provided roots and then verifies the signature using the
+// public key of the leaf certificate. Per RFC 7515 section 4.1.6 the leaf
+// must be the first certificate in the chain; if another certificate is
+// first, signature verification fails. Returns the payload along with the
+// verified chains. This function does not support multi-signature, if you
+// desire multi-sig verification use VerifyMulti with the leaf key instead.
+func (obj JSONWebSignature) VerifyWithCertificate(opts VerifyOptions) ([]byte, [][]*x509.Certificate, error) {
+	if len(obj.Signatures) > 1 {
+		return nil, nil, errors.New("square/go-jose: too many signatures in payload; expecting only one")
+	}
+
+	chains, err := obj.Signatures[0].Protected.Certificates(opts.VerifyOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if opts.ValidateThumbprints {
+		err = validateCertificateThumbprints(obj.Signatures[0].Protected, chains[0][0])
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	payload, err := obj.Verify(chains[0][0].PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return payload, chains, nil
+}
+
+// TrustedLeafKey validates the x5c certificate chain of a single-signature
+// message using the given verify options and returns the public key of the
+// verified leaf certificate. Intermediate certificates embedded in the chain
+// are used when opts does not supply its own intermediate pool, and extended
+// key usage is checked against opts.KeyUsages. The returned key can be passed
+// to Verify, or retained to verify future messages from the same signer.
+func (obj JSONWebSignature) TrustedLeafKey(opts x509.VerifyOptions) (interface{}, error) {
+	if len(obj.Signatures) > 1 {
+		return nil, errors.New("square/go-jose: too many signatures in payload; expecting only one")
+	}
+
+	chains, err := obj.Signatures[0].Protected.Certificates(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return chains[0][0].PublicKey, nil
+}
+
+// TrustedLeafKeyFromPool validates the x5c certificate chain against the
+// given root pool and returns the public key of the verified leaf
+// certificate. Extended key usage is not restricted to server authentication,
+// since JOSE signing certificates are generally not TLS certificates; use
+// TrustedLeafKey with explicit KeyUsages to enforce a particular usage.
+func (obj JSONWebSignature) TrustedLeafKeyFromPool(roots *x509.CertPool) (interface{}, error) {
+	return obj.TrustedLeafKey(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+}
+
+// validateCertificateThumbprints compares the x5t and x5t#S256 header values,
+// when present, against digests recomputed from the leaf certificate.
+func validateCertificateThumbprints(h Header, leaf *x509.Certificate) error {
+	if v, ok := h.ExtraHeaders[headerX5t]; ok {
+		sum := sha1.Sum(leaf.Raw)
+		if !thumbprintMatches(v, sum[:]) {
+			return errors.New("square/go-jose: x5t thumbprint does not match leaf certificate")
+		}
+	}
+
+	if v, ok := h.ExtraHeaders[headerX5tS256]; ok {
+		sum := sha256.Sum256(leaf.Raw)
+		if !thumbprintMatches(v, sum[:]) {
+			return errors.New("square/go-jose: x5t#S256 thumbprint does not match leaf certificate")
+		}
+	}
+
+	return nil
+}
+
+// thumbprintMatches checks a base64url-encoded header value against the
+// expected digest. Malformed values count as mismatches.
+func thumbprintMatches(value interface{}, sum []byte) bool {
+	encoded, ok := value.(string)
+	if !ok {
+		return false
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(decoded, sum)
+}
+
+// UnsafePayloadWithoutVerification returns the payload without
+// verifying it. The content returned from this function cannot be
+// trusted.
+func (obj JSONWebSignature) UnsafePayloadWithoutVerification() []byte {
+	return obj.payload
+}
+
+// DetachedVerify validates a detached signature on the given payload. In
+// most cases, you will probably want to use Verify instead. DetachedVerify
+// is only useful if you have a payload and signature that are separated from
+// each other.
+func (obj JSONWebSignature) DetachedVerify(payload []byte, verificationKey interface{}) error {
+	return obj.DetachedVerifyContext(context.Background(), payload, verificationKey)
+}
+
+// DetachedVerifyContext is like DetachedVerify but threads the context down
+// to any context-aware opaque verifier.
+func (obj JSONWebSignature) DetachedVerifyContext(ctx context.Context, payload []byte, verificationKey interface{}) error {
+	if err := validateRSAKeySize(verificationKey, obj.minimumRSAKeySize); err != nil {
+		return err
+	}
+
+	verifier, err := newVerifier(verificationKey)
+	if err != nil {
+		return err
+	}
+	applyVerifierPSSSaltLength(verifier, obj.pssSaltLength)
+
+	if len(obj.Signatures) > 1 {
+		return errors.New("square/go-jose: too many signatures in payload; expecting only one")
+	}
+
+	signature := obj.Signatures[0]
+
+	if err := validateExpectedType(signature.protected, obj.expectedType); err != nil {
+		return err
+	}
+
+	headers := signature.mergedHeaders()
+	critical, err := headers.getCritical()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range critical {
+		if !supportedCritical[name] && !criticalUnderstood(obj.understoodCritical, name) {
+			return ErrCryptoFailure
+		}
+	}
+
+	if headers.getSignatureAlgorithm() == noneAlgorithm {
+		if !obj.allowUnsignedTokens {
+			return errors.New(`square/go-jose: refusing token signed with the "none" algorithm; set AllowUnsignedTokens to accept it`)
+		}
+		if len(signature.Signature) != 0 {
+			return ErrCryptoFailure
+		}
+		return nil
+	}
+
+	if alg := headers.getSignatureAlgorithm(); !obj.signatureAlgorithmAllowed(alg) {
+		return fmt.Errorf("square/go-jose: signature algorithm '%s' is not on the allowlist", alg)
+	}
+
+	input, err := obj.computeAuthData(payload, &signature)
+	if err != nil {
+		return ErrCryptoFailure
+	}
+
+	alg := headers.getSignatureAlgorithm()
+	err = verifyPayloadWithContext(ctx, verifier, input, signature.Signature, alg)
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		// Don't collapse cancellation into ErrCryptoFailure.
+		return ctx.Err()
+	}
+
+	return ErrCryptoFailure
+}
+
+// VerifyMulti validates (one of the multiple) signatures on the object and
+// returns the index of the signature that was verified, along with the signature
+// object and the payload. We return the signature and index to guarantee that
+// callers are getting the verified value.
+func (obj JSONWebSignature) VerifyMulti(verificationKey interface{}) (int, Signature, []byte, error) {
+	idx, sig, err := obj.DetachedVerifyMulti(obj.payload, verificationKey)
+	if err != nil {
+		return -1, Signature{}, nil, err
+	}
+	return idx, sig, obj.payload, nil
+}
+
+// DetachedVerifyMulti validates a detached signature on the given payload with
+// a signature/object that has potentially multiple signers. This returns the index
+// of the signature that was verified, along with the signature object. We return
+// the signature and index to guarantee that callers are getting the verified value.
+//
+// In most cases, you will probably want to use Verify or VerifyMulti instead.
+// DetachedVerifyMulti is only useful if you have a payload and signature that are
+// separated from each other, and the signature can have multiple signers at the
+// same time.
+func (obj JSONWebSignature) DetachedVerifyMulti(payload []byte, verificationKey interface{}) (int, Signature, error) {
+	if err := validateRSAKeySize(verificationKey, obj.minimumRSAKeySize); err != nil {
+		return -1, Signature{}, err
+	}
+
+	verifier, err := newVerifier(verificationKey)
+	if err != nil {
+		return -1, Signature{}, err
+	}
+	applyVerifierPSSSaltLength(verifier, obj.pssSaltLength)
+
+outer:
+	for i, signature := range obj.Signatures {
+		if err := validateExpectedType(signature.protected, obj.expectedType); err != nil {
+			continue
+		}
+
+		headers := signature.mergedHeaders()
+		critical, err := headers.getCritical()
+		if err != nil {
+			continue
+		}
+
+		for _, name := range critical {
+			if !supportedCritical[name] && !criticalUnderstood(obj.understoodCritical, name) {
+				continue outer
+			}
+		}
+
+		if headers.getSignatureAlgorithm() == noneAlgorithm {
+			if obj.allowUnsignedTokens && len(signature.Signature) == 0 {
+				return i, signature, nil
+			}
+			continue
+		}
+
+		if !obj.signatureAlgorithmAllowed(headers.getSignatureAlgorithm()) {
+			continue
+		}
+
+		input, err := obj.computeAuthData(payload, &signature)
+		if err != nil {
+			continue
+		}
+
+		alg := headers.getSignatureAlgorithm()
+		err = verifier.verifyPayload(input, signature.Signature, alg)
+		if err == nil {
+			return i, signature, nil
+		}
+	}
+
+	return -1, Signature{}, ErrCryptoFailure
+}
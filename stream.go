@@ -0,0 +1,331 @@
+/*-
+ * Copyright 2026 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jose
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// This file implements a chunked streaming encryption mode built on
+// AES-256-GCM. It is an extension specific to this library and is NOT part of
+// the JWE specification (RFC 7516); the output is a binary framed format, not
+// a JWE object. Use it when the plaintext is too large to hold in memory and
+// both ends agree on this format.
+//
+// The stream starts with a fixed header:
+//
+//	magic (4 bytes) || segment size (4 bytes, big-endian) || base nonce (12 bytes)
+//
+// followed by one or more sealed segments, each framed as:
+//
+//	ciphertext length (4 bytes, big-endian) || ciphertext (plaintext + GCM tag)
+//
+// Every segment is sealed with a distinct nonce derived by XOR-ing a
+// big-endian segment counter into the last eight bytes of the base nonce, and
+// with a one-byte additional-data value that marks whether the segment is the
+// final one. The counter prevents segment reordering and the final-segment
+// marker prevents truncation: a stream that ends before an authenticated
+// final segment is rejected.
+
+const (
+	streamMagic              = "GJS1"
+	streamKeySize            = 32
+	streamNonceSize          = 12
+	streamDefaultSegmentSize = 64 * 1024
+	streamMaxSegmentSize     = 1 << 24
+)
+
+// Per-segment additional data distinguishing the final segment from all
+// others, so that a truncated stream fails to authenticate.
+var (
+	streamSegmentAAD      = []byte{0}
+	streamFinalSegmentAAD = []byte{1}
+)
+
+// streamEncrypter encrypts a stream segment by segment, implementing
+// io.WriteCloser.
+type streamEncrypter struct {
+	writer      io.Writer
+	aead        cipher.AEAD
+	baseNonce   []byte
+	segmentSize int
+	buffer      []byte
+	counter     uint64
+	wroteHeader bool
+	closed      bool
+}
+
+// NewStreamEncrypter creates an io.WriteCloser that encrypts everything
+// written to it with AES-256-GCM in this library's chunked streaming format,
+// writing the framed output to the given writer. The key must be 32 bytes.
+// A segmentSize of zero selects the default of 64 KiB; memory usage is
+// proportional to the segment size, not the total stream length.
+//
+// The stream is not complete until Close is called: closing seals any
+// buffered plaintext and writes the final, truncation-protecting segment.
+//
+// This format is an extension specific to this library and is not
+// interoperable JWE; see NewStreamReader for the decrypting side.
+func NewStreamEncrypter(writer io.Writer, key []byte, segmentSize int) (io.WriteCloser, error) {
+	if len(key) != streamKeySize {
+		return nil, fmt.Errorf("square/go-jose: invalid stream key size, expected %d bytes got %d", streamKeySize, len(key))
+	}
+	if segmentSize == 0 {
+		segmentSize = streamDefaultSegmentSize
+	}
+	if segmentSize < 0 || segmentSize > streamMaxSegmentSize {
+		return nil, fmt.Errorf("square/go-jose: invalid stream segment size %d", segmentSize)
+	}
+
+	aead, err := newStreamAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	baseNonce := make([]byte, streamNonceSize)
+	_, err = io.ReadFull(RandReader, baseNonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return &streamEncrypter{
+		writer:      writer,
+		aead:        aead,
+		baseNonce:   baseNonce,
+		segmentSize: segmentSize,
+		buffer:      make([]byte, 0, segmentSize),
+	}, nil
+}
+
+func newStreamAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// streamSegmentNonce derives the nonce for a given segment by XOR-ing the
+// big-endian counter into the last eight bytes of the base nonce.
+func streamSegmentNonce(baseNonce []byte, counter uint64) []byte {
+	nonce := make([]byte, streamNonceSize)
+	copy(nonce, baseNonce)
+	var ctr [8]byte
+	binary.BigEndian.PutUint64(ctr[:], counter)
+	for i := 0; i < 8; i++ {
+		nonce[streamNonceSize-8+i] ^= ctr[i]
+	}
+	return nonce
+}
+
+func (se *streamEncrypter) writeHeader() error {
+	header := make([]byte, 0, len(streamMagic)+4+streamNonceSize)
+	header = append(header, streamMagic...)
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(se.segmentSize))
+	header = append(header, size[:]...)
+	header = append(header, se.baseNonce...)
+
+	_, err := se.writer.Write(header)
+	if err == nil {
+		se.wroteHeader = true
+	}
+	return err
+}
+
+func (se *streamEncrypter) sealSegment(plaintext []byte, final bool) error {
+	if !se.wroteHeader {
+		err := se.writeHeader()
+		if err != nil {
+			return err
+		}
+	}
+	if se.counter == ^uint64(0) {
+		return fmt.Errorf("square/go-jose: stream segment counter exhausted")
+	}
+
+	aad := streamSegmentAAD
+	if final {
+		aad = streamFinalSegmentAAD
+	}
+
+	nonce := streamSegmentNonce(se.baseNonce, se.counter)
+	se.counter++
+
+	ciphertext := se.aead.Seal(nil, nonce, plaintext, aad)
+
+	var frame [4]byte
+	binary.BigEndian.PutUint32(frame[:], uint32(len(ciphertext)))
+	_, err := se.writer.Write(frame[:])
+	if err != nil {
+		return err
+	}
+	_, err = se.writer.Write(ciphertext)
+	return err
+}
+
+func (se *streamEncrypter) Write(p []byte) (int, error) {
+	if se.closed {
+		return 0, fmt.Errorf("square/go-jose: write to closed stream encrypter")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		space := se.segmentSize - len(se.buffer)
+		if space > len(p) {
+			space = len(p)
+		}
+		se.buffer = append(se.buffer, p[:space]...)
+		p = p[space:]
+		written += space
+
+		if len(se.buffer) == se.segmentSize {
+			err := se.sealSegment(se.buffer, false)
+			if err != nil {
+				return written, err
+			}
+			se.buffer = se.buffer[:0]
+		}
+	}
+	return written, nil
+}
+
+// Close seals any buffered plaintext into the final segment and completes the
+// stream. A final segment is always written, even when empty, so that readers
+// can distinguish a complete stream from a truncated one.
+func (se *streamEncrypter) Close() error {
+	if se.closed {
+		return nil
+	}
+	se.closed = true
+	return se.sealSegment(se.buffer, true)
+}
+
+// streamReader decrypts a stream produced by a streamEncrypter, implementing
+// io.Reader.
+type streamReader struct {
+	reader      io.Reader
+	aead        cipher.AEAD
+	baseNonce   []byte
+	segmentSize int
+	plaintext   []byte
+	counter     uint64
+	finished    bool
+}
+
+// NewStreamReader creates an io.Reader that decrypts a stream produced by
+// NewStreamEncrypter with the same key, reading the framed ciphertext from
+// the given reader. The stream header is consumed immediately. Any tampered,
+// reordered or truncated segment causes a subsequent Read to fail; the
+// stream is only authentic if Read eventually returns io.EOF.
+func NewStreamReader(reader io.Reader, key []byte) (io.Reader, error) {
+	if len(key) != streamKeySize {
+		return nil, fmt.Errorf("square/go-jose: invalid stream key size, expected %d bytes got %d", streamKeySize, len(key))
+	}
+
+	aead, err := newStreamAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, len(streamMagic)+4+streamNonceSize)
+	_, err = io.ReadFull(reader, header)
+	if err != nil {
+		return nil, fmt.Errorf("square/go-jose: invalid stream header: %v", err)
+	}
+	if string(header[:len(streamMagic)]) != streamMagic {
+		return nil, fmt.Errorf("square/go-jose: invalid stream header: bad magic")
+	}
+
+	segmentSize := int(binary.BigEndian.Uint32(header[len(streamMagic) : len(streamMagic)+4]))
+	if segmentSize <= 0 || segmentSize > streamMaxSegmentSize {
+		return nil, fmt.Errorf("square/go-jose: invalid stream segment size %d", segmentSize)
+	}
+
+	baseNonce := make([]byte, streamNonceSize)
+	copy(baseNonce, header[len(streamMagic)+4:])
+
+	return &streamReader{
+		reader:      reader,
+		aead:        aead,
+		baseNonce:   baseNonce,
+		segmentSize: segmentSize,
+	}, nil
+}
+
+// readSegment reads and authenticates the next segment, filling the
+// plaintext buffer.
+func (sr *streamReader) readSegment() error {
+	var frame [4]byte
+	_, err := io.ReadFull(sr.reader, frame[:])
+	if err != nil {
+		// Running out of input before an authenticated final segment means
+		// the stream was truncated.
+		return fmt.Errorf("square/go-jose: truncated stream: %v", err)
+	}
+
+	length := int(binary.BigEndian.Uint32(frame[:]))
+	if length < sr.aead.Overhead() || length > sr.segmentSize+sr.aead.Overhead() {
+		return fmt.Errorf("square/go-jose: invalid stream segment length %d", length)
+	}
+
+	ciphertext := make([]byte, length)
+	_, err = io.ReadFull(sr.reader, ciphertext)
+	if err != nil {
+		return fmt.Errorf("square/go-jose: truncated stream: %v", err)
+	}
+
+	// The final segment is the only one shorter than the segment size; try
+	// the matching additional data first, but fall back to the other marker
+	// so that a full-size final segment is still accepted.
+	nonce := streamSegmentNonce(sr.baseNonce, sr.counter)
+	plaintext, err := sr.aead.Open(nil, nonce, ciphertext, streamSegmentAAD)
+	if err != nil {
+		plaintext, err = sr.aead.Open(nil, nonce, ciphertext, streamFinalSegmentAAD)
+		if err != nil {
+			return ErrCryptoFailure
+		}
+		sr.finished = true
+	}
+	sr.counter++
+
+	sr.plaintext = plaintext
+	return nil
+}
+
+func (sr *streamReader) Read(p []byte) (int, error) {
+	for len(sr.plaintext) == 0 {
+		if sr.finished {
+			return 0, io.EOF
+		}
+		err := sr.readSegment()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, sr.plaintext)
+	sr.plaintext = sr.plaintext[n:]
+	if len(sr.plaintext) == 0 && sr.finished {
+		return n, io.EOF
+	}
+	return n, nil
+}
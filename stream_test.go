@@ -0,0 +1,178 @@
+/*-
+ * Copyright 2026 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jose
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	mrand "math/rand"
+	"testing"
+)
+
+func streamTestKey(t *testing.T) []byte {
+	key := make([]byte, streamKeySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestStreamRoundtrip(t *testing.T) {
+	key := streamTestKey(t)
+
+	// 50MB of deterministic pseudo-random plaintext, large enough to span
+	// many segments.
+	plaintext := make([]byte, 50*1024*1024)
+	mrand.New(mrand.NewSource(42)).Read(plaintext)
+
+	segmentSizes := []int{0, 4096, 1024 * 1024}
+
+	for _, segmentSize := range segmentSizes {
+		out := new(bytes.Buffer)
+		encrypter, err := NewStreamEncrypter(out, key, segmentSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = io.Copy(encrypter, bytes.NewReader(plaintext))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = encrypter.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reader, err := NewStreamReader(bytes.NewReader(out.Bytes()), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Errorf("error reading stream with segment size %d: %v", segmentSize, err)
+			continue
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("stream with segment size %d did not round-trip", segmentSize)
+		}
+	}
+}
+
+func TestStreamEmptyPlaintext(t *testing.T) {
+	key := streamTestKey(t)
+
+	out := new(bytes.Buffer)
+	encrypter, err := NewStreamEncrypter(out, key, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encrypter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := NewStreamReader(bytes.NewReader(out.Bytes()), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decrypted) != 0 {
+		t.Errorf("expected empty plaintext, got %d bytes", len(decrypted))
+	}
+}
+
+func TestStreamCorruptedSegment(t *testing.T) {
+	key := streamTestKey(t)
+
+	plaintext := make([]byte, 256*1024)
+	mrand.New(mrand.NewSource(1)).Read(plaintext)
+
+	out := new(bytes.Buffer)
+	encrypter, err := NewStreamEncrypter(out, key, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = encrypter.Write(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encrypter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flip one bit somewhere in the middle of the ciphertext
+	corrupted := make([]byte, out.Len())
+	copy(corrupted, out.Bytes())
+	corrupted[len(corrupted)/2] ^= 0x40
+
+	reader, err := NewStreamReader(bytes.NewReader(corrupted), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(reader)
+	if err == nil {
+		t.Error("reading a stream with a corrupted segment must fail")
+	}
+
+	// Dropping the final segment (an empty plaintext here, so just the frame
+	// length and the GCM tag) must be detected as truncation
+	frames := out.Bytes()
+	truncated := frames[:len(frames)-(4+16)]
+
+	reader, err = NewStreamReader(bytes.NewReader(truncated), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(reader)
+	if err == nil {
+		t.Error("reading a truncated stream must fail")
+	}
+
+	// Wrong key must fail on the first segment
+	reader, err = NewStreamReader(bytes.NewReader(out.Bytes()), streamTestKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(reader)
+	if err != ErrCryptoFailure {
+		t.Errorf("expected ErrCryptoFailure with wrong key, got '%v'", err)
+	}
+}
+
+func TestStreamInvalidParameters(t *testing.T) {
+	_, err := NewStreamEncrypter(new(bytes.Buffer), make([]byte, 16), 0)
+	if err == nil {
+		t.Error("stream encrypter accepted a 16-byte key")
+	}
+
+	_, err = NewStreamEncrypter(new(bytes.Buffer), make([]byte, streamKeySize), streamMaxSegmentSize+1)
+	if err == nil {
+		t.Error("stream encrypter accepted an oversized segment size")
+	}
+
+	_, err = NewStreamReader(bytes.NewReader([]byte("not a stream header")), make([]byte, streamKeySize))
+	if err == nil {
+		t.Error("stream reader accepted a bad header")
+	}
+}
@@ -0,0 +1,334 @@
+/*-
+ * Copyright 2014 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jose
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func TestInvalidSymmetricAlgorithms(t *testing.T) {
+	_, err := newSymmetricRecipient("XYZ", []byte{})
+	if err != ErrUnsupportedAlgorithm {
+		t.Error("should not accept invalid algorithm")
+	}
+
+	enc := &symmetricKeyCipher{}
+	_, err = enc.encryptKey([]byte{}, "XYZ")
+	if err != ErrUnsupportedAlgorithm {
+		t.Error("should not accept invalid algorithm")
+	}
+}
+
+func TestAeadErrors(t *testing.T) {
+	aead := &aeadContentCipher{
+		keyBytes:     16,
+		authtagBytes: 16,
+		getAead: func(key []byte) (cipher.AEAD, error) {
+			return nil, ErrCryptoFailure
+		},
+	}
+
+	parts, err := aead.encrypt([]byte{}, []byte{}, []byte{})
+	if err != ErrCryptoFailure {
+		t.Error("should handle aead failure")
+	}
+
+	_, err = aead.decrypt([]byte{}, []byte{}, parts)
+	if err != ErrCryptoFailure {
+		t.Error("should handle aead failure")
+	}
+}
+
+func TestInvalidKey(t *testing.T) {
+	gcm := newAESGCM(16).(*aeadContentCipher)
+	_, err := gcm.getAead([]byte{})
+	if err == nil {
+		t.Error("should not accept invalid key")
+	}
+}
+
+func TestStaticKeyGen(t *testing.T) {
+	key := make([]byte, 32)
+	io.ReadFull(rand.Reader, key)
+
+	gen := &staticKeyGenerator{key: key}
+	if gen.keySize() != len(key) {
+		t.Error("static key generator reports incorrect size")
+	}
+
+	generated, _, err := gen.genKey()
+	if err != nil {
+		t.Error("static key generator should always succeed", err)
+	}
+	if !bytes.Equal(generated, key) {
+		t.Error("static key generator returns different data")
+	}
+}
+
+func TestAeadInvalidInput(t *testing.T) {
+	sample := []byte("1234567890123456")
+	tt := []aeadParts{
+		{},
+		{iv: sample, tag: sample},
+	}
+	for _, tc := range tt {
+		aead := newAESGCM(16).(*aeadContentCipher)
+		_, err := aead.decrypt(sample, []byte{}, &tc)
+		if err != ErrCryptoFailure {
+			t.Error("should handle aead failure")
+		}
+	}
+}
+
+func TestVectorsAESGCM(t *testing.T) {
+	// Source: http://tools.ietf.org/html/draft-ietf-jose-json-web-encryption-29#appendix-A.1
+	plaintext := []byte{
+		84, 104, 101, 32, 116, 114, 117, 101, 32, 115, 105, 103, 110, 32,
+		111, 102, 32, 105, 110, 116, 101, 108, 108, 105, 103, 101, 110, 99,
+		101, 32, 105, 115, 32, 110, 111, 116, 32, 107, 110, 111, 119, 108,
+		101, 100, 103, 101, 32, 98, 117, 116, 32, 105, 109, 97, 103, 105,
+		110, 97, 116, 105, 111, 110, 46}
+
+	aad := []byte{
+		101, 121, 74, 104, 98, 71, 99, 105, 79, 105, 74, 83, 85, 48, 69,
+		116, 84, 48, 70, 70, 85, 67, 73, 115, 73, 109, 86, 117, 89, 121, 73,
+		54, 73, 107, 69, 121, 78, 84, 90, 72, 81, 48, 48, 105, 102, 81}
+
+	expectedCiphertext := []byte{
+		229, 236, 166, 241, 53, 191, 115, 196, 174, 43, 73, 109, 39, 122,
+		233, 96, 140, 206, 120, 52, 51, 237, 48, 11, 190, 219, 186, 80, 111,
+		104, 50, 142, 47, 167, 59, 61, 181, 127, 196, 21, 40, 82, 242, 32,
+		123, 143, 168, 226, 73, 216, 176, 144, 138, 247, 106, 60, 16, 205,
+		160, 109, 64, 63, 192}
+
+	expectedAuthtag := []byte{
+		92, 80, 104, 49, 133, 25, 161, 215, 173, 101, 219, 211, 136, 91, 210, 145}
+
+	withMockedRandom(t,
+		"b1a1f480548fe1733fb403ff6b9ad4f68a076e5b702e22692f82cb2e7aea40fc",
+		"",
+		"e3c575fc02dbe944b4e14ddb",
+		func() {
+			enc := newAESGCM(32)
+			key, _, _ := randomKeyGenerator{size: 32}.genKey()
+			out, err := enc.encrypt(key, aad, plaintext)
+			if err != nil {
+				t.Error("Unable to encrypt:", err)
+				return
+			}
+
+			if bytes.Compare(out.ciphertext, expectedCiphertext) != 0 {
+				t.Error("Ciphertext did not match")
+			}
+			if bytes.Compare(out.tag, expectedAuthtag) != 0 {
+				t.Error("Auth tag did not match")
+			}
+		})
+}
+
+func TestVectorAESCBCHS256RFC7516AppendixA2(t *testing.T) {
+	// Known-answer test for the A128CBC-HS256 content encryption from
+	// RFC 7516, Appendix A.2, with the content key and initialization
+	// vector taken from the mocked random stream.
+	plaintext := []byte("Live long and prosper.")
+
+	// Additional authenticated data, the base64url-encoded protected header
+	// {"alg":"RSA1_5","enc":"A128CBC-HS256"}
+	aad := []byte("eyJhbGciOiJSU0ExXzUiLCJlbmMiOiJBMTI4Q0JDLUhTMjU2In0")
+
+	expectedCiphertext := []byte{
+		40, 57, 83, 181, 119, 33, 133, 148, 198, 185, 243, 24, 152, 230, 6,
+		75, 129, 223, 127, 19, 210, 82, 183, 230, 168, 33, 215, 104, 143,
+		112, 56, 102}
+
+	expectedAuthtag := []byte{
+		246, 17, 244, 190, 4, 95, 98, 3, 231, 0, 115, 157, 242, 203, 100, 191}
+
+	withMockedRandom(t,
+		"04d31fc5549dfcfe0b649dfa3faa6ace6b7cd42d6f6b09dbc8b100f08f9c2ccf",
+		"",
+		"03163c0c2b4368696c6c69636f746865",
+		func() {
+			enc := getContentCipher(A128CBC_HS256)
+			key, _, _ := randomKeyGenerator{size: enc.keySize()}.genKey()
+			out, err := enc.encrypt(key, aad, plaintext)
+			if err != nil {
+				t.Error("Unable to encrypt:", err)
+				return
+			}
+
+			if bytes.Compare(out.ciphertext, expectedCiphertext) != 0 {
+				t.Error("Ciphertext did not match")
+			}
+			if bytes.Compare(out.tag, expectedAuthtag) != 0 {
+				t.Error("Auth tag did not match")
+			}
+		})
+}
+
+func TestVectorPBES2_HS256A_128KW(t *testing.T) {
+	cipher := &symmetricKeyCipher{
+		key: []byte("Thus from my lips, by yours, my sin is purged."),
+		p2c: 4096,
+		p2s: []byte{
+			217, 96, 147, 112, 150, 117, 70,
+			247, 127, 8, 155, 137, 174, 42, 80, 215,
+		},
+	}
+
+	cek := []byte{
+		111, 27, 25, 52, 66, 29, 20, 78, 92, 176, 56, 240, 65, 208, 82, 112,
+		161, 131, 36, 55, 202, 236, 185, 172, 129, 23, 153, 194, 195, 48,
+		253, 182,
+	}
+
+	// PBES2-HS256+A128KW || 0x00 || p2s
+	salt := []byte{
+		80, 66, 69, 83, 50, 45, 72, 83, 50, 53, 54, 43, 65, 49, 50, 56, 75,
+		87, 0, 217, 96, 147, 112, 150, 117, 70, 247, 127, 8, 155, 137, 174,
+		42, 80, 215,
+	}
+
+	expectedDerivedKey := []byte{
+		110, 171, 169, 92, 129, 92, 109, 117, 233, 242, 116, 233, 170, 14,
+		24, 75}
+
+	expectedEncryptedKey := []byte{
+		78, 186, 151, 59, 11, 141, 81, 240, 213, 245, 83, 211, 53, 188, 134,
+		188, 66, 125, 36, 200, 222, 124, 5, 103, 249, 52, 117, 184, 140, 81,
+		246, 158, 161, 177, 20, 33, 245, 57, 59, 4}
+
+	derivedKey := pbkdf2.Key(cipher.key, salt, cipher.p2c, 16, sha256.New)
+	if bytes.Compare(derivedKey, expectedDerivedKey) != 0 {
+		t.Error("Derived key did not match")
+	}
+
+	encryptedKey, err := cipher.encryptKey(cek, PBES2_HS256_A128KW)
+	if err != nil {
+		t.Fatal("Unable to encrypt:", err)
+	}
+
+	if bytes.Compare(encryptedKey.encryptedKey, expectedEncryptedKey) != 0 {
+		t.Error("Encrypted key did not match")
+	}
+}
+
+func TestPBES2IterationCountTooHigh(t *testing.T) {
+	cipher := &symmetricKeyCipher{
+		key: []byte("Thus from my lips, by yours, my sin is purged."),
+	}
+
+	// The "p2c" header comes from the (not yet authenticated) token, so an
+	// excessive value must be rejected before running the key derivation.
+	headers := rawHeader{}
+	headers.set(headerAlgorithm, PBES2_HS256_A128KW)
+	headers.set(headerP2C, defaultMaxP2C+1)
+	headers.set(headerP2S, newBuffer([]byte{
+		217, 96, 147, 112, 150, 117, 70,
+		247, 127, 8, 155, 137, 174, 42, 80, 215,
+	}))
+
+	_, err := cipher.decryptKey(headers, &recipientInfo{}, randomKeyGenerator{size: 32})
+	if err == nil {
+		t.Error("Decryption should have failed with excessive p2c")
+	}
+}
+
+func TestVectorXChaCha20Poly1305(t *testing.T) {
+	// Source: https://tools.ietf.org/html/draft-irtf-cfrg-xchacha-03#appendix-A.3
+	key, _ := hex.DecodeString("808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f")
+	nonce, _ := hex.DecodeString("404142434445464748494a4b4c4d4e4f5051525354555657")
+	aad, _ := hex.DecodeString("50515253c0c1c2c3c4c5c6c7")
+	plaintext := []byte("Ladies and Gentlemen of the class of '99: If you could offer you only one tip for the future, sunscreen would be it.")
+
+	expectedCiphertext, _ := hex.DecodeString(
+		"bd6d179d3e83d43b9576579493c0e939572a1700252bfaccbed2902c21396cbb" +
+			"731c7f1b0b4aa6440bf3a82f4eea312fe172c561c05f841fc882f2255d1ffb48" +
+			"618f8ce81bfbd30ba0526df0948eafd13977bd63c03c5a8f70a266703a86e2ff" +
+			"2cf8605b9c2664e6748b03ba1383c91fe169be1d")
+	expectedAuthtag, _ := hex.DecodeString("e538276b20026d4154e9f25a029dec58")
+
+	// Mock random reader so the generated nonce matches the vector
+	RandReader = bytes.NewReader(nonce)
+	defer resetRandReader()
+
+	enc := newXChaCha20Poly1305()
+	out, err := enc.encrypt(key, aad, plaintext)
+	if err != nil {
+		t.Fatal("unable to encrypt:", err)
+	}
+
+	if !bytes.Equal(out.iv, nonce) {
+		t.Error("nonce should be the 24 bytes drawn from the random source")
+	}
+	if !bytes.Equal(out.ciphertext, expectedCiphertext) {
+		t.Error("ciphertext did not match")
+	}
+	if !bytes.Equal(out.tag, expectedAuthtag) {
+		t.Error("auth tag did not match")
+	}
+
+	recovered, err := enc.decrypt(key, aad, out)
+	if err != nil {
+		t.Fatal("unable to decrypt:", err)
+	}
+	if !bytes.Equal(recovered, plaintext) {
+		t.Error("decrypted plaintext did not match")
+	}
+}
+
+func TestAESKeyWrapKEKSize(t *testing.T) {
+	shortKey := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	longKey := append(append([]byte{}, shortKey...), shortKey...)
+
+	// The KEK length must match the algorithm on the wrap side.
+	_, err := NewEncrypter(A128GCM, Recipient{Algorithm: A128KW, Key: longKey}, nil)
+	if !errors.Is(err, ErrInvalidKeySize) {
+		t.Errorf("expected errors.Is(err, ErrInvalidKeySize), got: %v", err)
+	}
+
+	// ... and on the unwrap side.
+	enc, err := NewEncrypter(A128GCM, Recipient{Algorithm: A256KW, Key: longKey}, nil)
+	if err != nil {
+		t.Fatal("error creating encrypter:", err)
+	}
+	obj, err := enc.Encrypt([]byte("Lorem ipsum dolor sit amet"))
+	if err != nil {
+		t.Fatal("error on encrypt:", err)
+	}
+	if _, err := obj.Decrypt(shortKey); !errors.Is(err, ErrInvalidKeySize) {
+		t.Errorf("expected errors.Is(err, ErrInvalidKeySize), got: %v", err)
+	}
+
+	// Matching KEK round trips.
+	output, err := obj.Decrypt(longKey)
+	if err != nil {
+		t.Fatal("error on decrypt:", err)
+	}
+	if !bytes.Equal(output, []byte("Lorem ipsum dolor sit amet")) {
+		t.Error("decrypted wrong plaintext")
+	}
+}
@@ -0,0 +1,446 @@
+/*-
+ * Copyright 2019 Square Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jose
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"fmt"
+	"testing"
+)
+
+// Worked examples for the EMV 3-D Secure SDK/ACS challenge flow. Examples 7
+// and 8 derive the session key from both sides of the ephemeral
+// Diffie-Hellman exchange using the 3DS2 derivation profile (empty algorithm
+// ID and partyUInfo, directory server ID as partyVInfo). Examples 9-12 use
+// the custom derivation and the derived session keys to encrypt and decrypt
+// CReq/CRes messages with A128CBC-HS256 and A128GCM.
+
+const threeDSDirectoryServerID = "A000000802"
+
+var threeDSSDKKey = &ecdsa.PrivateKey{
+	PublicKey: ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     fromBase64Int("qWm8GiKvWKgco2VmslVJB7hUFqReHVTTWNUzAxe4dn8"),
+		Y:     fromBase64Int("sOBSCbWns_bx_z1r4l9lZWUcVfva8BHJ_aePBuJxk1M"),
+	},
+	D: fromBase64Int("C9Sjh1IXcpCSNtRh2TZVxnIjTY7vxLQONzpBNeIRJSQ"),
+}
+
+var threeDSACSKey = &ecdsa.PrivateKey{
+	PublicKey: ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     fromBase64Int("vN9Wtye3Du20TTvwYUcOn76ZvCLIvlGZUAE2fGgZU24"),
+		Y:     fromBase64Int("PMz-fYT76nPBKs_KDklajqnBUxW_NMvEuxk6mMbTm-c"),
+	},
+	D: fromBase64Int("AhN9eeYPy7rLJQbFCrUnT6tBcmIhPXCxMrTLbKHR83g"),
+}
+
+var threeDSSessionKey = fromHexBytes("bb59353047fd9ed89798c20d072420c12b72ec335a781e9a4cea3739003d62c0")
+
+const threeDSCReq = `{"messageType":"CReq","messageVersion":"2.1.0","acsTransID":"6d2071d2-a1d4-4a40-a77a-51ba32bdd5f0","challengeWindowSize":"05"}`
+const threeDSCRes = `{"messageType":"CRes","messageVersion":"2.1.0","acsTransID":"6d2071d2-a1d4-4a40-a77a-51ba32bdd5f0","transStatus":"Y"}`
+
+// Example 7: the ACS derives the session key from its ephemeral private key
+// and the SDK ephemeral public key. The apu/apv arguments carried in the JWE
+// headers are ignored by the 3DS2 profile.
+func TestExample7(t *testing.T) {
+	derive := createCustomDeriveECDHES(threeDSDirectoryServerID, 1)
+
+	out := derive("A128CBC-HS256", []byte("ignored-apu"), []byte("ignored-apv"),
+		threeDSACSKey, &threeDSSDKKey.PublicKey, 32)
+
+	if !bytes.Equal(out, threeDSSessionKey) {
+		t.Errorf("ACS session key does not match, got %x, wanted %x", out, threeDSSessionKey)
+	}
+}
+
+// Example 8: the SDK derives the same session key from the other side of the
+// exchange.
+func TestExample8(t *testing.T) {
+	derive := createCustomDeriveECDHES(threeDSDirectoryServerID, 1)
+
+	out := derive("A128CBC-HS256", nil, nil, threeDSSDKKey, &threeDSACSKey.PublicKey, 32)
+
+	if !bytes.Equal(out, threeDSSessionKey) {
+		t.Errorf("SDK session key does not match, got %x, wanted %x", out, threeDSSessionKey)
+	}
+}
+
+// Deriving key number N selects block N of the Concat KDF output. Counter
+// one matches the session-key values from examples 7 and 8 from either side
+// of the exchange; counter two yields the next deterministic session key.
+func TestSessionKeyCounter(t *testing.T) {
+	keyTwo := fromHexBytes("0f4b8534379013c0054bd39605da911e8cfe4ca05861b188a476541914300e26")
+
+	vectors := []struct {
+		counter  int
+		expected []byte
+	}{
+		{1, threeDSSessionKey},
+		{2, keyTwo},
+	}
+
+	for _, vector := range vectors {
+		derive := NewDirectoryServerECDHESWithCounter(threeDSDirectoryServerID, vector.counter)
+
+		acsOut := derive("A128CBC-HS256", nil, nil, threeDSACSKey, &threeDSSDKKey.PublicKey, 32)
+		sdkOut := derive("A128CBC-HS256", nil, nil, threeDSSDKKey, &threeDSACSKey.PublicKey, 32)
+
+		if !bytes.Equal(acsOut, vector.expected) {
+			t.Errorf("ACS key %d does not match, got %x, wanted %x", vector.counter, acsOut, vector.expected)
+		}
+		if !bytes.Equal(sdkOut, vector.expected) {
+			t.Errorf("SDK key %d does not match, got %x, wanted %x", vector.counter, sdkOut, vector.expected)
+		}
+	}
+}
+
+func TestSessionKeyCounterTooLow(t *testing.T) {
+	defer func() { recover() }()
+
+	NewDirectoryServerECDHESWithCounter(threeDSDirectoryServerID, 0)
+	t.Fatal("should panic if counter was less than one")
+}
+
+// Example 9: the SDK encrypts a CReq to the ACS with ECDH-ES and
+// A128CBC-HS256 using the custom derivation; the ACS decrypts it with
+// DecryptWithCustomCek. A decrypt with the standard JWA derivation must fail.
+func TestExample9(t *testing.T) {
+	opts := (&EncrypterOptions{}).WithCustomDeriveECDHES(
+		createCustomDeriveECDHES(threeDSDirectoryServerID, 1))
+
+	enc, err := NewEncrypter(A128CBC_HS256, Recipient{Algorithm: ECDH_ES, Key: &threeDSACSKey.PublicKey}, opts)
+	if err != nil {
+		t.Fatal("error on new encrypter:", err)
+	}
+
+	obj, err := enc.Encrypt([]byte(threeDSCReq))
+	if err != nil {
+		t.Fatal("error on encrypt:", err)
+	}
+
+	msg, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal("error on serialize:", err)
+	}
+
+	parsed, err := ParseEncrypted(msg)
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+
+	output, err := parsed.DecryptWithCustomCek(threeDSACSKey,
+		createCustomDeriveECDHES(threeDSDirectoryServerID, 1))
+	if err != nil {
+		t.Fatal("error on decrypt:", err)
+	}
+
+	if string(output) != threeDSCReq {
+		t.Errorf("decrypted CReq does not match, got '%s'", output)
+	}
+
+	if _, err = parsed.Decrypt(threeDSACSKey); err == nil {
+		t.Error("decrypt with the standard derivation should have failed")
+	}
+}
+
+// Example 10: a fixed CReq produced with the custom derivation decrypts to
+// the expected message, pinning the serialized bytes.
+func TestExample10(t *testing.T) {
+	const msg = "eyJhbGciOiJFQ0RILUVTIiwiZW5jIjoiQTEyOENCQy1IUzI1NiIsImVwayI6eyJrdHkiOiJFQyIsImNydiI6IlAtMjU2IiwieCI6IkVKREVDVW9FUUpXLUFHT0hQcTM2RWdqYXpKZzFQaHg1dzlobjdTejdwYWciLCJ5IjoiZXRGcEdHd3BBZkd2aTN1b3lGUVRINmlmOXRIMmpEeEM1R2ozY0JYUHh3ZyJ9fQ..66WT0_iesb5eaya4MlbwtA.nZ06D6gzzRI--N8wVw-y2_DOmgM7jRks_0-ebP6sRxe12CW3yBZ15gfnt4MgH1Q7f0MdS9sGA8jZgsgXsTZUFeG3VYLiN5oMq4Arx5hrsczqqVTF5Q1pwbTSnTHozh71K3H7gBlNfthHogyA694M8o2QKpOS0-fBJu61HemHACw.TERLyyRLMgkk6xsOxsGlIg"
+
+	parsed, err := ParseEncrypted(msg)
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+
+	output, err := parsed.DecryptWithCustomCek(threeDSACSKey,
+		createCustomDeriveECDHES(threeDSDirectoryServerID, 1))
+	if err != nil {
+		t.Fatal("error on decrypt:", err)
+	}
+
+	if string(output) != threeDSCReq {
+		t.Errorf("decrypted CReq does not match, got '%s'", output)
+	}
+}
+
+// The custom derivation can be attached once at parse time instead of being
+// passed to every decrypt call; both paths must produce the same plaintext.
+func TestParseEncryptedWithOptionsCustomDerive(t *testing.T) {
+	opts := (&EncrypterOptions{}).WithCustomDeriveECDHES(
+		createCustomDeriveECDHES(threeDSDirectoryServerID, 1))
+
+	enc, err := NewEncrypter(A128CBC_HS256, Recipient{Algorithm: ECDH_ES, Key: &threeDSACSKey.PublicKey}, opts)
+	if err != nil {
+		t.Fatal("error on new encrypter:", err)
+	}
+
+	obj, err := enc.Encrypt([]byte(threeDSCReq))
+	if err != nil {
+		t.Fatal("error on encrypt:", err)
+	}
+
+	msg, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal("error on serialize:", err)
+	}
+
+	parsed, err := ParseEncryptedWithOptions(msg, (&DecrypterOptions{}).WithCustomDeriveECDHES(
+		createCustomDeriveECDHES(threeDSDirectoryServerID, 1)))
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+
+	viaOptions, err := parsed.Decrypt(threeDSACSKey)
+	if err != nil {
+		t.Fatal("error on decrypt with options:", err)
+	}
+
+	viaArgument, err := parsed.DecryptWithCustomCek(threeDSACSKey,
+		createCustomDeriveECDHES(threeDSDirectoryServerID, 1))
+	if err != nil {
+		t.Fatal("error on decrypt with argument:", err)
+	}
+
+	if string(viaOptions) != threeDSCReq || string(viaArgument) != threeDSCReq {
+		t.Errorf("decrypted messages do not match, got '%s' and '%s'", viaOptions, viaArgument)
+	}
+}
+
+// Example 11: the CRes travels in direct mode with A128GCM, keyed with the
+// leftmost 128 bits of the session key. Round-trips and decrypts a fixed
+// token.
+func TestExample11(t *testing.T) {
+	enc, err := NewEncrypter(A128GCM, Recipient{Algorithm: DIRECT, Key: threeDSSessionKey[:16]}, nil)
+	if err != nil {
+		t.Fatal("error on new encrypter:", err)
+	}
+
+	obj, err := enc.Encrypt([]byte(threeDSCRes))
+	if err != nil {
+		t.Fatal("error on encrypt:", err)
+	}
+
+	msg, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal("error on serialize:", err)
+	}
+
+	tokens := []string{
+		msg,
+		"eyJhbGciOiJkaXIiLCJlbmMiOiJBMTI4R0NNIn0..9WfNS43pq5hWtgLB.cA3mUFUPlHtc0eCB2kS657m0-ryMNP03opHk5DDg5Tt375l_WRQegsM5PBKWLBiWTewEoH70rdPTauORVGMjtSvx0jteWKkp8l3wI5JLOfoxf1ebIjZtN7wNXEkaqjuAqe3A-4zFHPDf-zq1h-dyHawvvmqo.RONhXgLYcFxkPFm0LKCoFA",
+	}
+
+	for _, token := range tokens {
+		parsed, err := ParseEncrypted(token)
+		if err != nil {
+			t.Fatal("error on parse:", err)
+		}
+
+		output, err := parsed.Decrypt(threeDSSessionKey[:16])
+		if err != nil {
+			t.Fatal("error on decrypt:", err)
+		}
+
+		if string(output) != threeDSCRes {
+			t.Errorf("decrypted CRes does not match, got '%s'", output)
+		}
+	}
+}
+
+// Example 12: same CRes in direct mode with A128CBC-HS256, keyed with the
+// full 256-bit session key.
+func TestExample12(t *testing.T) {
+	const msg = "eyJhbGciOiJkaXIiLCJlbmMiOiJBMTI4Q0JDLUhTMjU2In0..uOJsxpDiIIU3SnkcicwaUw.Nf9weTEKWWAA5hF7FVe6pm9UyTYuTPMSpyYH5hcZckBWUSWeIOEJxQtkaD1runOGMYZjHg9IoiPSXZxhwsUTS2NfzgjN1NtwLFu6FyaH-LQGE1LgVlN1cs7YYSlAWD4BinCxekPFpps7JnXIyVJZf6hAU2D77jnLkijGdZJYKf0.EyWvcXCPyKsxD9Es-XmELQ"
+
+	parsed, err := ParseEncrypted(msg)
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+
+	output, err := parsed.Decrypt(threeDSSessionKey)
+	if err != nil {
+		t.Fatal("error on decrypt:", err)
+	}
+
+	if string(output) != threeDSCRes {
+		t.Errorf("decrypted CRes does not match, got '%s'", output)
+	}
+
+	enc, err := NewEncrypter(A128CBC_HS256, Recipient{Algorithm: DIRECT, Key: threeDSSessionKey}, nil)
+	if err != nil {
+		t.Fatal("error on new encrypter:", err)
+	}
+
+	obj, err := enc.Encrypt([]byte(threeDSCRes))
+	if err != nil {
+		t.Fatal("error on encrypt:", err)
+	}
+
+	roundtrip, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal("error on serialize:", err)
+	}
+
+	parsed, err = ParseEncrypted(roundtrip)
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+
+	output, err = parsed.Decrypt(threeDSSessionKey)
+	if err != nil {
+		t.Fatal("error on decrypt:", err)
+	}
+
+	if string(output) != threeDSCRes {
+		t.Errorf("round-tripped CRes does not match, got '%s'", output)
+	}
+}
+
+// DirectoryServerSessionKey exposes the counter-based derivation as raw key
+// bytes, matching the session keys from examples 7 and 8 and the per-counter
+// keys from either side of the exchange.
+func TestDirectoryServerSessionKey(t *testing.T) {
+	keyTwo := fromHexBytes("0f4b8534379013c0054bd39605da911e8cfe4ca05861b188a476541914300e26")
+
+	vectors := []struct {
+		counter  int
+		expected []byte
+	}{
+		{1, threeDSSessionKey},
+		{2, keyTwo},
+	}
+
+	for _, vector := range vectors {
+		acsOut := DirectoryServerSessionKey(threeDSDirectoryServerID, vector.counter, threeDSACSKey, &threeDSSDKKey.PublicKey)
+		sdkOut := DirectoryServerSessionKey(threeDSDirectoryServerID, vector.counter, threeDSSDKKey, &threeDSACSKey.PublicKey)
+
+		if !bytes.Equal(acsOut, vector.expected) {
+			t.Errorf("ACS session key %d does not match, got %x, wanted %x", vector.counter, acsOut, vector.expected)
+		}
+		if !bytes.Equal(sdkOut, vector.expected) {
+			t.Errorf("SDK session key %d does not match, got %x, wanted %x", vector.counter, sdkOut, vector.expected)
+		}
+	}
+}
+
+// The session phase helpers take the full 256-bit session key for both
+// content encryptions and size the CEK internally: round-trips a CReq with
+// A128CBC-HS256 and A128GCM and decrypts the fixed CRes tokens from examples
+// 11 and 12 without the caller truncating the key.
+func TestDirectoryServerSession(t *testing.T) {
+	sessionKey := DirectoryServerSessionKey(threeDSDirectoryServerID, 1, threeDSACSKey, &threeDSSDKKey.PublicKey)
+
+	for _, enc := range []ContentEncryption{A128CBC_HS256, A128GCM} {
+		encrypter, err := NewDirectoryServerSessionEncrypter(enc, sessionKey)
+		if err != nil {
+			t.Fatal("error on new encrypter:", err)
+		}
+
+		obj, err := encrypter.Encrypt([]byte(threeDSCReq))
+		if err != nil {
+			t.Fatal("error on encrypt:", err)
+		}
+
+		msg, err := obj.CompactSerialize()
+		if err != nil {
+			t.Fatal("error on serialize:", err)
+		}
+
+		parsed, err := ParseEncrypted(msg)
+		if err != nil {
+			t.Fatal("error on parse:", err)
+		}
+
+		output, err := parsed.DecryptDirectoryServerSession(sessionKey)
+		if err != nil {
+			t.Fatal("error on decrypt:", err)
+		}
+
+		if string(output) != threeDSCReq {
+			t.Errorf("decrypted CReq does not match for %s, got '%s'", enc, output)
+		}
+	}
+
+	fixed := []string{
+		"eyJhbGciOiJkaXIiLCJlbmMiOiJBMTI4R0NNIn0..9WfNS43pq5hWtgLB.cA3mUFUPlHtc0eCB2kS657m0-ryMNP03opHk5DDg5Tt375l_WRQegsM5PBKWLBiWTewEoH70rdPTauORVGMjtSvx0jteWKkp8l3wI5JLOfoxf1ebIjZtN7wNXEkaqjuAqe3A-4zFHPDf-zq1h-dyHawvvmqo.RONhXgLYcFxkPFm0LKCoFA",
+		"eyJhbGciOiJkaXIiLCJlbmMiOiJBMTI4Q0JDLUhTMjU2In0..uOJsxpDiIIU3SnkcicwaUw.Nf9weTEKWWAA5hF7FVe6pm9UyTYuTPMSpyYH5hcZckBWUSWeIOEJxQtkaD1runOGMYZjHg9IoiPSXZxhwsUTS2NfzgjN1NtwLFu6FyaH-LQGE1LgVlN1cs7YYSlAWD4BinCxekPFpps7JnXIyVJZf6hAU2D77jnLkijGdZJYKf0.EyWvcXCPyKsxD9Es-XmELQ",
+	}
+
+	for _, token := range fixed {
+		parsed, err := ParseEncrypted(token)
+		if err != nil {
+			t.Fatal("error on parse:", err)
+		}
+
+		output, err := parsed.DecryptDirectoryServerSession(sessionKey)
+		if err != nil {
+			t.Fatal("error on decrypt:", err)
+		}
+
+		if string(output) != threeDSCRes {
+			t.Errorf("decrypted CRes does not match, got '%s'", output)
+		}
+	}
+}
+
+func TestDirectoryServerSessionRejectsBadInput(t *testing.T) {
+	if _, err := NewDirectoryServerSessionEncrypter(A128CBC_HS256, threeDSSessionKey[:16]); err == nil {
+		t.Error("should reject a session key that is not 32 bytes")
+	}
+
+	if _, err := NewDirectoryServerSessionEncrypter(A256GCM, threeDSSessionKey); err == nil {
+		t.Error("should reject a content encryption outside the 3DS2 session phase")
+	}
+
+	// A message that is not direct mode must be rejected before any crypto.
+	const ecdhMsg = "eyJhbGciOiJFQ0RILUVTIiwiZW5jIjoiQTEyOENCQy1IUzI1NiIsImVwayI6eyJrdHkiOiJFQyIsImNydiI6IlAtMjU2IiwieCI6IkVKREVDVW9FUUpXLUFHT0hQcTM2RWdqYXpKZzFQaHg1dzlobjdTejdwYWciLCJ5IjoiZXRGcEdHd3BBZkd2aTN1b3lGUVRINmlmOXRIMmpEeEM1R2ozY0JYUHh3ZyJ9fQ..66WT0_iesb5eaya4MlbwtA.nZ06D6gzzRI--N8wVw-y2_DOmgM7jRks_0-ebP6sRxe12CW3yBZ15gfnt4MgH1Q7f0MdS9sGA8jZgsgXsTZUFeG3VYLiN5oMq4Arx5hrsczqqVTF5Q1pwbTSnTHozh71K3H7gBlNfthHogyA694M8o2QKpOS0-fBJu61HemHACw.TERLyyRLMgkk6xsOxsGlIg"
+
+	parsed, err := ParseEncrypted(ecdhMsg)
+	if err != nil {
+		t.Fatal("error on parse:", err)
+	}
+
+	if _, err := parsed.DecryptDirectoryServerSession(threeDSSessionKey); err == nil {
+		t.Error("should reject a message that is not in direct mode")
+	}
+}
+
+// An ACS integrator decrypts an incoming CReq with the exported 3DS2
+// derivation: construct it from the directory server ID and pass it to
+// DecryptWithCustomCek together with the ACS ephemeral private key.
+func ExampleNewDirectoryServerECDHES() {
+	creq := "eyJhbGciOiJFQ0RILUVTIiwiZW5jIjoiQTEyOENCQy1IUzI1NiIsImVwayI6eyJrdHkiOiJFQyIsImNydiI6IlAtMjU2IiwieCI6IkVKREVDVW9FUUpXLUFHT0hQcTM2RWdqYXpKZzFQaHg1dzlobjdTejdwYWciLCJ5IjoiZXRGcEdHd3BBZkd2aTN1b3lGUVRINmlmOXRIMmpEeEM1R2ozY0JYUHh3ZyJ9fQ..66WT0_iesb5eaya4MlbwtA.nZ06D6gzzRI--N8wVw-y2_DOmgM7jRks_0-ebP6sRxe12CW3yBZ15gfnt4MgH1Q7f0MdS9sGA8jZgsgXsTZUFeG3VYLiN5oMq4Arx5hrsczqqVTF5Q1pwbTSnTHozh71K3H7gBlNfthHogyA694M8o2QKpOS0-fBJu61HemHACw.TERLyyRLMgkk6xsOxsGlIg"
+
+	parsed, err := ParseEncrypted(creq)
+	if err != nil {
+		panic(err)
+	}
+
+	derive := NewDirectoryServerECDHES("A000000802")
+
+	plaintext, err := parsed.DecryptWithCustomCek(threeDSACSKey, derive)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(string(plaintext))
+	// Output: {"messageType":"CReq","messageVersion":"2.1.0","acsTransID":"6d2071d2-a1d4-4a40-a77a-51ba32bdd5f0","challengeWindowSize":"05"}
+}